@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// keyLimiter caps the new pages a middleware stores per window, see
+// Options.MaxNewKeys.
+type keyLimiter struct {
+	max    int
+	window time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	start time.Time
+	count int
+}
+
+func newKeyLimiter(options Options) *keyLimiter {
+	return &keyLimiter{max: options.MaxNewKeys, window: options.NewKeysWindow, now: options.Now}
+}
+
+// admit reports whether a new page may be stored, counting it if so.
+func (l *keyLimiter) admit() bool {
+	if l.max <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now := l.now(); now.Sub(l.start) >= l.window {
+		l.start, l.count = now, 0
+	}
+	if l.count >= l.max {
+		return false
+	}
+	l.count++
+	return true
+}
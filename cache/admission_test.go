@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCachePage_MaxNewKeys(t *testing.T) {
+	clock := newFakeClock()
+	r := newCountingRouter(NewInMemoryStore(time.Hour), Options{
+		Now:           clock.Now,
+		MaxNewKeys:    2,
+		NewKeysWindow: time.Minute,
+	})
+	expectBody(t, performRequest(r, "GET", "/page?1"), "1")
+	expectBody(t, performRequest(r, "GET", "/page?2"), "2")
+	// Past the limit, new pages aren't stored.
+	expectBody(t, performRequest(r, "GET", "/page?3"), "3")
+	expectBody(t, performRequest(r, "GET", "/page?3"), "4")
+	// The stored ones are still served.
+	expectBody(t, performRequest(r, "GET", "/page?1"), "1")
+
+	clock.Advance(time.Minute)
+	expectBody(t, performRequest(r, "GET", "/page?3"), "5")
+	expectBody(t, performRequest(r, "GET", "/page?3"), "5")
+}
+
+func TestCachePage_Admission(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	store.SetAdmission(2)
+	var errs []error
+	r := newCountingRouter(store, Options{
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+
+	// Keys requested once are never stored.
+	for i := 0; i < 10; i++ {
+		performRequest(r, "GET", fmt.Sprintf("/page?r=%d", i))
+	}
+	if n := store.Len(); n != 0 {
+		t.Errorf("Expected keys requested once not to be admitted, got %d entries", n)
+	}
+
+	// A key requested twice is.
+	expectBody(t, performRequest(r, "GET", "/page"), "11")
+	expectBody(t, performRequest(r, "GET", "/page"), "12")
+	expectBody(t, performRequest(r, "GET", "/page"), "12")
+	if n := store.Len(); n != 1 {
+		t.Errorf("Expected the repeated key to be admitted, got %d entries", n)
+	}
+	if len(errs) != 0 {
+		t.Errorf("Expected the keys not admitted not to be reported, got %v", errs)
+	}
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"strings"
+	"time"
+)
+
+// BatchEntry is an entry written by SetMany.
+type BatchEntry struct {
+	Key    string
+	Value  interface{}
+	Expire time.Duration
+}
+
+// BatchStore is implemented by stores able to read and write several keys in
+// a single round trip, e.g. RedisStore.
+type BatchStore interface {
+	// GetMany decodes the value of each keys[i] into the pointer values[i].
+	// Missing keys are reported by a *MissingKeysError, the values of the
+	// other keys being decoded anyway.
+	GetMany(keys []string, values []interface{}) error
+	// SetMany stores every entry. It stops at the first error, so part of
+	// the entries may have been stored.
+	SetMany(entries ...BatchEntry) error
+}
+
+// MissingKeysError is returned by GetMany when some of the keys aren't in the
+// store. It matches ErrCacheMiss with errors.Is.
+type MissingKeysError struct {
+	Keys []string
+}
+
+func (e *MissingKeysError) Error() string {
+	return "cache: keys not found: " + strings.Join(e.Keys, ", ")
+}
+
+func (e *MissingKeysError) Is(target error) bool {
+	return target == ErrCacheMiss
+}
+
+// GetMany decodes the value of each keys[i] into the pointer values[i], in a
+// single round trip if store is a BatchStore and key by key otherwise. It
+// returns a *MissingKeysError if some keys are missing.
+func GetMany(store CacheStore, keys []string, values []interface{}) error {
+	if len(keys) != len(values) {
+		panic("cache: GetMany called with different numbers of keys and values")
+	}
+	if batch, ok := store.(BatchStore); ok {
+		return batch.GetMany(keys, values)
+	}
+	var missing []string
+	for i, key := range keys {
+		switch err := store.Get(key, values[i]); err {
+		case nil:
+		case ErrCacheMiss:
+			missing = append(missing, key)
+		default:
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingKeysError{missing}
+	}
+	return nil
+}
+
+// SetMany stores every entry, in a single round trip if store is a BatchStore
+// and entry by entry otherwise.
+func SetMany(store CacheStore, entries ...BatchEntry) error {
+	if batch, ok := store.(BatchStore); ok {
+		return batch.SetMany(entries...)
+	}
+	for _, entry := range entries {
+		if err := store.Set(entry.Key, entry.Value, entry.Expire); err != nil {
+			return err
+		}
+	}
+	return nil
+}
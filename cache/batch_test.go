@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// batchCalls records the calls reaching a BatchStore.
+type batchCalls struct {
+	CacheStore
+	gets, sets int
+}
+
+func (s *batchCalls) GetMany(keys []string, values []interface{}) error {
+	s.gets++
+	return GetMany(s.CacheStore, keys, values)
+}
+
+func (s *batchCalls) SetMany(entries ...BatchEntry) error {
+	s.sets++
+	return SetMany(s.CacheStore, entries...)
+}
+
+// batchEquivalence checks that GetMany and SetMany on store behave like the
+// key by key operations on an InMemoryStore.
+func batchEquivalence(t *testing.T, newCache cacheFactory) {
+	batch := newCache(t, time.Hour)
+	sequential := NewInMemoryStore(time.Hour)
+	entries := []BatchEntry{
+		{"string", "foo", DEFAULT},
+		{"int", 42, time.Minute},
+		{"page", ResponseCache{Status: 200, Data: []byte("body")}, FOREVER},
+	}
+	if err := SetMany(batch, entries...); err != nil {
+		t.Fatalf("Error setting the entries: %s", err)
+	}
+	for _, entry := range entries {
+		sequential.Set(entry.Key, entry.Value, entry.Expire)
+	}
+
+	keys := []string{"string", "missing", "int", "page"}
+	get := func(store CacheStore, many bool) ([]interface{}, error) {
+		var s string
+		var i int
+		var page ResponseCache
+		values := []interface{}{&s, new(string), &i, &page}
+		if many {
+			return values, GetMany(store, keys, values)
+		}
+		var missing []string
+		for j, key := range keys {
+			if err := store.Get(key, values[j]); err == ErrCacheMiss {
+				missing = append(missing, key)
+			}
+		}
+		return values, &MissingKeysError{missing}
+	}
+	batchValues, batchErr := get(batch, true)
+	sequentialValues, sequentialErr := get(sequential, false)
+	if !reflect.DeepEqual(batchValues, sequentialValues) {
+		t.Errorf("Expected the same values, got %v and %v", batchValues, sequentialValues)
+	}
+	if !errors.Is(batchErr, ErrCacheMiss) || !reflect.DeepEqual(batchErr, sequentialErr) {
+		t.Errorf("Expected the missing keys to be reported, got %v", batchErr)
+	}
+}
+
+func TestBatch_Fallback(t *testing.T) {
+	batchEquivalence(t, newInMemoryStore)
+}
+
+func TestBatch_BatchStore(t *testing.T) {
+	store := &batchCalls{CacheStore: NewInMemoryStore(time.Hour)}
+	if err := SetMany(store, BatchEntry{"a", 1, DEFAULT}, BatchEntry{"b", 2, DEFAULT}); err != nil {
+		t.Fatalf("Error setting the entries: %s", err)
+	}
+	var a, b int
+	if err := GetMany(store, []string{"a", "b"}, []interface{}{&a, &b}); err != nil || a != 1 || b != 2 {
+		t.Errorf("Expected 1 and 2, got %d and %d: %v", a, b, err)
+	}
+	if store.gets != 1 || store.sets != 1 {
+		t.Errorf("Expected the batch methods to be used, got %d gets and %d sets", store.gets, store.sets)
+	}
+}
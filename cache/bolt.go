@@ -0,0 +1,309 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket is the bucket holding the entries of a BoltStore.
+var boltBucket = []byte("cache")
+
+// BoltStore is a CacheStore persisted in a BoltDB file, so a single instance
+// keeps its cache across restarts without running a cache server. Values are
+// serialized with gob unless another Codec is given, each prefixed with its
+// expiration time, which is checked on read. Expired entries are removed by a
+// background janitor until the store is closed.
+//
+// BoltDB allows many concurrent readers but a single writer: Get and TTL run
+// concurrently, while the other methods are serialized, each committing its
+// own transaction to disk. The file is locked while the store is open, so it
+// can't be shared between processes.
+type BoltStore struct {
+	db                *bolt.DB
+	defaultExpiration time.Duration
+	codec             Codec
+	stop              chan struct{}
+
+	clock   sync.RWMutex
+	timeNow func() time.Time
+}
+
+// NewBoltStore opens, or creates, the BoltDB file at path.
+func NewBoltStore(path string, defaultExpiration time.Duration) (*BoltStore, error) {
+	return NewBoltStoreWithCodec(path, defaultExpiration, GobCodec{})
+}
+
+// NewBoltStoreWithCodec is like NewBoltStore, encoding the values with codec
+// instead of gob.
+func NewBoltStoreWithCodec(path string, defaultExpiration time.Duration, codec Codec) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	store := &BoltStore{db: db, defaultExpiration: defaultExpiration, codec: codec, stop: make(chan struct{}), timeNow: time.Now}
+	go store.janitor(sweepInterval)
+	return store, nil
+}
+
+// SetClock sets the time source the entries expire from, time.Now by
+// default, so tests can expire entries without sleeping. It should be set
+// before the store is used.
+func (c *BoltStore) SetClock(now func() time.Time) {
+	c.clock.Lock()
+	defer c.clock.Unlock()
+	c.timeNow = now
+}
+
+// now returns the current time of the clock.
+func (c *BoltStore) now() time.Time {
+	c.clock.RLock()
+	defer c.clock.RUnlock()
+	return c.timeNow()
+}
+
+// Close stops the janitor and closes the database.
+func (c *BoltStore) Close() error {
+	close(c.stop)
+	return c.db.Close()
+}
+
+func (c *BoltStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.deleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *BoltStore) deleteExpired() error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		now := c.now()
+		bucket := tx.Bucket(boltBucket)
+		var expired [][]byte
+		bucket.ForEach(func(k, v []byte) error {
+			if boltExpired(v, now) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *BoltStore) Get(key string, value interface{}) error {
+	var data []byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		v, found := boltLookup(tx, key, c.now())
+		if !found {
+			return ErrCacheMiss
+		}
+		// v is only valid during the transaction.
+		data = append([]byte(nil), v[8:]...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.codec.Unmarshal(data, value)
+}
+
+// TTL returns the time left before key expires, or FOREVER.
+func (c *BoltStore) TTL(key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := c.db.View(func(tx *bolt.Tx) error {
+		v, found := boltLookup(tx, key, c.now())
+		if !found {
+			return ErrCacheMiss
+		}
+		if expires := boltExpiration(v); expires.IsZero() {
+			ttl = FOREVER
+		} else {
+			ttl = expires.Sub(c.now())
+		}
+		return nil
+	})
+	return ttl, err
+}
+
+func (c *BoltStore) Set(key string, value interface{}, expires time.Duration) error {
+	return c.store(key, value, expires, func(bool) bool { return true })
+}
+
+func (c *BoltStore) Add(key string, value interface{}, expires time.Duration) error {
+	return c.store(key, value, expires, func(found bool) bool { return !found })
+}
+
+func (c *BoltStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return c.store(key, value, expires, func(found bool) bool { return found })
+}
+
+// store writes value at key, when allowed reports true for whether key
+// currently holds a live entry.
+func (c *BoltStore) store(key string, value interface{}, expires time.Duration, allowed func(found bool) bool) error {
+	data, err := c.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		_, found := boltLookup(tx, key, c.now())
+		if !allowed(found) {
+			return ErrNotStored
+		}
+		return tx.Bucket(boltBucket).Put([]byte(key), boltValue(c.expiration(expires), data))
+	})
+}
+
+func (c *BoltStore) expiration(expires time.Duration) time.Time {
+	if expires == DEFAULT {
+		expires = c.defaultExpiration
+	}
+	if expires <= 0 {
+		return time.Time{}
+	}
+	return c.now().Add(expires)
+}
+
+func (c *BoltStore) Delete(key string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if _, found := boltLookup(tx, key, c.now()); !found {
+			return ErrCacheMiss
+		}
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+func (c *BoltStore) Increment(key string, n uint64) (uint64, error) {
+	return c.add(key, func(current uint64) uint64 {
+		return current + n
+	})
+}
+
+func (c *BoltStore) Decrement(key string, n uint64) (uint64, error) {
+	return c.add(key, func(current uint64) uint64 {
+		if n > current {
+			return 0
+		}
+		return current - n
+	})
+}
+
+// add replaces the integer stored at key with op applied to it, keeping its
+// expiration. Integers are stored as text by the codecs.
+func (c *BoltStore) add(key string, op func(uint64) uint64) (uint64, error) {
+	var result uint64
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		v, found := boltLookup(tx, key, c.now())
+		if !found {
+			return ErrCacheMiss
+		}
+		current, err := strconv.ParseUint(string(v[8:]), 10, 64)
+		if err != nil {
+			return ErrNotSupport
+		}
+		result = op(current)
+		data := []byte(strconv.FormatUint(result, 10))
+		return tx.Bucket(boltBucket).Put([]byte(key), boltValue(boltExpiration(v), data))
+	})
+	return result, err
+}
+
+// Flush drops the bucket holding the entries and creates it again.
+func (c *BoltStore) Flush() error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(boltBucket)
+		return err
+	})
+}
+
+// FlushPrefix removes all the keys starting with prefix.
+func (c *BoltStore) FlushPrefix(prefix string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		p := []byte(prefix)
+		cursor := tx.Bucket(boltBucket).Cursor()
+		for k, _ := cursor.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = cursor.Seek(p) {
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Keys returns the live keys starting with prefix.
+func (c *BoltStore) Keys(prefix string) ([]string, error) {
+	var keys []string
+	err := c.db.View(func(tx *bolt.Tx) error {
+		now := c.now()
+		p := []byte(prefix)
+		cursor := tx.Bucket(boltBucket).Cursor()
+		for k, v := cursor.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = cursor.Next() {
+			if !boltExpired(v, now) {
+				keys = append(keys, string(k))
+			}
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// boltLookup returns the value stored at key if it is live at now.
+func boltLookup(tx *bolt.Tx, key string, now time.Time) ([]byte, bool) {
+	v := tx.Bucket(boltBucket).Get([]byte(key))
+	if v == nil || boltExpired(v, now) {
+		return nil, false
+	}
+	return v, true
+}
+
+// boltValue prefixes data with the expiration time, in unix nanoseconds or 0
+// when the entry never expires.
+func boltValue(expires time.Time, data []byte) []byte {
+	v := make([]byte, 8+len(data))
+	if !expires.IsZero() {
+		binary.BigEndian.PutUint64(v, uint64(expires.UnixNano()))
+	}
+	copy(v[8:], data)
+	return v
+}
+
+func boltExpiration(v []byte) time.Time {
+	if len(v) < 8 {
+		return time.Time{}
+	}
+	nanos := binary.BigEndian.Uint64(v)
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(nanos))
+}
+
+func boltExpired(v []byte, now time.Time) bool {
+	expires := boltExpiration(v)
+	return !expires.IsZero() && now.After(expires)
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var newBoltStore = func(t *testing.T, defaultExpiration time.Duration) CacheStore {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "cache.db"), defaultExpiration)
+	if err != nil {
+		t.Fatalf("Error opening the store: %s", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestBoltStore_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newBoltStore)
+}
+
+func TestBoltStore_IncrDecr(t *testing.T) {
+	incrDecr(t, newBoltStore)
+}
+
+func TestBoltStore_Expiration(t *testing.T) {
+	expiration(t, newBoltStore)
+}
+
+func TestBoltStore_ExpirationConstants(t *testing.T) {
+	expirationConstants(t, newBoltStore)
+}
+
+func TestBoltStore_EmptyCache(t *testing.T) {
+	emptyCache(t, newBoltStore)
+}
+
+func TestBoltStore_Replace(t *testing.T) {
+	testReplace(t, newBoltStore)
+}
+
+func TestBoltStore_Add(t *testing.T) {
+	testAdd(t, newBoltStore)
+}
+
+func TestBoltStore_TTL(t *testing.T) {
+	testTTL(t, newBoltStore)
+}
+
+func TestBoltStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	store, err := NewBoltStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Error opening the store: %s", err)
+	}
+	store.Set("value", "foo", DEFAULT)
+	store.Set("short", "bar", 100*time.Millisecond)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Error closing the store: %s", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	store, err = NewBoltStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("Error reopening the store: %s", err)
+	}
+	defer store.Close()
+	var value string
+	if err := store.Get("value", &value); err != nil || value != "foo" {
+		t.Errorf("Expected the entry to persist across restarts, got %q: %v", value, err)
+	}
+	if err := store.Get("short", &value); err != ErrCacheMiss {
+		t.Errorf("Expected the expired entry not to be returned, got: %v", err)
+	}
+}
+
+func TestBoltStore_DeleteExpired(t *testing.T) {
+	clock := newFakeClock()
+	store := newBoltStore(t, time.Hour).(*BoltStore)
+	store.SetClock(clock.Now)
+	store.Set("short", 1, time.Minute)
+	store.Set("long", 1, DEFAULT)
+	clock.Advance(2 * time.Minute)
+	if err := store.deleteExpired(); err != nil {
+		t.Fatalf("Error deleting the expired entries: %s", err)
+	}
+
+	count := 0
+	store.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(boltBucket).Stats().KeyN
+		return nil
+	})
+	if count != 1 {
+		t.Errorf("Expected only the live entry to be kept, got %d entries", count)
+	}
+}
+
+func TestBoltStore_Clock(t *testing.T) {
+	clock := newFakeClock()
+	store := newBoltStore(t, time.Hour).(*BoltStore)
+	store.SetClock(clock.Now)
+	store.Set("a", 1, time.Minute)
+	store.Set("b", 1, time.Hour)
+
+	clock.Advance(20 * time.Second)
+	if ttl, err := store.TTL("a"); err != nil || ttl != 40*time.Second {
+		t.Errorf("Expected 40s left, got %s: %v", ttl, err)
+	}
+	clock.Advance(41 * time.Second)
+	var i int
+	if err := store.Get("a", &i); err != ErrCacheMiss {
+		t.Errorf("Expected the entry to expire on the clock, got %v", err)
+	}
+	if err := store.Add("a", 2, DEFAULT); err != nil {
+		t.Errorf("Expected to add over an expired entry, got %v", err)
+	}
+	if keys, err := store.Keys(""); err != nil || len(keys) != 2 {
+		t.Errorf("Expected the live keys only, got %v: %v", keys, err)
+	}
+}
+
+func TestBoltStore_Flush(t *testing.T) {
+	store := newBoltStore(t, time.Hour).(*BoltStore)
+	store.Set("page:a", 1, DEFAULT)
+	store.Set("page:b", 1, DEFAULT)
+	store.Set("other", 1, DEFAULT)
+
+	var i int
+	if err := store.FlushPrefix("page:"); err != nil {
+		t.Fatalf("Error flushing the prefix: %s", err)
+	}
+	if err := store.Get("page:a", &i); err != ErrCacheMiss {
+		t.Errorf("Expected the prefixed keys to be removed, got: %v", err)
+	}
+	if err := store.Get("other", &i); err != nil {
+		t.Errorf("Expected the other keys to be kept, got: %v", err)
+	}
+
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	if err := store.Get("other", &i); err != ErrCacheMiss {
+		t.Errorf("Expected the store to be empty, got: %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Operation categories of a CircuitBreakerStore, each with its own breaker.
+const (
+	breakerRead = iota
+	breakerWrite
+	breakerCategories
+)
+
+// CircuitBreakerStore protects the application from an unhealthy store, e.g.
+// a flapping redis server. Once threshold consecutive calls of a category,
+// reads or writes, have failed, the following calls of that category return
+// ErrCircuitOpen right away, without reaching the store, for the cooldown.
+// A single call then probes the store, closing the breaker if it succeeds or
+// opening it for another cooldown otherwise. Misses and the other errors of
+// the cache contract aren't failures.
+//
+// The middlewares fail open on the short-circuited calls, serving the
+// requests from the handlers as misses. Unlike the CircuitBreaker policy of a
+// middleware, the breaker is shared by everything using the store.
+type CircuitBreakerStore struct {
+	store     CacheStore
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	now      func() time.Time
+	breakers [breakerCategories]breaker
+}
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// NewCircuitBreakerStore returns a store passing the calls on to store until
+// threshold consecutive calls failed, then short-circuiting them for
+// cooldown. A zero threshold or cooldown defaults to 5 and 30 seconds.
+func NewCircuitBreakerStore(store CacheStore, threshold int, cooldown time.Duration) *CircuitBreakerStore {
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+	return &CircuitBreakerStore{store: store, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// SetClock sets the time source of the cooldown, time.Now by default. It
+// should be set before the store is used.
+func (s *CircuitBreakerStore) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// call runs op unless the breaker of category is open.
+func (s *CircuitBreakerStore) call(category int, op func() error) error {
+	if !s.allow(category) {
+		return ErrCircuitOpen
+	}
+	err := op()
+	s.done(category, err)
+	return err
+}
+
+// allow reports whether a call of category may reach the store, letting a
+// single probe through once the cooldown is over.
+func (s *CircuitBreakerStore) allow(category int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &s.breakers[category]
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if b.probing || s.now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// done records the outcome of a call of category.
+func (s *CircuitBreakerStore) done(category int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &s.breakers[category]
+	if !breakerFailure(err) {
+		*b = breaker{}
+		return
+	}
+	b.failures++
+	if b.probing || b.failures >= s.threshold {
+		*b = breaker{openUntil: s.now().Add(s.cooldown)}
+	}
+}
+
+// breakerFailure reports whether err tells the store is unhealthy, as
+// opposed to the outcomes of the cache contract and to the client giving up.
+func breakerFailure(err error) bool {
+	return err != nil && !errors.Is(err, ErrCacheMiss) && err != ErrNotStored && err != ErrNotAdmitted && err != ErrNotSupport && err != ErrCircuitOpen && !errors.Is(err, context.Canceled)
+}
+
+func (s *CircuitBreakerStore) Get(key string, value interface{}) error {
+	return s.GetContext(context.Background(), key, value)
+}
+
+func (s *CircuitBreakerStore) Set(key string, value interface{}, expires time.Duration) error {
+	return s.SetContext(context.Background(), key, value, expires)
+}
+
+func (s *CircuitBreakerStore) Add(key string, value interface{}, expires time.Duration) error {
+	return s.AddContext(context.Background(), key, value, expires)
+}
+
+func (s *CircuitBreakerStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.ReplaceContext(context.Background(), key, value, expires)
+}
+
+func (s *CircuitBreakerStore) Delete(key string) error {
+	return s.DeleteContext(context.Background(), key)
+}
+
+func (s *CircuitBreakerStore) Increment(key string, n uint64) (uint64, error) {
+	return s.IncrementContext(context.Background(), key, n)
+}
+
+func (s *CircuitBreakerStore) Decrement(key string, n uint64) (uint64, error) {
+	return s.DecrementContext(context.Background(), key, n)
+}
+
+func (s *CircuitBreakerStore) Flush() error {
+	return s.FlushContext(context.Background())
+}
+
+// GetContext is like Get, passing ctx on to the wrapped store, which is only
+// bounded by it if it implements ContextCacheStore. A call cancelled by ctx
+// isn't a failure, unlike one running past its deadline.
+func (s *CircuitBreakerStore) GetContext(ctx context.Context, key string, value interface{}) error {
+	return s.call(breakerRead, func() error { return withContext(s.store).GetContext(ctx, key, value) })
+}
+
+// SetContext is like Set, passing ctx on as GetContext does.
+func (s *CircuitBreakerStore) SetContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	return s.call(breakerWrite, func() error { return withContext(s.store).SetContext(ctx, key, value, expires) })
+}
+
+// AddContext is like Add, passing ctx on as GetContext does.
+func (s *CircuitBreakerStore) AddContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	return s.call(breakerWrite, func() error { return withContext(s.store).AddContext(ctx, key, value, expires) })
+}
+
+// ReplaceContext is like Replace, passing ctx on as GetContext does.
+func (s *CircuitBreakerStore) ReplaceContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	return s.call(breakerWrite, func() error { return withContext(s.store).ReplaceContext(ctx, key, value, expires) })
+}
+
+// DeleteContext is like Delete, passing ctx on as GetContext does.
+func (s *CircuitBreakerStore) DeleteContext(ctx context.Context, key string) error {
+	return s.call(breakerWrite, func() error { return withContext(s.store).DeleteContext(ctx, key) })
+}
+
+// IncrementContext is like Increment, passing ctx on as GetContext does.
+func (s *CircuitBreakerStore) IncrementContext(ctx context.Context, key string, n uint64) (uint64, error) {
+	var result uint64
+	err := s.call(breakerWrite, func() (err error) {
+		result, err = withContext(s.store).IncrementContext(ctx, key, n)
+		return err
+	})
+	return result, err
+}
+
+// DecrementContext is like Decrement, passing ctx on as GetContext does.
+func (s *CircuitBreakerStore) DecrementContext(ctx context.Context, key string, n uint64) (uint64, error) {
+	var result uint64
+	err := s.call(breakerWrite, func() (err error) {
+		result, err = withContext(s.store).DecrementContext(ctx, key, n)
+		return err
+	})
+	return result, err
+}
+
+// FlushContext is like Flush, passing ctx on as GetContext does.
+func (s *CircuitBreakerStore) FlushContext(ctx context.Context) error {
+	return s.call(breakerWrite, func() error { return withContext(s.store).FlushContext(ctx) })
+}
+
+// TTL returns the time left before key expires, or ErrNotSupport if the
+// wrapped store can't tell.
+func (s *CircuitBreakerStore) TTL(key string) (time.Duration, error) {
+	ttls, ok := ttlStore(s.store)
+	if !ok {
+		return 0, ErrNotSupport
+	}
+	var ttl time.Duration
+	err := s.call(breakerRead, func() (err error) {
+		ttl, err = ttls.TTL(key)
+		return err
+	})
+	return ttl, err
+}
+
+// FlushPrefix removes all the keys starting with prefix. It requires the
+// wrapped store to implement PrefixFlusher and returns ErrNotSupport
+// otherwise.
+func (s *CircuitBreakerStore) FlushPrefix(prefix string) error {
+	flusher, ok := s.store.(PrefixFlusher)
+	if !ok {
+		return ErrNotSupport
+	}
+	return s.call(breakerWrite, func() error { return flusher.FlushPrefix(prefix) })
+}
+
+// Keys returns the keys starting with prefix. It requires the wrapped store
+// to implement KeyLister and returns ErrNotSupport otherwise.
+func (s *CircuitBreakerStore) Keys(prefix string) ([]string, error) {
+	lister, ok := s.store.(KeyLister)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	var keys []string
+	err := s.call(breakerRead, func() (err error) {
+		keys, err = lister.Keys(prefix)
+		return err
+	})
+	return keys, err
+}
+
+// Tag adds key to the keys tagged with tag. It requires the wrapped store to
+// implement TagStore and returns ErrNotSupport otherwise.
+func (s *CircuitBreakerStore) Tag(tag string, key string, expire time.Duration) error {
+	tagger, ok := tagStore(s.store)
+	if !ok {
+		return ErrNotSupport
+	}
+	return s.call(breakerWrite, func() error { return tagger.Tag(tag, key, expire) })
+}
+
+// PopTag removes the keys tagged with tag and returns them. It requires the
+// wrapped store to implement TagStore and returns ErrNotSupport otherwise.
+func (s *CircuitBreakerStore) PopTag(tag string) ([]string, error) {
+	tagger, ok := tagStore(s.store)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	var keys []string
+	err := s.call(breakerWrite, func() (err error) {
+		keys, err = tagger.PopTag(tag)
+		return err
+	})
+	return keys, err
+}
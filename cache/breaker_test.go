@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStore_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, func(t *testing.T, defaultExpiration time.Duration) CacheStore {
+		return NewCircuitBreakerStore(NewInMemoryStore(defaultExpiration), 3, time.Minute)
+	})
+}
+
+func TestCircuitBreakerStore_Trip(t *testing.T) {
+	clock := newFakeClock()
+	flaky := &flakyStore{CacheStore: NewInMemoryStore(time.Minute)}
+	store := NewCircuitBreakerStore(flaky, 3, time.Minute)
+	store.SetClock(clock.Now)
+	var value string
+
+	// Misses aren't failures.
+	for i := 0; i < 5; i++ {
+		if err := store.Get("missing", &value); err != ErrCacheMiss {
+			t.Errorf("Expected ErrCacheMiss, got %v", err)
+		}
+	}
+
+	flaky.down = true
+	for i := 0; i < 3; i++ {
+		if err := store.Get("key", &value); err != errStoreDown {
+			t.Errorf("Expected the store error before tripping, got %v", err)
+		}
+	}
+	gets := flaky.gets
+	if err := store.Get("key", &value); err != ErrCircuitOpen {
+		t.Errorf("Expected ErrCircuitOpen once open, got %v", err)
+	}
+	if flaky.gets != gets {
+		t.Errorf("Expected the open breaker not to reach the store")
+	}
+	// Writes have their own breaker.
+	if err := store.Set("key", "value", DEFAULT); err != nil {
+		t.Errorf("Expected writes to reach the store, got %v", err)
+	}
+
+	// A failed probe opens the breaker again.
+	clock.Advance(time.Minute)
+	if err := store.Get("key", &value); err != errStoreDown {
+		t.Errorf("Expected the probe to reach the store, got %v", err)
+	}
+	if err := store.Get("key", &value); err != ErrCircuitOpen {
+		t.Errorf("Expected ErrCircuitOpen after a failed probe, got %v", err)
+	}
+
+	flaky.down = false
+	clock.Advance(time.Minute)
+	for i := 0; i < 2; i++ {
+		if err := store.Get("key", &value); err != nil || value != "value" {
+			t.Errorf("Expected the breaker to recover, got %q: %v", value, err)
+		}
+	}
+}
+
+func TestCachePage_CircuitBreakerStore(t *testing.T) {
+	flaky := &flakyStore{CacheStore: NewInMemoryStore(time.Minute), down: true}
+	store := NewCircuitBreakerStore(flaky, 2, time.Minute)
+	r := newCountingRouter(store, Options{})
+	for i := 1; i <= 4; i++ {
+		expectBody(t, performRequest(r, "GET", "/page"), fmt.Sprint(i))
+	}
+	if flaky.gets != 2 {
+		t.Errorf("Expected the lookups to be short-circuited once open, got %d lookups", flaky.gets)
+	}
+}
+
+func TestCachePage_OpenCircuitBreakerStore(t *testing.T) {
+	flaky := &flakyStore{CacheStore: NewInMemoryStore(time.Minute), down: true}
+	store := NewCircuitBreakerStore(flaky, 1, time.Minute)
+	var value string
+	store.Get("key", &value)
+
+	var errs []error
+	r := newCountingRouter(store, Options{
+		OnStoreError: FailClosed,
+		OnError:      func(err error) { errs = append(errs, err) },
+	})
+	for i := 1; i <= 3; i++ {
+		w := performRequest(r, "GET", "/page")
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected the open breaker to be a miss, got %d", w.Code)
+		}
+		expectBody(t, w, fmt.Sprint(i))
+	}
+	if len(errs) != 0 {
+		t.Errorf("Expected the short-circuited calls not to be reported, got %v", errs)
+	}
+}
+
+func TestCircuitBreakerStore_Context(t *testing.T) {
+	store := NewCircuitBreakerStore(blockingStore{contextStore{NewInMemoryStore(time.Minute)}}, 1, time.Minute)
+	s := withContext(store)
+	expectDeadline(t, "GetContext", func(ctx context.Context) error {
+		var value string
+		return s.GetContext(ctx, "key", &value)
+	})
+	expectDeadline(t, "SetContext", func(ctx context.Context) error {
+		return s.SetContext(ctx, "key", "value", DEFAULT)
+	})
+
+	// The deadlines opened the breakers, while a cancelled call doesn't.
+	var value string
+	if err := store.Get("key", &value); err != ErrCircuitOpen {
+		t.Errorf("Expected the deadline to count as a failure, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	store = NewCircuitBreakerStore(blockingStore{contextStore{NewInMemoryStore(time.Minute)}}, 1, time.Minute)
+	store.GetContext(ctx, "key", &value)
+	if err := store.GetContext(ctx, "key", &value); err != context.Canceled {
+		t.Errorf("Expected the cancellation not to open the breaker, got %v", err)
+	}
+}
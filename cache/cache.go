@@ -2,20 +2,44 @@ package cache
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"hash"
 	"io"
 	"net/http"
 	"net/url"
-	"strings"
+	"strconv"
 	"time"
 )
 
 const (
-	DEFAULT              = time.Duration(0)
+	// DEFAULT stores an entry with the default expiration of the store.
+	DEFAULT = time.Duration(0)
+	// FOREVER stores an entry without expiration. A store whose default
+	// expiration is FOREVER keeps DEFAULT entries forever as well.
 	FOREVER              = time.Duration(-1)
 	CACHE_MIDDLEWARE_KEY = "gincontrib.cache"
+	// CACHE_TTL_KEY is the context key handlers may set to a time.Duration
+	// to override the expiration of their response. FOREVER stores it without
+	// expiration and 0 doesn't store it.
+	CACHE_TTL_KEY = "cache-ttl"
+	// CACHE_KEY_KEY is the context key holding the store key of the page,
+	// see CacheKey.
+	CACHE_KEY_KEY = "gincontrib.cache.key"
+	// CACHE_TAGS_KEY is the context key handlers may set to a []string of
+	// tags, e.g. "product:42", to invalidate their response along with the
+	// other pages sharing a tag, see InvalidateTag. Tagging pages in a store
+	// not implementing TagStore reports ErrNotSupport to OnError.
+	CACHE_TAGS_KEY = "cache-tags"
+	// ROUTE_ERROR_TAG tags the error pages of gin's router stored per
+	// Options.RouteErrorExpire, to invalidate them with InvalidateTag once
+	// the routes change.
+	ROUTE_ERROR_TAG = "gin.route-error"
 )
 
 var (
@@ -23,6 +47,19 @@ var (
 	ErrCacheMiss    = errors.New("cache: key not found.")
 	ErrNotStored    = errors.New("cache: not stored.")
 	ErrNotSupport   = errors.New("cache: not support.")
+	ErrBodyTooLarge = errors.New("cache: body too large.")
+	// ErrUnknownCompressor is returned reading an entry compressed with a
+	// Compressor that isn't configured.
+	ErrUnknownCompressor = errors.New("cache: unknown compressor.")
+	// ErrDecrypt is returned by an EncryptedStore reading a value it can't
+	// decrypt, encrypted with an unknown key or altered.
+	ErrDecrypt = errors.New("cache: can't decrypt value.")
+	// ErrNotAdmitted is returned by a store declining to write a new key,
+	// see InMemoryStore.SetAdmission. The middlewares don't report it.
+	ErrNotAdmitted = errors.New("cache: not admitted.")
+	// ErrCircuitOpen is returned by a CircuitBreakerStore short-circuiting a
+	// call. The middlewares treat it as a miss and don't report it.
+	ErrCircuitOpen = errors.New("cache: circuit open.")
 )
 
 type CacheStore interface {
@@ -31,30 +68,106 @@ type CacheStore interface {
 	Add(key string, value interface{}, expire time.Duration) error
 	Replace(key string, data interface{}, expire time.Duration) error
 	Delete(key string) error
+	// Increment atomically adds data to the integer stored at key and
+	// returns the new value. It returns ErrCacheMiss if key isn't in the
+	// store rather than creating it. The value wraps around on overflow.
 	Increment(key string, data uint64) (uint64, error)
+	// Decrement atomically subtracts data from the integer stored at key and
+	// returns the new value, stopping at 0 as memcached does. It returns
+	// ErrCacheMiss if key isn't in the store.
 	Decrement(key string, data uint64) (uint64, error)
 	Flush() error
 }
 
-type responseCache struct {
+// ResponseCache is the cached page, as kept in the store. Stores that encode
+// values as interfaces should call RegisterGobTypes.
+type ResponseCache struct {
 	Status int
 	Header http.Header
 	Data   []byte
+	// Timestamp is the time the response was stored.
+	Timestamp time.Time
+	ETag      string
+	// Vary is only set on index entries, see lookupCache.
+	Vary []string
+	// FreshUntil and StaleUntil are set when stale-while-revalidate is
+	// enabled: past FreshUntil, the response is served while being refreshed.
+	FreshUntil time.Time
+	StaleUntil time.Time
+	// ErrorUntil is set when ServeStaleOnError or the stale-if-error directive
+	// of the response is: past FreshUntil and StaleUntil, the response is only
+	// served when the handler fails.
+	ErrorUntil time.Time
+	// Compressed is set when Data is compressed, see Options.Compress, and
+	// Encoding is the name of the Compressor, gzip if empty.
+	Compressed bool
+	Encoding   string
+	// Trailer holds the trailers sent after the body, if any.
+	Trailer http.Header
+	// remaining is the time left before the response expires, set on lookup
+	// when Options.SetMaxAgeHeader is true. It isn't stored.
+	remaining time.Duration
 }
 
 type cachedWriter struct {
 	gin.ResponseWriter
-	store  CacheStore
-	expire time.Duration
-	key    string
+	store    ContextCacheStore
+	expire   time.Duration
+	key      string
+	exchange *exchange
+	status   int
+	body     bytes.Buffer
+	failed   bool
+	// released is set once a buffered response has been sent to the client.
+	released bool
+	// committed is set once the status can't change anymore, as the handler
+	// started writing the body.
+	committed bool
+	options   Options
+	// writeBehind is set in write-behind mode, see Options.WriteBehind.
+	writeBehind writeBehind
+	// If replace is set, the page is only stored if it is still in the
+	// store, so a refresh doesn't bring back a page invalidated meanwhile.
+	replace bool
 }
 
+// defaultMaxKeyLength is the longest key built before hashing the url.
+const defaultMaxKeyLength = 200
+
+// urlEscape builds the store key "prefix:<escaped url>". URLs whose key would
+// be too long are replaced by the hex encoded sha1 of the url so the key
+// stays printable and within the limits of the network stores.
 func urlEscape(prefix string, u string) string {
+	return escapeKey(prefix, u, defaultMaxKeyLength, 0, nil)
+}
+
+// pageEscape is like urlEscape with the key limits and hash function of the
+// options.
+func pageEscape(prefix string, u string, options Options) string {
+	return escapeKey(prefix, u, options.MaxKeyLength, options.HashedKeyHint, options.HashFunc)
+}
+
+// escapeKey builds the key "prefix:<escaped url>", hashing the url when the
+// key is longer than max, in which case the hash follows the first hint bytes
+// of the escaped url. The url is hashed with sha1 when newHash is nil, and
+// otherwise with newHash, the hash being tagged with its size in bits, e.g.
+// "h256-", so the keys of different functions can't be mistaken for each
+// other.
+func escapeKey(prefix string, u string, max int, hint int, newHash func() hash.Hash) string {
 	key := url.QueryEscape(u)
-	if len(key) > 200 {
-		h := sha1.New()
+	if len(prefix)+1+len(key) > max {
+		tag := ""
+		if newHash == nil {
+			newHash = sha1.New
+		} else {
+			tag = "h" + strconv.Itoa(newHash().Size()*8) + "-"
+		}
+		h := newHash()
 		io.WriteString(h, u)
-		key = string(h.Sum(nil))
+		if hint > len(key) {
+			hint = len(key)
+		}
+		key = key[:hint] + tag + hex.EncodeToString(h.Sum(nil))
 	}
 	var buffer bytes.Buffer
 	buffer.WriteString(prefix)
@@ -63,26 +176,299 @@ func urlEscape(prefix string, u string) string {
 	return buffer.String()
 }
 
-func newCachedWriter(store CacheStore, expire time.Duration, writer gin.ResponseWriter, key string) *cachedWriter {
-	return &cachedWriter{writer, store, expire, key}
+// newCachedWriter wraps writer to store the response at key. The status
+// starts as the one of writer, which gin sets for its router errors before
+// running the handlers.
+func newCachedWriter(store ContextCacheStore, expire time.Duration, writer gin.ResponseWriter, key string, x *exchange, options Options) *cachedWriter {
+	return &cachedWriter{
+		ResponseWriter: writer,
+		store:          store,
+		expire:         expire,
+		key:            key,
+		exchange:       x,
+		status:         writer.Status(),
+		options:        options,
+	}
+}
+
+// WriteHeader sets the status of the response. Like the gin writer, which
+// logs a warning in that case, it ignores calls made once the body is being
+// written, so the stored status is the one the client received.
+func (w *cachedWriter) WriteHeader(code int) {
+	if !w.committed {
+		w.status = code
+	}
+	if !w.holding() {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+// WriteHeaderNow doesn't send a buffered response before the handler is done.
+func (w *cachedWriter) WriteHeaderNow() {
+	w.committed = true
+	if !w.holding() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+// Flush sends what the handler wrote so far. Flushing marks the response as
+// streamed, e.g. server-sent events, which isn't stored: the body written by
+// then is released in buffered mode and the rest is only passed through.
+func (w *cachedWriter) Flush() {
+	w.committed = true
+	if w.holding() {
+		w.release()
+	}
+	w.failed = true
+	w.body = bytes.Buffer{}
+	w.ResponseWriter.Flush()
 }
 
+// Status returns the status of the response, even if it isn't sent yet.
+func (w *cachedWriter) Status() int {
+	if w.holding() {
+		return w.status
+	}
+	return w.ResponseWriter.Status()
+}
+
+// Write passes data straight through to the client and keeps a copy of it, so
+// the complete body can be stored once the handler is done. In buffered mode
+// data is only kept until then.
 func (w *cachedWriter) Write(data []byte) (int, error) {
+	w.committed = true
+	if w.holding() && w.buffer(len(data)) {
+		return w.body.Write(data)
+	}
 	ret, err := w.ResponseWriter.Write(data)
-	if err == nil {
-		//cache response
-		store := w.store
-		val := responseCache{
-			w.Status(),
-			w.Header(),
-			data,
-		}
-		err = store.Set(w.key, val, w.expire)
+	if err != nil {
+		w.failed = true
+	}
+	if w.buffer(ret) {
+		w.body.Write(data[:ret])
+	}
+	return ret, err
+}
+
+// WriteString is the string counterpart of Write, used by io.WriteString.
+func (w *cachedWriter) WriteString(data string) (int, error) {
+	w.committed = true
+	if w.holding() && w.buffer(len(data)) {
+		return w.body.WriteString(data)
+	}
+	ret, err := w.ResponseWriter.WriteString(data)
+	if err != nil {
+		w.failed = true
+	}
+	if w.buffer(ret) {
+		w.body.WriteString(data[:ret])
+	}
+	return ret, err
+}
+
+// buffer reports whether n more bytes of body should be kept. Once the body
+// grows past MaxBodyBytes the response won't be stored, so the buffered bytes
+// are released and the rest is only passed through.
+func (w *cachedWriter) buffer(n int) bool {
+	if w.failed {
+		return false
+	}
+	if max := w.options.MaxBodyBytes; max > 0 && w.body.Len()+n > max {
+		if w.holding() {
+			w.release()
+		}
+		w.failed = true
+		w.body = bytes.Buffer{}
+		w.options.OnError(&CacheError{Op: "set", Key: w.key, Err: ErrBodyTooLarge})
+		return false
+	}
+	return true
+}
+
+// holding reports whether the response is buffered and not sent yet.
+func (w *cachedWriter) holding() bool {
+	return w.options.Buffered && !w.released
+}
+
+// release sends the buffered response to the client.
+func (w *cachedWriter) release() {
+	w.released = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.body.Len() > 0 {
+		if _, err := w.ResponseWriter.Write(w.body.Bytes()); err != nil {
+			w.failed = true
+		}
+	}
+}
+
+// finalize stores the buffered response, and sends it to the client in
+// buffered mode. It must be called once the handler has returned.
+func (w *cachedWriter) finalize() {
+	w.save()
+	if w.holding() {
+		w.release()
+	}
+}
+
+func (w *cachedWriter) save() {
+	if w.failed {
+		return
+	}
+	if w.status == http.StatusPartialContent || w.Header().Get("Content-Range") != "" {
+		// Partial bodies, e.g. served by http.ServeContent to range requests,
+		// can't be replayed as the page.
+		return
+	}
+	if w.options.SkipEmptyBody && w.body.Len() == 0 && w.status != http.StatusNoContent && w.exchange.r.Method != "HEAD" {
+		return
+	}
+	if w.options.CacheableContentType != nil && !w.options.CacheableContentType(mediaType(w.Header())) {
+		return
+	}
+	expire := w.expire
+	if routeError(w.exchange, w.status, w.options) {
+		expire = w.options.RouteErrorExpire
+	} else if ttl, ok := w.options.TTLByStatus[w.status]; ok {
+		if ttl == 0 {
+			return
+		}
+		expire = ttl
+	} else if !w.options.CacheableStatus(w.status) {
+		if w.options.NegativeExpire <= 0 || !containsStatus(w.options.NegativeStatus, w.status) {
+			return
+		}
+		expire = w.options.NegativeExpire
+	}
+	expire, ok := responseExpire(w.Header(), expire)
+	if !ok || (!w.options.AllowSetCookie && setsCookie(w.Header())) {
+		return
+	}
+	if ttl, found := w.exchange.c.Get(CACHE_TTL_KEY); found {
+		if ttl, ok := ttl.(time.Duration); ok {
+			if ttl == 0 {
+				return
+			}
+			expire = ttl
+		}
+	}
+	expire = jitter(expire, w.options)
+	data := w.body.Bytes()
+	if w.options.TransformOnStore != nil {
+		data = w.options.TransformOnStore(w.exchange.c, data)
+	}
+	val := ResponseCache{
+		Status:    w.status,
+		Header:    storedHeader(w.Header(), w.options),
+		Trailer:   storedTrailer(w.Header(), w.options),
+		Data:      data,
+		Timestamp: w.options.Now(),
+		ETag:      w.Header().Get("ETag"),
+	}
+	grace := staleIfError(w.Header(), w.options.ServeStaleOnError)
+	if (w.options.StaleWhileRevalidate > 0 || grace > 0) && expire > 0 {
+		val.FreshUntil = val.Timestamp.Add(expire)
+		val.StaleUntil = val.FreshUntil.Add(w.options.StaleWhileRevalidate)
+		val.ErrorUntil = val.FreshUntil.Add(grace)
+		if w.options.StaleWhileRevalidate > grace {
+			expire += w.options.StaleWhileRevalidate
+		} else {
+			expire += grace
+		}
+	}
+	if w.options.LastModified && val.Header.Get("Last-Modified") == "" {
+		val.Header.Set("Last-Modified", val.Timestamp.UTC().Format(http.TimeFormat))
+	}
+	if val.ETag == "" && w.options.ETag {
+		val.ETag = newETag(data, w.options.HashFunc)
+	}
+	if compressible(val.Header, len(data), w.options) {
+		compressed, err := w.options.Compressor.Compress(data)
 		if err != nil {
-			// need logger
+			w.fail(err)
+			return
 		}
+		val.Data, val.Compressed, val.Encoding = compressed, true, w.options.Compressor.Name()
 	}
-	return ret, err
+	w.set(val, expire)
+}
+
+// fail reports an error storing the response.
+func (w *cachedWriter) fail(err error) {
+	err = &CacheError{Op: "set", Key: w.key, Err: err}
+	w.options.Metrics.Error("set", err)
+	w.options.OnError(err)
+}
+
+// set stores the response, along with a Vary index entry if the response
+// varies by request headers. The keys are derived from the request right
+// away, while the writes run in the background in write-behind mode.
+func (w *cachedWriter) set(val ResponseCache, expire time.Duration) {
+	names := varyHeaders(w.Header())
+	var entryKey string
+	for _, name := range names {
+		if name == "*" {
+			// The response can't be matched to future requests.
+			return
+		}
+	}
+	if len(names) > 0 {
+		entryKey = varyKey(w.key, names, w.exchange.r, w.options)
+	}
+	tags := contextTags(w.exchange.c)
+	if w.options.ObserveOnly {
+		// Nothing is stored, so there is no page to tag.
+		tags = nil
+	} else if _, ok := tagStore(w.store); ok && routeError(w.exchange, w.status, w.options) {
+		tags = append(tags[:len(tags):len(tags)], ROUTE_ERROR_TAG)
+	}
+	write := func(ctx context.Context) {
+		store := w.store.SetContext
+		if w.replace {
+			store = w.store.ReplaceContext
+		}
+		var err error
+		if len(names) == 0 {
+			err = store(ctx, w.key, val, expire)
+		} else if err = store(ctx, w.key, ResponseCache{Vary: names}, expire); err == nil {
+			err = w.store.SetContext(ctx, entryKey, val, expire)
+		}
+		if err == nil && len(tags) > 0 {
+			err = tagPage(w.store, w.key, tags, expire, w.options)
+		}
+		if err == ErrNotStored && w.replace || err == ErrNotAdmitted || err == ErrCircuitOpen {
+			// The page was removed meanwhile, or the store skips it
+			// deliberately.
+			return
+		}
+		if err != nil {
+			w.fail(err)
+			return
+		}
+		w.options.Metrics.Store(w.key)
+	}
+	if w.writeBehind == nil {
+		write(w.exchange.r.Context())
+		return
+	}
+	// The request, along with its context, is over by the time the
+	// response is stored.
+	val.Data = append([]byte(nil), val.Data...)
+	w.writeBehind.run(func() { write(context.Background()) })
+}
+
+// RegisterGobTypes registers the values stored by the middlewares with gob,
+// for stores encoding them as interface values.
+func RegisterGobTypes() {
+	gob.Register(ResponseCache{})
+}
+
+func containsStatus(statuses []int, status int) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
 }
 
 // Cache Middleware
@@ -93,6 +479,18 @@ func Cache(store CacheStore) gin.HandlerFunc {
 	}
 }
 
+// CacheFunc is like Cache, with the store of each request returned by
+// resolver, e.g. to give every tenant its own store. Requests for which
+// resolver returns nil get no store, so Cached doesn't cache them.
+func CacheFunc(resolver func(*gin.Context) CacheStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if store := resolver(c); store != nil {
+			c.Set(CACHE_MIDDLEWARE_KEY, store)
+		}
+		c.Next()
+	}
+}
+
 func GetCache(c *gin.Context) (CacheStore, bool) {
 	if store, ok := c.Get(CACHE_MIDDLEWARE_KEY); ok {
 		return store.(CacheStore), true
@@ -105,77 +503,499 @@ func MustGetCache(c *gin.Context) CacheStore {
 	return c.MustGet(CACHE_MIDDLEWARE_KEY).(CacheStore)
 }
 
+// CacheKey returns the store key of the page requested in c, as computed by
+// the page cache middleware serving the request, e.g. for logging.
+func CacheKey(c *gin.Context) (string, bool) {
+	key, ok := c.Get(CACHE_KEY_KEY)
+	if !ok {
+		return "", false
+	}
+	s, ok := key.(string)
+	return s, ok
+}
+
+// fetchCache looks up the cached response for the request and reports
+// whether it was found. Store failures other than a miss, or an open circuit
+// breaker, are passed on to the OnError option and returned as a *CacheError.
+func fetchCache(store ContextCacheStore, key string, r *http.Request, cache *ResponseCache, options Options) (bool, error) {
+	err := lookupCache(store, key, r, cache, options)
+	if err == nil && options.SetMaxAgeHeader {
+		cache.remaining = remainingTTL(store, key, cache, options.Now())
+	}
+	if err == nil && cache.Compressed && (!acceptsEncoding(r, cache.encoding()) || options.TransformOnServe != nil) {
+		err = decompress(cache, options)
+	}
+	switch err {
+	case nil:
+		return true, nil
+	case ErrCacheMiss, ErrCircuitOpen:
+		return false, nil
+	}
+	err = &CacheError{Op: "get", Key: key, Err: err}
+	options.Metrics.Error("get", err)
+	options.OnError(err)
+	return false, err
+}
+
+// setEntityHeaders sets the headers describing the cached body.
+func setEntityHeaders(w http.ResponseWriter, cache *ResponseCache) {
+	if cache.ETag != "" {
+		w.Header().Set("ETag", cache.ETag)
+	}
+	if cache.Status == http.StatusOK && !cache.Compressed {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+	if cache.Compressed {
+		w.Header().Set("Content-Encoding", cache.encoding())
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+}
+
+// setContentLength sets Content-Length to the length of the cached body as
+// served, replacing the stored one, which is wrong once the body has been
+// transformed or compressed. It is removed from the responses without a
+// body, and from those with trailers, which are sent chunked.
+func setContentLength(w http.ResponseWriter, cache *ResponseCache) {
+	if cache.Status < http.StatusOK || cache.Status == http.StatusNoContent || cache.Status == http.StatusNotModified || len(cache.Trailer) > 0 {
+		w.Header().Del("Content-Length")
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(cache.Data)))
+}
+
+// setCacheStatusHeaders adds the informational X-Cache and Age headers
+// enabled in the options. A nil cache stands for a miss.
+func setCacheStatusHeaders(w http.ResponseWriter, cache *ResponseCache, options Options) {
+	if cache == nil {
+		if options.SetXCacheHeader {
+			w.Header().Set("X-Cache", "MISS")
+		}
+		return
+	}
+	if options.SetXCacheHeader {
+		w.Header().Set("X-Cache", "HIT")
+	}
+	if options.SetAgeHeader && !cache.Timestamp.IsZero() {
+		age := options.Now().Sub(cache.Timestamp) / time.Second
+		w.Header().Set("Age", strconv.FormatInt(int64(age), 10))
+	}
+	if options.SetMaxAgeHeader && cache.remaining > 0 {
+		maxAge := cache.remaining / time.Second
+		w.Header().Set("Cache-Control", "max-age="+strconv.FormatInt(int64(maxAge), 10))
+	}
+}
+
+// writeCachedResponse serves a cached response to the client. The handler
+// isn't run then, so nothing else writes to the response.
+func writeCachedResponse(x *exchange, cache *ResponseCache, options Options) {
+	for k, vals := range cache.Header {
+		if excludedHeader(k, options) {
+			continue
+		}
+		replayHeader(x.w.Header(), k, vals, options)
+	}
+	if options.TransformOnServe != nil {
+		transformed := *cache
+		transformed.Data = options.TransformOnServe(x.c, cache.Data)
+		cache = &transformed
+	}
+	setCacheStatusHeaders(x.w, cache, options)
+	setEntityHeaders(x.w, cache)
+	setContentLength(x.w, cache)
+	switch {
+	case notModified(x.r, cache):
+		writeNotModified(x.w)
+	case rangeRequest(x.r, cache) && writeRange(x.w, x.r, cache):
+	case x.r.Method == "HEAD":
+		x.w.WriteHeader(cache.Status)
+	default:
+		x.w.WriteHeader(cache.Status)
+		x.w.Write(cache.Data)
+		setTrailer(x.w.Header(), cache.Trailer)
+	}
+}
+
+// skipped reports whether the request bypasses the cache according to the
+// Skip and SkipQueryStrings options.
+func skipped(x *exchange, options Options) bool {
+	if options.SkipQueryStrings && hasQuery(x.r.URL, options) {
+		return true
+	}
+	return options.Skip != nil && options.Skip(x.c)
+}
+
+// routeError reports whether the response is an error of gin's router, for
+// a request matching no route, stored per RouteErrorExpire.
+func routeError(x *exchange, status int, options Options) bool {
+	return options.RouteErrorExpire > 0 && x.c.FullPath() == "" && (status == http.StatusNotFound || status == http.StatusMethodNotAllowed)
+}
+
+// writeRouteError writes the default message of gin's router for an error
+// page no NoRoute or NoMethod handler wrote, so it is stored.
+func writeRouteError(w *cachedWriter) {
+	message := "404 page not found"
+	if w.status == http.StatusMethodNotAllowed {
+		message = "405 method not allowed"
+	}
+	w.Header()["Content-Type"] = []string{"text/plain"}
+	w.WriteString(message)
+}
+
+// mustCache reports whether MustCache keeps the request from reaching the
+// handler.
+func mustCache(x *exchange, options Options) bool {
+	return options.MustCache != nil && options.MustCache(x.c)
+}
+
+// writeMustCache answers a request kept from reaching the handler by
+// MustCache.
+func writeMustCache(w gin.ResponseWriter, options Options) {
+	if options.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((options.RetryAfter+time.Second-1)/time.Second)))
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.WriteHeaderNow()
+}
+
+// observedStore replaces the store in ObserveOnly mode: every lookup misses
+// and writes are dropped, while the decisions are made and reported to
+// Metrics as usual.
+var observedStore = withContext(NewNoOpStore())
+
+// pageCache is the state shared by the requests of a CachePage or Cached
+// middleware instance.
+type pageCache struct {
+	options      Options
+	group        flightGroup
+	revalidating keySet
+	guard        *storeGuard
+	newKeys      *keyLimiter
+	writeBehind  writeBehind
+}
+
+func newPageCache(options Options) *pageCache {
+	options = applyDefaults(options)
+	return &pageCache{
+		options:     options,
+		guard:       newStoreGuard(options),
+		newKeys:     newKeyLimiter(options),
+		writeBehind: newWriteBehind(options.WriteBehind),
+	}
+}
+
+// exchange is a request served by the page cache, over the writer and the
+// request of the server. The gin middlewares and Handler adapt their handlers
+// to it: next runs the handler writing to w, and detach returns the exchange
+// refreshing the page in the background, writing to w, or is nil when the
+// handler can't run once the request is over, as for Cached. c is the gin
+// context handed to the options taking one, e.g. KeyFunc.
+type exchange struct {
+	w      gin.ResponseWriter
+	r      *http.Request
+	c      *gin.Context
+	next   func(w gin.ResponseWriter)
+	detach func(w gin.ResponseWriter) *exchange
+	// ran is set once the handler has been run.
+	ran bool
+}
+
+// run runs the handler writing to w.
+func (x *exchange) run(w gin.ResponseWriter) {
+	x.ran = true
+	x.next(w)
+}
+
+// contextExchange returns the exchange of the request of c, running handle,
+// or the rest of the chain if it is nil.
+func contextExchange(c *gin.Context, handle gin.HandlerFunc) *exchange {
+	x := &exchange{w: c.Writer, r: c.Request, c: c}
+	x.next = func(w gin.ResponseWriter) {
+		writer := c.Writer
+		c.Writer = w
+		// Restored even if the handler panics, so the recovery middleware
+		// answers through the writer of gin.
+		defer func() { c.Writer = writer }()
+		if handle == nil {
+			c.Next()
+			return
+		}
+		handle(c)
+	}
+	if handle != nil {
+		x.detach = func(w gin.ResponseWriter) *exchange {
+			// The copy doesn't share the request context, as the original
+			// request is over by the time the store is updated.
+			cp := c.Copy()
+			cp.Request = cp.Request.WithContext(context.Background())
+			cp.Writer = w
+			return contextExchange(cp, handle)
+		}
+	}
+	return x
+}
+
+// serveContext serves the request of c as serve does, and stops the handler
+// chain unless the handler was run.
+func (p *pageCache) serveContext(c *gin.Context, store ContextCacheStore, expire time.Duration, handle gin.HandlerFunc) {
+	x := contextExchange(c, handle)
+	p.serve(x, store, expire)
+	if !x.ran {
+		c.Abort()
+	}
+}
+
+// serve answers the request from the store, or runs the handler and caches
+// its response for expire.
+func (p *pageCache) serve(x *exchange, store ContextCacheStore, expire time.Duration) {
+	options := p.options
+	if skipped(x, options) {
+		x.run(x.w)
+		return
+	}
+
+	closed := cacheableMethod(x.r.Method, options) && mustCache(x, options)
+	noStore, noCache := false, false
+	if !options.IgnoreRequestCacheControl && !closed {
+		noStore, noCache = requestDirectives(x.r)
+	}
+	if noStore || !cacheableMethod(x.r.Method, options) || !p.guard.available() {
+		if closed {
+			writeMustCache(x.w, options)
+			return
+		}
+		x.run(x.w)
+		return
+	}
+
+	if options.ObserveOnly {
+		store = observedStore
+		options.SingleFlight = false
+	}
+
+	var cache ResponseCache
+	var fallback *ResponseCache
+	key := pageKey(x, options)
+	x.c.Set(CACHE_KEY_KEY, key)
+	miss := func() {
+		options.Metrics.Miss(key)
+		setCacheStatusHeaders(x.w, nil, options)
+		if closed {
+			if fallback != nil {
+				options.Metrics.Hit(key)
+				writeCachedResponse(x, fallback, options)
+			} else {
+				writeMustCache(x.w, options)
+			}
+			return
+		}
+		if fallback == nil && !p.newKeys.admit() {
+			// Too many new pages were stored lately.
+			x.run(x.w)
+			return
+		}
+		// replace writer
+		writerOptions := options
+		if fallback != nil {
+			// Hold the response until it is known whether the origin failed.
+			writerOptions.Buffered = true
+		}
+		serveFallback := func() {
+			header := x.w.Header()
+			for k := range header {
+				delete(header, k)
+			}
+			options.Metrics.Hit(key)
+			writeCachedResponse(x, fallback, options)
+		}
+		writer := newCachedWriter(store, expire, x.w, key, x, writerOptions)
+		writer.writeBehind = p.writeBehind
+		panicked := true
+		defer func() {
+			if !panicked {
+				return
+			}
+			if fallback != nil && writer.holding() {
+				// The origin failed before sending anything: serve the last
+				// good page as for an error status, leaving the panic to
+				// the loggers.
+				x.c.Error(fmt.Errorf("cache: served a stale page after a panic: %v", recover()))
+				serveFallback()
+				return
+			}
+			// Nothing is stored, and the response goes on as unbuffered: what
+			// the handler sent is released, and otherwise the recovery
+			// middleware can still answer.
+			if writer.holding() && writer.committed {
+				writer.release()
+			}
+		}()
+		x.run(writer)
+		panicked = false
+		if routeError(x, writer.status, options) && !writer.committed {
+			// gin writes its default message once the handlers are done.
+			writeRouteError(writer)
+		}
+		if fallback != nil && writer.status >= http.StatusInternalServerError && writer.holding() {
+			// Serve the last good page instead of the error.
+			serveFallback()
+			return
+		}
+		writer.finalize()
+	}
+	// lookup fetches the page, keeping aside a page only kept past its
+	// freshness for ServeStaleOnError. It reports whether the request was
+	// answered because the store failed.
+	found := false
+	lookup := func() bool {
+		var err error
+		p.guard.lookup(func() { found, err = fetchCache(store, key, x.r, &cache, options) })
+		if p.guard.failed(x, err) {
+			return true
+		}
+		if now := options.Now(); found && cache.expired(now) {
+			if now.Before(cache.ErrorUntil) {
+				stale := cache
+				fallback = &stale
+			}
+			found = false
+		}
+		return false
+	}
+	if !noCache && lookup() {
+		return
+	}
+	// The response of a HEAD request can't be stored as the GET page it's
+	// served from.
+	derived := keyMethod(x.r.Method, options) != x.r.Method
+	if !found && derived {
+		options.Metrics.Miss(key)
+		setCacheStatusHeaders(x.w, nil, options)
+		if closed {
+			writeMustCache(x.w, options)
+			return
+		}
+		x.run(x.w)
+		return
+	}
+	if !found && !noCache && options.SingleFlight {
+		start := time.Now()
+		if p.group.do(key, miss) {
+			return
+		}
+		// Another request regenerated the page meanwhile.
+		if lookup() {
+			return
+		}
+		if found {
+			options.Metrics.Coalesced(key, time.Since(start))
+		}
+	}
+	if found && !derived && !closed && cache.stale(options.Now()) {
+		if unlock, locked := p.lockRevalidation(x.r, store, key); locked {
+			if x.detach != nil {
+				revalidate(x, store, expire, key, options, unlock)
+			} else {
+				// The rest of the chain can't run once this request is over,
+				// so this request refreshes the page while the others get it
+				// stale.
+				defer unlock()
+				found = false
+			}
+		}
+	}
+	if !found {
+		miss()
+	} else {
+		options.Metrics.Hit(key)
+		writeCachedResponse(x, &cache, options)
+	}
+}
+
 func SiteCache(store CacheStore, expire time.Duration) gin.HandlerFunc {
+	return SiteCacheWithOptions(store, expire, Options{})
+}
 
+// SiteCacheWithOptions is like SiteCache but allows tuning the cache behavior.
+func SiteCacheWithOptions(cacheStore CacheStore, expire time.Duration, options Options) gin.HandlerFunc {
+	options = applyDefaults(options)
+	store := withContext(cacheStore)
+	if options.ObserveOnly {
+		store = observedStore
+	}
+	guard := newStoreGuard(options)
 	return func(c *gin.Context) {
-		var cache responseCache
-		url := c.Request.URL
-		key := urlEscape(PageCachePrefix, url.RequestURI())
-		if err := store.Get(key, &cache); err != nil {
+		x := contextExchange(c, nil)
+		if !cacheableMethod(c.Request.Method, options) || skipped(x, options) {
 			c.Next()
-		} else {
-			c.Writer.WriteHeader(cache.Status)
-			for k, vals := range cache.Header {
-				for _, v := range vals {
-					c.Writer.Header().Add(k, v)
-				}
+			return
+		}
+		closed := mustCache(x, options)
+		if !guard.available() {
+			if closed {
+				writeMustCache(c.Writer, options)
+				c.Abort()
+				return
 			}
-			c.Writer.Write(cache.Data)
+			c.Next()
+			return
+		}
+		var cache ResponseCache
+		key := pageKey(x, options)
+		c.Set(CACHE_KEY_KEY, key)
+		var found bool
+		var err error
+		guard.lookup(func() { found, err = fetchCache(store, key, c.Request, &cache, options) })
+		if guard.failed(x, err) {
+			c.Abort()
+			return
+		}
+		if !found {
+			options.Metrics.Miss(key)
+			setCacheStatusHeaders(c.Writer, nil, options)
+			if closed {
+				writeMustCache(c.Writer, options)
+				c.Abort()
+				return
+			}
+			c.Next()
+		} else {
+			options.Metrics.Hit(key)
+			writeCachedResponse(x, &cache, options)
+			c.Abort()
 		}
 	}
 }
 
 // Cache Decorator
 func CachePage(store CacheStore, expire time.Duration, handle gin.HandlerFunc) gin.HandlerFunc {
+	return CachePageWithOptions(store, expire, Options{}, handle)
+}
+
+// CachePageWithOptions is like CachePage but allows tuning the cache behavior.
+func CachePageWithOptions(cacheStore CacheStore, expire time.Duration, options Options, handle gin.HandlerFunc) gin.HandlerFunc {
+	p := newPageCache(options)
+	store := withContext(cacheStore)
 	return func(c *gin.Context) {
-		var cache responseCache
-		url := c.Request.URL
-		key := urlEscape(PageCachePrefix, url.RequestURI())
-		if err := store.Get(key, &cache); err != nil {
-			// replace writer
-			writer := newCachedWriter(store, expire, c.Writer, key)
-			c.Writer = writer
-			handle(c)
-		} else {
-			c.Writer.WriteHeader(cache.Status)
-			for k, vals := range cache.Header {
-				for _, v := range vals {
-					c.Writer.Header().Add(k, v)
-				}
-			}
-			c.Writer.Write(cache.Data)
-		}
+		p.serveContext(c, store, expire, handle)
 	}
 }
 
 func Cached(expire time.Duration) gin.HandlerFunc {
+	return CachedWithOptions(expire, Options{})
+}
+
+// CachedWithOptions is like Cached but allows tuning the cache behavior.
+func CachedWithOptions(expire time.Duration, options Options) gin.HandlerFunc {
+	if options.AccessControl == DefaultAccessControl {
+		options.AccessControl = SkipAccessControl
+	}
+	p := newPageCache(options)
 	return func(c *gin.Context) {
 		store, ok := GetCache(c)
 		if !ok {
 			c.Next()
 			return
 		}
-
-		var cache responseCache
-		url := c.Request.URL
-		key := urlEscape(PageCachePrefix, url.RequestURI())
-		if err := store.Get(key, &cache); err != nil {
-			// replace writer
-			writer := newCachedWriter(store, expire, c.Writer, key)
-			c.Writer = writer
-			c.Next()
-		} else {
-			c.Writer.WriteHeader(cache.Status)
-			for k, vals := range cache.Header {
-				if strings.HasPrefix(k, "Access-Control") {
-					continue
-				}
-				for _, v := range vals {
-					c.Writer.Header().Add(k, v)
-				}
-			}
-			c.Writer.Write(cache.Data)
-			c.Abort()
-		}
+		p.serveContext(c, withContext(store), expire, nil)
 	}
 }
@@ -1,9 +1,25 @@
 package cache
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
 	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/gin-gonic/gin"
 )
 
 type cacheFactory func(*testing.T, time.Duration) CacheStore
@@ -69,6 +85,18 @@ func incrDecr(t *testing.T, newCache cacheFactory) {
 	if newValue != 0 {
 		t.Errorf("Expected capped at 0, got %d", newValue)
 	}
+
+	// Missing keys aren't created.
+	if _, err = cache.Increment("missing", 1); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss incrementing a missing key, got: %v", err)
+	}
+	if _, err = cache.Decrement("missing", 1); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss decrementing a missing key, got: %v", err)
+	}
+	var i int
+	if err = cache.Get("missing", &i); err != ErrCacheMiss {
+		t.Errorf("Expected the missing key not to be created, got: %v", err)
+	}
 }
 
 func expiration(t *testing.T, newCache cacheFactory) {
@@ -172,6 +200,63 @@ func testReplace(t *testing.T, newCache cacheFactory) {
 	}
 }
 
+func expirationConstants(t *testing.T, newCache cacheFactory) {
+	cache := newCache(t, time.Second)
+	if err := cache.Set("forever", 1, FOREVER); err != nil {
+		t.Errorf("Error setting forever: %s", err)
+	}
+	if err := cache.Set("default", 1, DEFAULT); err != nil {
+		t.Errorf("Error setting default: %s", err)
+	}
+	if err := cache.Set("subsecond", 1, 500*time.Millisecond); err != nil {
+		t.Errorf("Error setting an expiration under a second: %s", err)
+	}
+	time.Sleep(2 * time.Second)
+	var i int
+	if err := cache.Get("forever", &i); err != nil {
+		t.Errorf("Expected a FOREVER entry to outlive the store default, got: %s", err)
+	}
+	for _, key := range []string{"default", "subsecond"} {
+		if err := cache.Get(key, &i); err != ErrCacheMiss {
+			t.Errorf("Expected %s to expire, got: %v", key, err)
+		}
+	}
+
+	cache = newCache(t, FOREVER)
+	cache.Set("default", 1, DEFAULT)
+	time.Sleep(2 * time.Second)
+	if err := cache.Get("default", &i); err != nil {
+		t.Errorf("Expected a DEFAULT entry of a FOREVER store not to expire, got: %s", err)
+	}
+}
+
+func testTTL(t *testing.T, newCache cacheFactory) {
+	cache, ok := newCache(t, time.Hour).(TTLStore)
+	if !ok {
+		t.Fatalf("Expected the store to implement TTLStore")
+	}
+	store := cache.(CacheStore)
+	if _, err := cache.TTL("missing"); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss for a missing key, got: %v", err)
+	}
+
+	store.Set("int", 1, 10*time.Second)
+	first, err := cache.TTL("int")
+	if err != nil || first <= 0 || first > 10*time.Second {
+		t.Errorf("Expected a TTL of at most 10s, got %s, %v", first, err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	second, err := cache.TTL("int")
+	if err != nil || second >= first {
+		t.Errorf("Expected the TTL to decrease from %s, got %s, %v", first, second, err)
+	}
+
+	store.Set("forever", 1, FOREVER)
+	if ttl, err := cache.TTL("forever"); err != nil || ttl != FOREVER {
+		t.Errorf("Expected FOREVER for an entry that never expires, got %s, %v", ttl, err)
+	}
+}
+
 func testAdd(t *testing.T, newCache cacheFactory) {
 	var err error
 	cache := newCache(t, time.Hour)
@@ -200,3 +285,1559 @@ func testAdd(t *testing.T, newCache cacheFactory) {
 		t.Errorf("Expected 3, got: %d", i)
 	}
 }
+
+func TestUrlEscape(t *testing.T) {
+	key := urlEscape("prefix", "/foo?bar=baz")
+	if key != "prefix:%2Ffoo%3Fbar%3Dbaz" {
+		t.Errorf("Unexpected key for short url: %s", key)
+	}
+}
+
+func TestUrlEscapeLongUrl(t *testing.T) {
+	u := "/foo?bar=" + strings.Repeat("x", 500)
+	key := urlEscape("prefix", u)
+
+	if !strings.HasPrefix(key, "prefix:") {
+		t.Errorf("Expected key to start with prefix, got %q", key)
+	}
+	hash := strings.TrimPrefix(key, "prefix:")
+	if len(hash) != 40 {
+		t.Errorf("Expected a 40 char hex digest, got %q", hash)
+	}
+	for _, r := range key {
+		if r < 0x21 || r > 0x7e {
+			t.Errorf("Expected a printable key, got %q", key)
+			break
+		}
+	}
+	if again := urlEscape("prefix", u); again != key {
+		t.Errorf("Expected stable key, got %q and %q", key, again)
+	}
+}
+
+func TestHashFunc(t *testing.T) {
+	u := "/" + strings.Repeat("x", 300)
+	sum1, sum256 := sha1.Sum([]byte(u)), sha256.Sum256([]byte(u))
+	for _, tc := range []struct {
+		hash func() hash.Hash
+		key  string
+		etag string
+	}{
+		{nil, PageCachePrefix + ":" + hex.EncodeToString(sum1[:]), newETag([]byte("1"), sha1.New)},
+		{sha1.New, PageCachePrefix + ":h160-" + hex.EncodeToString(sum1[:]), newETag([]byte("1"), nil)},
+		{sha256.New, PageCachePrefix + ":h256-" + hex.EncodeToString(sum256[:]), newETag([]byte("1"), sha256.New)},
+	} {
+		options := Options{HashFunc: tc.hash, ETag: true}
+		for i := 0; i < 2; i++ {
+			if key, _ := PageKey("GET", u, options); key != tc.key {
+				t.Errorf("Expected the key %q, got %q", tc.key, key)
+			}
+		}
+		r := newCountingRouter(NewInMemoryStore(time.Minute), options)
+		performRequest(r, "GET", "/page")
+		w := performRequest(r, "GET", "/page")
+		if etag := w.Header().Get("ETag"); etag != tc.etag {
+			t.Errorf("Expected the ETag %q, got %q", tc.etag, etag)
+		}
+	}
+	if newETag([]byte("1"), nil) == newETag([]byte("1"), sha256.New) {
+		t.Errorf("Expected the ETags of different hashes to differ")
+	}
+}
+
+func TestUrlEscapeMaxLength(t *testing.T) {
+	for _, tc := range []struct {
+		u      string
+		max    int
+		hint   int
+		hashed bool
+	}{
+		{strings.Repeat("x", 93), 100, 0, false},
+		{strings.Repeat("x", 94), 100, 0, true},
+		{strings.Repeat("x", 243), 250, 0, false},
+		{strings.Repeat("x", 244), 250, 0, true},
+		{strings.Repeat("x", 244), 250, 8, true},
+		{"/", 5, 8, true},
+	} {
+		key := escapeKey("prefix", tc.u, tc.max, tc.hint, nil)
+		if hashed := key != "prefix:"+url.QueryEscape(tc.u); hashed != tc.hashed {
+			t.Errorf("Expected a %d bytes url hashed with a %d limit: %t, got %q", len(tc.u), tc.max, tc.hashed, key)
+		}
+		if tc.hashed {
+			hint := tc.u
+			if len(hint) > tc.hint {
+				hint = hint[:tc.hint]
+			}
+			if want := 7 + len(url.QueryEscape(hint)) + 40; len(key) != want || !strings.HasPrefix(key, "prefix:"+url.QueryEscape(hint)) {
+				t.Errorf("Expected the hashed key to keep %d bytes of the url, got %q", tc.hint, key)
+			}
+		} else if len(key) > tc.max {
+			t.Errorf("Expected the key to fit in %d bytes, got %d", tc.max, len(key))
+		}
+	}
+}
+
+func TestCachePage_MaxKeyLength(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r := newCountingRouter(store, Options{MaxKeyLength: 250, HashedKeyHint: 10})
+	path := "/page?q=" + strings.Repeat("x", 240)
+	expectBody(t, performRequest(r, "GET", path), "1")
+	expectBody(t, performRequest(r, "GET", path), "1")
+
+	var cache ResponseCache
+	if err := store.Get(escapeKey(PageCachePrefix, path, 250, 10, nil), &cache); err != nil {
+		t.Errorf("Expected the page under its hashed key, got: %s", err)
+	}
+}
+
+func performRequest(r http.Handler, method, path string) *httptest.ResponseRecorder {
+	return performRequestWithHeader(r, method, path, nil)
+}
+
+func performRequestWithHeader(r http.Handler, method, path string, header http.Header) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	for k, vals := range header {
+		req.Header[k] = vals
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// newCachePageRouter serves /page through CachePageWithOptions, responding
+// with the given status, and returns a pointer counting handler invocations.
+func newCachePageRouter(store CacheStore, options Options, status int) (*gin.Engine, *int) {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+		calls++
+		c.String(status, "body")
+	}))
+	return r, &calls
+}
+
+func TestCachePage_CacheableStatus(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		calls  int
+	}{
+		{http.StatusOK, 1},
+		{http.StatusNotFound, 2},
+		{http.StatusInternalServerError, 2},
+	} {
+		r, calls := newCachePageRouter(NewInMemoryStore(time.Minute), Options{}, tc.status)
+		for i := 0; i < 2; i++ {
+			w := performRequest(r, "GET", "/page")
+			if w.Code != tc.status {
+				t.Errorf("Expected status %d, got %d", tc.status, w.Code)
+			}
+			if w.Body.String() != "body" {
+				t.Errorf("Expected body, got %q", w.Body.String())
+			}
+		}
+		if *calls != tc.calls {
+			t.Errorf("Status %d: expected handler to run %d times, ran %d", tc.status, tc.calls, *calls)
+		}
+	}
+}
+
+func TestCachePage_CustomCacheableStatus(t *testing.T) {
+	options := Options{
+		CacheableStatus: func(status int) bool { return status == http.StatusNotFound },
+	}
+	r, calls := newCachePageRouter(NewInMemoryStore(time.Minute), options, http.StatusNotFound)
+	performRequest(r, "GET", "/page")
+	performRequest(r, "GET", "/page")
+	if *calls != 1 {
+		t.Errorf("Expected 404 to be cached, handler ran %d times", *calls)
+	}
+}
+
+func TestCached_ReplayStatusAndHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	options := Options{
+		CacheableStatus: func(int) bool { return true },
+	}
+	calls := 0
+	r := gin.New()
+	r.Use(Cache(NewInMemoryStore(time.Minute)))
+	r.GET("/page", CachedWithOptions(time.Minute, options), func(c *gin.Context) {
+		calls++
+		c.Header("X-Custom", "custom")
+		c.Data(http.StatusInternalServerError, "text/plain", []byte("error"))
+	})
+
+	first := performRequest(r, "GET", "/page")
+	second := performRequest(r, "GET", "/page")
+	if calls != 1 {
+		t.Errorf("Expected the second request to be served from cache, handler ran %d times", calls)
+	}
+	if second.Code != first.Code || second.Code != http.StatusInternalServerError {
+		t.Errorf("Expected replayed status %d, got %d", first.Code, second.Code)
+	}
+	for _, h := range []string{"X-Custom", "Content-Type"} {
+		if second.Header().Get(h) != first.Header().Get(h) {
+			t.Errorf("Expected replayed %s %q, got %q", h, first.Header().Get(h), second.Header().Get(h))
+		}
+	}
+	if second.Body.String() != "error" {
+		t.Errorf("Expected replayed body, got %q", second.Body.String())
+	}
+}
+
+// newCountingRouter serves /page through CachePageWithOptions with a body
+// holding the number of times the handler ran.
+func newCountingRouter(store CacheStore, options Options) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, fmt.Sprint(calls))
+	}))
+	return r
+}
+
+func expectBody(t *testing.T, w *httptest.ResponseRecorder, body string) {
+	if w.Body.String() != body {
+		t.Errorf("Expected body %q, got %q", body, w.Body.String())
+	}
+}
+
+func TestCachePage_RequestNoStore(t *testing.T) {
+	r := newCountingRouter(NewInMemoryStore(time.Minute), Options{})
+	noStore := http.Header{"Cache-Control": {"no-store"}}
+
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", noStore), "1")
+	// The no-store response must not have been cached.
+	expectBody(t, performRequest(r, "GET", "/page"), "2")
+	// Nor must a cached copy be served.
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", noStore), "3")
+	expectBody(t, performRequest(r, "GET", "/page"), "2")
+}
+
+func TestCachePage_RequestNoCache(t *testing.T) {
+	for _, header := range []http.Header{
+		{"Cache-Control": {"no-cache"}},
+		{"Pragma": {"no-cache"}},
+	} {
+		r := newCountingRouter(NewInMemoryStore(time.Minute), Options{})
+		expectBody(t, performRequest(r, "GET", "/page"), "1")
+		expectBody(t, performRequestWithHeader(r, "GET", "/page", header), "2")
+		// The fresh response replaces the cached one.
+		expectBody(t, performRequest(r, "GET", "/page"), "2")
+	}
+}
+
+func TestCachePage_IgnoreRequestCacheControl(t *testing.T) {
+	r := newCountingRouter(NewInMemoryStore(time.Minute), Options{IgnoreRequestCacheControl: true})
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", http.Header{"Cache-Control": {"no-cache"}}), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", http.Header{"Cache-Control": {"no-store"}}), "1")
+}
+
+func TestCachePage_AgeAndXCacheHeaders(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r := newCountingRouter(store, Options{SetAgeHeader: true, SetXCacheHeader: true})
+
+	w := performRequest(r, "GET", "/page")
+	if w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("Expected X-Cache MISS, got %q", w.Header().Get("X-Cache"))
+	}
+	if _, found := w.Header()["Age"]; found {
+		t.Errorf("Expected no Age header on a miss")
+	}
+
+	w = performRequest(r, "GET", "/page")
+	if w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Expected X-Cache HIT, got %q", w.Header().Get("X-Cache"))
+	}
+	if w.Header().Get("Age") != "0" {
+		t.Errorf("Expected Age 0, got %q", w.Header().Get("Age"))
+	}
+
+	key := urlEscape(PageCachePrefix, "/page")
+	var cache ResponseCache
+	store.Get(key, &cache)
+	cache.Timestamp = cache.Timestamp.Add(-10 * time.Second)
+	store.Set(key, cache, DEFAULT)
+	w = performRequest(r, "GET", "/page")
+	if w.Header().Get("Age") != "10" {
+		t.Errorf("Expected Age 10, got %q", w.Header().Get("Age"))
+	}
+}
+
+func TestCachePage_Now(t *testing.T) {
+	clock := newFakeClock()
+	store := NewInMemoryStore(time.Hour)
+	store.SetClock(clock.Now)
+	r := newCountingRouter(store, Options{SetAgeHeader: true, StaleWhileRevalidate: time.Minute, Now: clock.Now})
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+
+	clock.Advance(30 * time.Second)
+	w := performRequest(r, "GET", "/page")
+	expectBody(t, w, "1")
+	if w.Header().Get("Age") != "30" {
+		t.Errorf("Expected Age 30, got %q", w.Header().Get("Age"))
+	}
+
+	// Past the stale window, the page is gone from the store.
+	clock.Advance(2 * time.Minute)
+	expectBody(t, performRequest(r, "GET", "/page"), "2")
+}
+
+func TestCachePage_ETag(t *testing.T) {
+	r := newCountingRouter(NewInMemoryStore(time.Minute), Options{ETag: true})
+	performRequest(r, "GET", "/page")
+
+	w := performRequest(r, "GET", "/page")
+	etag := w.Header().Get("ETag")
+	if etag != newETag([]byte("1"), nil) {
+		t.Errorf("Expected ETag of the body, got %q", etag)
+	}
+
+	for _, tc := range []struct {
+		ifNoneMatch string
+		code        int
+		body        string
+	}{
+		{etag, http.StatusNotModified, ""},
+		{"W/" + etag, http.StatusNotModified, ""},
+		{`"other", ` + etag, http.StatusNotModified, ""},
+		{"*", http.StatusNotModified, ""},
+		{`"other"`, http.StatusOK, "1"},
+		{"", http.StatusOK, "1"},
+	} {
+		header := http.Header{}
+		if tc.ifNoneMatch != "" {
+			header.Set("If-None-Match", tc.ifNoneMatch)
+		}
+		w := performRequestWithHeader(r, "GET", "/page", header)
+		if w.Code != tc.code {
+			t.Errorf("If-None-Match %q: expected status %d, got %d", tc.ifNoneMatch, tc.code, w.Code)
+		}
+		expectBody(t, w, tc.body)
+	}
+}
+
+func TestCachePage_LastModified(t *testing.T) {
+	clock := newFakeClock()
+	r := newCountingRouter(NewInMemoryStore(time.Minute), Options{LastModified: true, ETag: true, Now: clock.Now})
+	stored := clock.Now().Truncate(time.Second)
+	performRequest(r, "GET", "/page")
+
+	w := performRequest(r, "GET", "/page")
+	modified := w.Header().Get("Last-Modified")
+	if date, err := http.ParseTime(modified); err != nil || !date.Equal(stored) {
+		t.Errorf("Expected Last-Modified %s, got %q", stored, modified)
+	}
+
+	for _, tc := range []struct {
+		header http.Header
+		code   int
+	}{
+		{http.Header{"If-Modified-Since": {stored.Add(-time.Hour).UTC().Format(http.TimeFormat)}}, http.StatusOK},
+		{http.Header{"If-Modified-Since": {modified}}, http.StatusNotModified},
+		{http.Header{"If-Modified-Since": {stored.Add(time.Hour).UTC().Format(http.TimeFormat)}}, http.StatusNotModified},
+		{http.Header{"If-Modified-Since": {"yesterday"}}, http.StatusOK},
+		{http.Header{"If-Modified-Since": {modified}, "If-None-Match": {`"other"`}}, http.StatusOK},
+	} {
+		w := performRequestWithHeader(r, "GET", "/page", tc.header)
+		if w.Code != tc.code {
+			t.Errorf("%v: expected status %d, got %d", tc.header, tc.code, w.Code)
+		}
+	}
+}
+
+func TestCachePage_Vary(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	r := gin.New()
+	r.GET("/page", CachePage(NewInMemoryStore(time.Minute), time.Minute, func(c *gin.Context) {
+		calls++
+		c.Header("Vary", c.Query("vary"))
+		c.String(http.StatusOK, fmt.Sprint(calls))
+	}))
+
+	gzip := http.Header{"Accept-Encoding": {"gzip"}}
+	identity := http.Header{"Accept-Encoding": {"identity"}}
+	expectBody(t, performRequestWithHeader(r, "GET", "/page?vary=Accept-Encoding", gzip), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page?vary=Accept-Encoding", identity), "2")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page?vary=Accept-Encoding", gzip), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page?vary=Accept-Encoding", identity), "2")
+
+	vary := "/page?vary=Accept-Encoding,Accept-Language"
+	gzipEn := http.Header{"Accept-Encoding": {"gzip"}, "Accept-Language": {"en"}}
+	gzipFr := http.Header{"Accept-Encoding": {"gzip"}, "Accept-Language": {"fr"}}
+	expectBody(t, performRequestWithHeader(r, "GET", vary, gzipEn), "3")
+	expectBody(t, performRequestWithHeader(r, "GET", vary, gzipFr), "4")
+	expectBody(t, performRequestWithHeader(r, "GET", vary, gzipEn), "3")
+	expectBody(t, performRequestWithHeader(r, "GET", vary, gzipFr), "4")
+
+	// Vary: * can't be matched and is never served from the cache.
+	expectBody(t, performRequest(r, "GET", "/page?vary=*"), "5")
+	expectBody(t, performRequest(r, "GET", "/page?vary=*"), "6")
+}
+
+func TestCachePage_VaryMaxKeyLength(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	calls := 0
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(store, time.Minute, Options{MaxKeyLength: 100, HashedKeyHint: 10}, func(c *gin.Context) {
+		calls++
+		c.Header("Vary", "User-Agent")
+		c.String(http.StatusOK, fmt.Sprint(calls))
+	}))
+
+	agent := http.Header{"User-Agent": {strings.Repeat("agent ", 40)}}
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", agent), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", agent), "1")
+
+	keys, _ := store.Keys("")
+	for _, key := range keys {
+		if len(key) > 100 {
+			t.Errorf("Expected the keys to fit MaxKeyLength, got %q", key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || !strings.HasPrefix(keys[1], urlEscape(PageCachePrefix, "/page")+":User-Agent") {
+		t.Errorf("Expected the vary index and the hashed variant keeping HashedKeyHint bytes, got %q", keys)
+	}
+}
+
+func TestCachePage_KeyFunc(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r := newCountingRouter(store, Options{
+		KeyFunc: func(c *gin.Context) string {
+			return c.Request.Header.Get("X-Tenant") + strings.Repeat("/", 300)
+		},
+	})
+
+	a := http.Header{"X-Tenant": {"a"}}
+	b := http.Header{"X-Tenant": {"b"}}
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", a), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", b), "2")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", a), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", b), "2")
+
+	var cache ResponseCache
+	if err := store.Get(urlEscape(PageCachePrefix, "a"+strings.Repeat("/", 300)), &cache); err != nil {
+		t.Errorf("Expected the custom key to be hashed and stored, got: %s", err)
+	}
+}
+
+func TestCachePage_NormalizeQuery(t *testing.T) {
+	r := newCountingRouter(NewInMemoryStore(time.Minute), Options{
+		NormalizeQuery:    true,
+		IgnoreQueryParams: []string{"utm_*", "ref"},
+	})
+	expectBody(t, performRequest(r, "GET", "/page?a=1&b=2"), "1")
+	expectBody(t, performRequest(r, "GET", "/page?b=2&a=1"), "1")
+	expectBody(t, performRequest(r, "GET", "/page?utm_source=x&b=2&ref=y&a=1&utm_medium=z"), "1")
+
+	// Repeated parameters keep their order.
+	expectBody(t, performRequest(r, "GET", "/page?a=1&a=2"), "2")
+	expectBody(t, performRequest(r, "GET", "/page?b=3&a=1&a=2"), "3")
+	expectBody(t, performRequest(r, "GET", "/page?a=1&b=3&a=2"), "3")
+	expectBody(t, performRequest(r, "GET", "/page?a=2&a=1"), "4")
+
+	// Only ignored parameters left means the bare path.
+	expectBody(t, performRequest(r, "GET", "/page"), "5")
+	expectBody(t, performRequest(r, "GET", "/page?utm_source=x"), "5")
+}
+
+func TestCachePage_QueryOrderWithoutNormalization(t *testing.T) {
+	r := newCountingRouter(NewInMemoryStore(time.Minute), Options{})
+	expectBody(t, performRequest(r, "GET", "/page?a=1&b=2"), "1")
+	expectBody(t, performRequest(r, "GET", "/page?b=2&a=1"), "2")
+}
+
+var errStoreDown = errors.New("store down")
+
+// fakeClock is a time source only moving when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// failingStore fails every operation with errStoreDown.
+type failingStore struct{}
+
+func (failingStore) Get(string, interface{}) error                    { return errStoreDown }
+func (failingStore) Set(string, interface{}, time.Duration) error     { return errStoreDown }
+func (failingStore) Add(string, interface{}, time.Duration) error     { return errStoreDown }
+func (failingStore) Replace(string, interface{}, time.Duration) error { return errStoreDown }
+func (failingStore) Delete(string) error                              { return errStoreDown }
+func (failingStore) Increment(string, uint64) (uint64, error)         { return 0, errStoreDown }
+func (failingStore) Decrement(string, uint64) (uint64, error)         { return 0, errStoreDown }
+func (failingStore) Flush() error                                     { return errStoreDown }
+
+func TestCachePage_OnError(t *testing.T) {
+	var errs []error
+	r := newCountingRouter(failingStore{}, Options{
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+
+	w := performRequest(r, "GET", "/page")
+	expectBody(t, w, "1")
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the handler response despite store errors, got %d", w.Code)
+	}
+	// One failed Get and one failed Set.
+	if len(errs) != 2 || !errors.Is(errs[0], errStoreDown) || !errors.Is(errs[1], errStoreDown) {
+		t.Errorf("Expected OnError to be called for Get and Set, got %v", errs)
+	}
+}
+
+func TestCachePage_OnErrorIgnoresMiss(t *testing.T) {
+	var errs []error
+	r := newCountingRouter(NewInMemoryStore(time.Minute), Options{
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+	performRequest(r, "GET", "/page")
+	performRequest(r, "GET", "/page")
+	if len(errs) != 0 {
+		t.Errorf("Expected no errors reported for a miss, got %v", errs)
+	}
+}
+
+func TestCachePage_MultipleWrites(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	r := gin.New()
+	r.GET("/page", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.Writer.Write([]byte("a"))
+		c.Writer.Write([]byte("b"))
+		c.Writer.Write([]byte("c"))
+	}))
+
+	expectBody(t, performRequest(r, "GET", "/page"), "abc")
+	var cache ResponseCache
+	if err := store.Get(urlEscape(PageCachePrefix, "/page"), &cache); err != nil {
+		t.Errorf("Expected the page to be cached, got: %s", err)
+	}
+	if string(cache.Data) != "abc" {
+		t.Errorf("Expected the complete body to be cached, got %q", cache.Data)
+	}
+	expectBody(t, performRequest(r, "GET", "/page"), "abc")
+}
+
+func TestCachePage_WriteString(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	r := gin.New()
+	r.GET("/string", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(http.StatusOK, "body %d", 1)
+	}))
+	r.GET("/data", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte("body 1"))
+	}))
+	r.GET("/writestring", CachePage(store, time.Minute, func(c *gin.Context) {
+		io.WriteString(c.Writer, "body ")
+		c.Writer.WriteString("1")
+	}))
+
+	for _, path := range []string{"/string", "/data", "/writestring"} {
+		expectBody(t, performRequest(r, "GET", path), "body 1")
+		var cache ResponseCache
+		if err := store.Get(urlEscape(PageCachePrefix, path), &cache); err != nil {
+			t.Errorf("%s: expected the page to be cached, got: %s", path, err)
+		}
+		if string(cache.Data) != "body 1" {
+			t.Errorf("%s: expected cached body %q, got %q", path, "body 1", cache.Data)
+		}
+	}
+}
+
+// recordingStore records the expiration of every Set.
+type recordingStore struct {
+	CacheStore
+	expires map[string]time.Duration
+}
+
+func newRecordingStore() *recordingStore {
+	return &recordingStore{NewInMemoryStore(time.Minute), make(map[string]time.Duration)}
+}
+
+func (s *recordingStore) Set(key string, value interface{}, expire time.Duration) error {
+	s.expires[key] = expire
+	return s.CacheStore.Set(key, value, expire)
+}
+
+// newStatusRouter serves /:status through CachePageWithOptions, responding
+// with the status from the path.
+func newStatusRouter(store CacheStore, options Options) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/:status", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+		var status int
+		fmt.Sscan(c.Param("status"), &status)
+		c.String(status, "body")
+	}))
+	return r
+}
+
+func TestCachePage_NegativeExpire(t *testing.T) {
+	store := newRecordingStore()
+	r := newStatusRouter(store, Options{NegativeExpire: time.Second})
+	for _, path := range []string{"/200", "/404", "/410", "/500"} {
+		performRequest(r, "GET", path)
+	}
+
+	for path, expire := range map[string]time.Duration{
+		"/200": time.Minute,
+		"/404": time.Second,
+		"/410": time.Second,
+	} {
+		if got, found := store.expires[urlEscape(PageCachePrefix, path)]; !found || got != expire {
+			t.Errorf("%s: expected to be cached for %s, got %s (stored: %t)", path, expire, got, found)
+		}
+	}
+	if _, found := store.expires[urlEscape(PageCachePrefix, "/500")]; found {
+		t.Errorf("Expected 500 not to be cached")
+	}
+}
+
+func TestCachePage_TTLByStatus(t *testing.T) {
+	store := newRecordingStore()
+	r := newStatusRouter(store, Options{
+		NegativeExpire: time.Second,
+		TTLByStatus: map[int]time.Duration{
+			http.StatusOK:               10 * time.Minute,
+			http.StatusMovedPermanently: 24 * time.Hour,
+			http.StatusNotFound:         30 * time.Second,
+			http.StatusGone:             0,
+		},
+	})
+	for _, path := range []string{"/200", "/203", "/301", "/404", "/410", "/500"} {
+		performRequest(r, "GET", path)
+	}
+
+	for path, expire := range map[string]time.Duration{
+		"/200": 10 * time.Minute,
+		"/301": 24 * time.Hour,
+		"/404": 30 * time.Second,
+	} {
+		if got, found := store.expires[urlEscape(PageCachePrefix, path)]; !found || got != expire {
+			t.Errorf("%s: expected to be cached for %s, got %s (stored: %t)", path, expire, got, found)
+		}
+	}
+	for _, path := range []string{"/203", "/410", "/500"} {
+		if _, found := store.expires[urlEscape(PageCachePrefix, path)]; found {
+			t.Errorf("Expected %s not to be cached", path)
+		}
+	}
+}
+
+func TestCachePage_NegativeExpireDisabled(t *testing.T) {
+	store := newRecordingStore()
+	r := newStatusRouter(store, Options{})
+	performRequest(r, "GET", "/404")
+	if len(store.expires) != 0 {
+		t.Errorf("Expected negative responses not to be cached by default, got %v", store.expires)
+	}
+}
+
+func TestEntryPoints_ReplayThroughSharedHelper(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	store.Set(urlEscape(PageCachePrefix, "/page"), ResponseCache{
+		Status: http.StatusCreated,
+		Header: http.Header{
+			"X-Custom":                    {"custom"},
+			"Access-Control-Allow-Origin": {"*"},
+			"Set-Cookie":                  {"session=secret"},
+		},
+		Data: []byte("cached"),
+		ETag: `"etag"`,
+	}, DEFAULT)
+	handler := func(c *gin.Context) {
+		c.String(http.StatusOK, "handler")
+	}
+
+	site := gin.New()
+	site.Use(SiteCache(store, time.Minute))
+	site.GET("/page", handler)
+	page := gin.New()
+	page.GET("/page", CachePage(store, time.Minute, handler))
+	cached := gin.New()
+	cached.Use(Cache(store))
+	cached.GET("/page", Cached(time.Minute), handler)
+
+	for name, tc := range map[string]struct {
+		r    *gin.Engine
+		cors bool
+	}{
+		"SiteCache": {site, true},
+		"CachePage": {page, true},
+		"Cached":    {cached, false},
+	} {
+		w := performRequest(tc.r, "GET", "/page")
+		if w.Code != http.StatusCreated || w.Header().Get("X-Custom") != "custom" || w.Header().Get("ETag") != `"etag"` {
+			t.Errorf("%s: expected the cached status and headers, got %d %v", name, w.Code, w.Header())
+		}
+		if _, found := w.Header()["Set-Cookie"]; found {
+			t.Errorf("%s: expected Set-Cookie to be excluded", name)
+		}
+		if _, found := w.Header()["Access-Control-Allow-Origin"]; found != tc.cors {
+			t.Errorf("%s: expected Access-Control headers replayed: %t", name, tc.cors)
+		}
+
+		w = performRequestWithHeader(tc.r, "GET", "/page", http.Header{"If-None-Match": {`"etag"`}})
+		if w.Code != http.StatusCreated {
+			t.Errorf("%s: expected conditional requests to only apply to 200 responses, got %d", name, w.Code)
+		}
+	}
+}
+
+func TestHitAbortsDownstreamHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for name, newRouter := range map[string]func(store CacheStore, downstream gin.HandlerFunc) *gin.Engine{
+		"SiteCache": func(store CacheStore, downstream gin.HandlerFunc) *gin.Engine {
+			r := gin.New()
+			r.Use(SiteCache(store, time.Minute))
+			r.GET("/page", func(c *gin.Context) {
+				c.String(http.StatusOK, "page")
+			}, downstream)
+			return r
+		},
+		"CachePage": func(store CacheStore, downstream gin.HandlerFunc) *gin.Engine {
+			r := gin.New()
+			r.GET("/page", CachePage(store, time.Minute, func(c *gin.Context) {
+				c.String(http.StatusOK, "page")
+			}), downstream)
+			return r
+		},
+	} {
+		store := NewInMemoryStore(time.Minute)
+		store.Set(urlEscape(PageCachePrefix, "/page"), ResponseCache{
+			Status: http.StatusOK,
+			Header: http.Header{},
+			Data:   []byte("cached"),
+		}, DEFAULT)
+		calls := 0
+		r := newRouter(store, func(c *gin.Context) {
+			calls++
+		})
+		w := performRequest(r, "GET", "/page")
+		if w.Body.String() != "cached" {
+			t.Errorf("%s: expected the cached page, got %q", name, w.Body.String())
+		}
+		if calls != 0 {
+			t.Errorf("%s: expected the downstream handler not to run on a hit, ran %d times", name, calls)
+		}
+	}
+}
+
+func TestCachePage_MaxBodyBytes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var errs []error
+	store := NewInMemoryStore(time.Minute)
+	options := Options{MaxBodyBytes: 6, OnError: func(err error) { errs = append(errs, err) }}
+	r := gin.New()
+	handler := func(c *gin.Context) {
+		c.Writer.WriteString("abc")
+		c.Writer.WriteString(c.Param("rest"))
+	}
+	r.GET("/page/:rest", CachePageWithOptions(store, time.Minute, options, handler))
+
+	expectBody(t, performRequest(r, "GET", "/page/def"), "abcdef")
+	var cache ResponseCache
+	if err := store.Get(urlEscape(PageCachePrefix, "/page/def"), &cache); err != nil {
+		t.Errorf("Expected a body within the limit to be cached, got: %s", err)
+	}
+
+	expectBody(t, performRequest(r, "GET", "/page/defg"), "abcdefg")
+	if err := store.Get(urlEscape(PageCachePrefix, "/page/defg"), &cache); err != ErrCacheMiss {
+		t.Errorf("Expected a body over the limit not to be cached, got: %v", err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], ErrBodyTooLarge) {
+		t.Errorf("Expected ErrBodyTooLarge to be reported once, got %v", errs)
+	}
+}
+
+func TestCachePage_Prefix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	r := gin.New()
+	r.GET("/v1/page", CachePageWithOptions(store, time.Minute, Options{Prefix: "v1", KeyFunc: func(c *gin.Context) string {
+		return "/page"
+	}}, func(c *gin.Context) {
+		c.String(http.StatusOK, "v1")
+	}))
+	r.GET("/v2/page", CachePageWithOptions(store, time.Minute, Options{Prefix: "v2", KeyFunc: func(c *gin.Context) string {
+		return "/page"
+	}}, func(c *gin.Context) {
+		c.String(http.StatusOK, "v2")
+	}))
+
+	expectBody(t, performRequest(r, "GET", "/v1/page"), "v1")
+	expectBody(t, performRequest(r, "GET", "/v2/page"), "v2")
+	for _, prefix := range []string{"v1", "v2"} {
+		var cache ResponseCache
+		if err := store.Get(urlEscape(prefix, "/page"), &cache); err != nil || string(cache.Data) != prefix {
+			t.Errorf("Expected the %s page under its own prefix, got %q, %v", prefix, cache.Data, err)
+		}
+	}
+	if err := InvalidateURLWithOptions(store, "/page", Options{Prefix: "v1"}); err != nil {
+		t.Errorf("Expected the v1 page to be invalidated, got: %s", err)
+	}
+	expectBody(t, performRequest(r, "GET", "/v2/page"), "v2")
+}
+
+func TestCachePage_ResponseCacheControl(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := newRecordingStore()
+	r := gin.New()
+	r.GET("/page", CachePage(store, time.Minute, func(c *gin.Context) {
+		if cc := c.Query("cc"); cc != "" {
+			c.Header("Cache-Control", cc)
+		}
+		c.String(http.StatusOK, "body")
+	}))
+
+	for query, expire := range map[string]time.Duration{
+		"":                                time.Minute,
+		"?cc=max-age%3D60":                60 * time.Second,
+		"?cc=public,+max-age%3D%2230%22":  30 * time.Second,
+		"?cc=max-age%3D60,+s-maxage%3D10": 10 * time.Second,
+		"?cc=max-age%3Dsoon":              time.Minute,
+	} {
+		performRequest(r, "GET", "/page"+query)
+		if got, found := store.expires[urlEscape(PageCachePrefix, "/page"+query)]; !found || got != expire {
+			t.Errorf("Expected %q to be stored for %s, got %s (stored: %t)", query, expire, got, found)
+		}
+	}
+	for _, query := range []string{"?cc=max-age%3D0", "?cc=no-store", "?cc=No-Store,+max-age%3D60"} {
+		performRequest(r, "GET", "/page"+query)
+		if _, found := store.expires[urlEscape(PageCachePrefix, "/page"+query)]; found {
+			t.Errorf("Expected %q not to be stored", query)
+		}
+	}
+}
+
+// newMethodsRouter serves /page for GET, HEAD and POST through
+// CachePageWithOptions, responding with the method and the call count.
+func newMethodsRouter(store CacheStore, options Options) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	count := 0
+	handler := CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+		count++
+		c.Header("X-Method", c.Request.Method)
+		c.String(http.StatusOK, "%s %d", c.Request.Method, count)
+	})
+	r := gin.New()
+	r.GET("/page", handler)
+	r.HEAD("/page", handler)
+	r.POST("/page", handler)
+	return r
+}
+
+func TestCachePage_Methods(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r := newMethodsRouter(store, Options{SeparateHead: true})
+	expectBody(t, performRequest(r, "POST", "/page"), "POST 1")
+	expectBody(t, performRequest(r, "POST", "/page"), "POST 2")
+	expectBody(t, performRequest(r, "GET", "/page"), "GET 3")
+
+	w := performRequest(r, "HEAD", "/page")
+	if w.Header().Get("X-Method") != "HEAD" {
+		t.Errorf("Expected HEAD not to be served the GET entry, got %s", w.Header().Get("X-Method"))
+	}
+	w = performRequest(r, "HEAD", "/page")
+	if w.Header().Get("X-Method") != "HEAD" || w.Body.Len() != 0 {
+		t.Errorf("Expected the cached HEAD entry without body, got %s %q", w.Header().Get("X-Method"), w.Body.String())
+	}
+	expectBody(t, performRequest(r, "GET", "/page"), "GET 3")
+
+	if err := InvalidateURL(store, "/page"); err != nil {
+		t.Errorf("Unexpected error invalidating: %s", err)
+	}
+	expectBody(t, performRequest(r, "GET", "/page"), "GET 5")
+	expectBody(t, performRequest(r, "HEAD", "/page"), "HEAD 6")
+}
+
+func TestCachePage_OptInMethods(t *testing.T) {
+	r := newMethodsRouter(NewInMemoryStore(time.Minute), Options{Methods: []string{"GET", "POST"}})
+	expectBody(t, performRequest(r, "POST", "/page"), "POST 1")
+	expectBody(t, performRequest(r, "POST", "/page"), "POST 1")
+	expectBody(t, performRequest(r, "GET", "/page"), "GET 2")
+}
+
+func TestCachePage_HeadFromGet(t *testing.T) {
+	r := newMethodsRouter(NewInMemoryStore(time.Minute), Options{})
+	expectBody(t, performRequest(r, "HEAD", "/page"), "HEAD 1")
+	expectBody(t, performRequest(r, "GET", "/page"), "GET 2")
+
+	w := performRequest(r, "HEAD", "/page")
+	if w.Code != http.StatusOK || w.Header().Get("X-Method") != "GET" || w.Body.Len() != 0 {
+		t.Errorf("Expected the GET status and headers without body, got %d %s %q", w.Code, w.Header().Get("X-Method"), w.Body.String())
+	}
+	if w.Header().Get("Content-Length") != "5" {
+		t.Errorf("Expected the Content-Length of the GET body, got %q", w.Header().Get("Content-Length"))
+	}
+	if w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("Expected the GET Content-Type, got %q", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestCachePage_Skip(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r := newCountingRouter(store, Options{Skip: func(c *gin.Context) bool {
+		return c.GetHeader("Authorization") != ""
+	}})
+	auth := http.Header{"Authorization": {"Bearer token"}}
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", auth), "1")
+	expectBody(t, performRequest(r, "GET", "/page"), "2")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", auth), "3")
+	expectBody(t, performRequest(r, "GET", "/page"), "2")
+}
+
+func TestCachePage_SetMaxAgeHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(NewInMemoryStore(time.Minute), 10*time.Second, Options{SetMaxAgeHeader: true}, func(c *gin.Context) {
+		c.Header("Cache-Control", "public")
+		c.String(http.StatusOK, "body")
+	}))
+	if w := performRequest(r, "GET", "/page"); w.Header().Get("Cache-Control") != "public" {
+		t.Errorf("Expected the handler Cache-Control on a miss, got %q", w.Header().Get("Cache-Control"))
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if w := performRequest(r, "GET", "/page"); w.Header().Get("Cache-Control") != "max-age=8" {
+		t.Errorf("Expected the remaining TTL as max-age on a hit, got %q", w.Header().Get("Cache-Control"))
+	}
+
+	// Stores that can't tell the TTL keep the stored header.
+	r = gin.New()
+	r.GET("/page", CachePageWithOptions(hiddenTTLStore{NewInMemoryStore(time.Minute)}, 10*time.Second, Options{SetMaxAgeHeader: true}, func(c *gin.Context) {
+		c.Header("Cache-Control", "public")
+		c.String(http.StatusOK, "body")
+	}))
+	performRequest(r, "GET", "/page")
+	if w := performRequest(r, "GET", "/page"); w.Header().Get("Cache-Control") != "public" {
+		t.Errorf("Expected the stored Cache-Control without TTLStore, got %q", w.Header().Get("Cache-Control"))
+	}
+}
+
+// hiddenTTLStore hides the TTL method of the wrapped store.
+type hiddenTTLStore struct {
+	CacheStore
+}
+
+func TestCachePage_ServeContent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	r := gin.New()
+	r.GET("/file", CachePageWithOptions(store, time.Minute, Options{CacheableStatus: func(status int) bool {
+		return status == http.StatusOK || status == http.StatusPartialContent
+	}}, func(c *gin.Context) {
+		http.ServeContent(c.Writer, c.Request, "file.txt", time.Time{}, strings.NewReader("0123456789"))
+	}))
+
+	w := performRequestWithHeader(r, "GET", "/file?range", http.Header{"Range": {"bytes=2-4"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+		t.Errorf("Expected the partial body, got %d %q", w.Code, w.Body.String())
+	}
+	var cache ResponseCache
+	if err := store.Get(urlEscape(PageCachePrefix, "/file?range"), &cache); err != ErrCacheMiss {
+		t.Errorf("Expected a partial response not to be stored, got: %v", err)
+	}
+
+	expectBody(t, performRequest(r, "GET", "/file"), "0123456789")
+	if err := store.Get(urlEscape(PageCachePrefix, "/file"), &cache); err != nil || string(cache.Data) != "0123456789" {
+		t.Errorf("Expected the full file to be stored, got %q, %v", cache.Data, err)
+	}
+}
+
+func TestCachePage_ResponsePrivate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	r := gin.New()
+	r.GET("/page", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.Header("Cache-Control", c.Query("cc"))
+		c.String(http.StatusOK, "body")
+	}))
+
+	for query, stored := range map[string]bool{
+		"?cc=private":                    false,
+		"?cc=private,+max-age%3D60":      false,
+		"?cc=no-store":                   false,
+		"?cc=public,+max-age%3D60":       true,
+		"?cc=Private%3D%22Set-Cookie%22": false,
+	} {
+		w := performRequest(r, "GET", "/page"+query)
+		if w.Header().Get("Cache-Control") == "" {
+			t.Errorf("Expected %q to reach the client", query)
+		}
+		var cache ResponseCache
+		if err := store.Get(urlEscape(PageCachePrefix, "/page"+query), &cache); (err == nil) != stored {
+			t.Errorf("Expected %q stored: %t, got: %v", query, stored, err)
+		}
+	}
+}
+
+func TestCachePage_KeyHost(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r := newCountingRouter(store, Options{KeyHost: true, KeyScheme: true})
+	request := func(host string, proto string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/page", nil)
+		req.Host = host
+		if proto != "" {
+			req.Header.Set("X-Forwarded-Proto", proto)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	expectBody(t, request("api.example.com", ""), "1")
+	expectBody(t, request("admin.example.com", ""), "2")
+	expectBody(t, request("API.example.com", ""), "1")
+	expectBody(t, request("api.example.com", "https"), "3")
+	expectBody(t, request("", ""), "4")
+	expectBody(t, request("", ""), "4")
+
+	if err := InvalidateURLWithOptions(store, "http://api.example.com/page", Options{KeyHost: true, KeyScheme: true}); err != nil {
+		t.Errorf("Unexpected error invalidating: %s", err)
+	}
+	expectBody(t, request("api.example.com", ""), "5")
+	expectBody(t, request("admin.example.com", ""), "2")
+}
+
+func TestCachePage_Buffered(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	r := gin.New()
+	r.GET("/:status", CachePageWithOptions(store, time.Minute, Options{Buffered: true, MaxBodyBytes: 10}, func(c *gin.Context) {
+		var status int
+		fmt.Sscan(c.Param("status"), &status)
+		c.Status(status)
+		c.Writer.WriteString("body")
+		if c.Writer.Written() {
+			t.Errorf("Expected a buffered response not to be sent before the handler is done")
+		}
+		c.Writer.WriteString(c.Query("more"))
+		c.Header("X-Late", "late")
+	}))
+
+	for _, path := range []string{"/200", "/500", "/200?more=-more-than-10"} {
+		for i := 0; i < 2; i++ {
+			w := performRequest(r, "GET", path)
+			status, body := http.StatusOK, "body"
+			if path == "/500" {
+				status = http.StatusInternalServerError
+			} else if path != "/200" {
+				body = "body-more-than-10"
+			}
+			if w.Code != status || w.Body.String() != body {
+				t.Errorf("%s: expected %d %q, got %d %q", path, status, body, w.Code, w.Body.String())
+			}
+		}
+	}
+	if w := performRequest(r, "GET", "/200"); w.Header().Get("X-Late") != "late" {
+		t.Errorf("Expected headers set after the body to be sent and stored in buffered mode")
+	}
+	var cache ResponseCache
+	if err := store.Get(urlEscape(PageCachePrefix, "/200?more=-more-than-10"), &cache); err != ErrCacheMiss {
+		t.Errorf("Expected a body over the limit not to be stored, got: %v", err)
+	}
+}
+
+func TestCachePage_BufferedPanic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	r := gin.New()
+	r.Use(gin.RecoveryWithWriter(io.Discard))
+	r.Use(func(c *gin.Context) {
+		defer func() {
+			if _, ok := c.Writer.(*cachedWriter); ok {
+				t.Errorf("Expected the writer to be restored after a panic")
+			}
+		}()
+		c.Next()
+	})
+	r.GET("/:page", CachePageWithOptions(store, time.Minute, Options{Buffered: true}, func(c *gin.Context) {
+		if c.Param("page") == "late" {
+			c.String(http.StatusOK, "partial")
+		}
+		panic("handler failed")
+	}))
+
+	for i := 0; i < 2; i++ {
+		if w := performRequest(r, "GET", "/early"); w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected the recovery status, got %d %q", w.Code, w.Body.String())
+		}
+		// What was written before the panic is sent, as it is unbuffered.
+		if w := performRequest(r, "GET", "/late"); w.Code != http.StatusOK || w.Body.String() != "partial" {
+			t.Errorf("Expected the partial response, got %d %q", w.Code, w.Body.String())
+		}
+	}
+	var cache ResponseCache
+	for _, path := range []string{"/early", "/late"} {
+		if err := store.Get(urlEscape(PageCachePrefix, path), &cache); err != ErrCacheMiss {
+			t.Errorf("Expected %s not to be stored after a panic, got: %v", path, err)
+		}
+	}
+}
+
+func TestCachePage_ContextTTL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := newRecordingStore()
+	r := gin.New()
+	r.GET("/page", CachePage(store, time.Minute, func(c *gin.Context) {
+		switch c.Query("ttl") {
+		case "30s":
+			c.Set(CACHE_TTL_KEY, 30*time.Second)
+		case "forever":
+			c.Set(CACHE_TTL_KEY, FOREVER)
+		case "zero":
+			c.Set(CACHE_TTL_KEY, time.Duration(0))
+		case "wrong":
+			c.Set(CACHE_TTL_KEY, 30)
+		}
+		c.String(http.StatusOK, "body")
+	}))
+
+	for query, expire := range map[string]time.Duration{
+		"":             time.Minute,
+		"?ttl=30s":     30 * time.Second,
+		"?ttl=forever": FOREVER,
+		"?ttl=wrong":   time.Minute,
+	} {
+		performRequest(r, "GET", "/page"+query)
+		if got, found := store.expires[urlEscape(PageCachePrefix, "/page"+query)]; !found || got != expire {
+			t.Errorf("Expected %q to be stored for %s, got %s (stored: %t)", query, expire, got, found)
+		}
+	}
+	performRequest(r, "GET", "/page?ttl=zero")
+	if _, found := store.expires[urlEscape(PageCachePrefix, "/page?ttl=zero")]; found {
+		t.Errorf("Expected a zero ttl not to be stored")
+	}
+}
+
+func TestCachePage_SkipQueryStrings(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r := newCountingRouter(store, Options{SkipQueryStrings: true})
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+	expectBody(t, performRequest(r, "GET", "/page?a=1"), "2")
+	expectBody(t, performRequest(r, "GET", "/page?a=1"), "3")
+
+	// Ignored parameters don't make the url dynamic.
+	r = newCountingRouter(NewInMemoryStore(time.Minute), Options{SkipQueryStrings: true, NormalizeQuery: true, IgnoreQueryParams: []string{"utm_*"}})
+	expectBody(t, performRequest(r, "GET", "/page?utm_source=mail"), "1")
+	expectBody(t, performRequest(r, "GET", "/page?utm_source=feed"), "1")
+	expectBody(t, performRequest(r, "GET", "/page?utm_source=mail&a=1"), "2")
+	expectBody(t, performRequest(r, "GET", "/page?utm_source=mail&a=1"), "3")
+}
+
+func TestCachePage_SkipEmptyBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	r := gin.New()
+	r.GET("/page/:body", CachePageWithOptions(store, time.Minute, Options{SkipEmptyBody: true}, func(c *gin.Context) {
+		if body := c.Param("body"); body != "empty" {
+			c.String(http.StatusOK, body)
+		}
+	}))
+
+	var cache ResponseCache
+	performRequest(r, "GET", "/page/empty")
+	if err := store.Get(urlEscape(PageCachePrefix, "/page/empty"), &cache); err != ErrCacheMiss {
+		t.Errorf("Expected an empty body not to be cached, got: %v", err)
+	}
+	expectBody(t, performRequest(r, "GET", "/page/full"), "full")
+	if err := store.Get(urlEscape(PageCachePrefix, "/page/full"), &cache); err != nil {
+		t.Errorf("Expected a body to be cached, got: %v", err)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := newRecordingStore()
+	var keys []string
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Next()
+		key, ok := CacheKey(c)
+		if !ok {
+			t.Errorf("Expected the key to be set on the context")
+		}
+		keys = append(keys, key)
+	})
+	r.Use(Cache(store))
+	r.GET("/page", Cached(time.Minute), func(c *gin.Context) {
+		c.String(http.StatusOK, "body")
+	})
+	r.GET("/other", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(http.StatusOK, "body")
+	}))
+
+	performRequest(r, "GET", "/page?id=1")
+	performRequest(r, "GET", "/other")
+	for i, path := range []string{"/page?id=1", "/other"} {
+		if _, found := store.expires[keys[i]]; !found || keys[i] != urlEscape(PageCachePrefix, path) {
+			t.Errorf("Expected the key the store received for %s, got %q", path, keys[i])
+		}
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if _, ok := CacheKey(c); ok {
+		t.Errorf("Expected no key outside of the middlewares")
+	}
+}
+
+func TestCachePage_Flush(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, buffered := range []bool{false, true} {
+		store := NewInMemoryStore(time.Minute)
+		r := gin.New()
+		r.GET("/stream", CachePageWithOptions(store, time.Minute, Options{Buffered: buffered}, func(c *gin.Context) {
+			c.Writer.WriteString("event: 1\n")
+			c.Writer.Flush()
+			c.Writer.WriteString("event: 2\n")
+		}))
+
+		w := performRequest(r, "GET", "/stream")
+		if !w.Flushed || w.Body.String() != "event: 1\nevent: 2\n" {
+			t.Errorf("Buffered %t: expected the stream to be flushed to the client, got %q", buffered, w.Body.String())
+		}
+		var cache ResponseCache
+		if err := store.Get(urlEscape(PageCachePrefix, "/stream"), &cache); err != ErrCacheMiss {
+			t.Errorf("Buffered %t: expected a flushed response not to be cached, got %v", buffered, err)
+		}
+	}
+}
+
+func TestCachePage_WriteHeaderAfterWrite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, buffered := range []bool{false, true} {
+		store := NewInMemoryStore(time.Minute)
+		options := Options{Buffered: buffered, CacheableStatus: func(status int) bool { return status < 300 }}
+		r := gin.New()
+		r.GET("/ordered", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+			c.Writer.WriteHeader(http.StatusCreated)
+			c.Writer.WriteString("created")
+		}))
+		r.GET("/misuse", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+			c.Writer.WriteString("ok")
+			c.Writer.WriteHeader(http.StatusCreated)
+		}))
+
+		for path, status := range map[string]int{"/ordered": http.StatusCreated, "/misuse": http.StatusOK} {
+			if w := performRequest(r, "GET", path); w.Code != status {
+				t.Errorf("Buffered %t: expected %s to send %d, got %d", buffered, path, status, w.Code)
+			}
+			var cache ResponseCache
+			if err := store.Get(urlEscape(PageCachePrefix, path), &cache); err != nil || cache.Status != status {
+				t.Errorf("Buffered %t: expected %s to be cached with %d, got %d: %v", buffered, path, status, cache.Status, err)
+			}
+			if w := performRequest(r, "GET", path); w.Code != status {
+				t.Errorf("Buffered %t: expected %s to be replayed with %d, got %d", buffered, path, status, w.Code)
+			}
+		}
+	}
+}
+
+func TestCachePage_TransformOnStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(NewInMemoryStore(time.Minute), time.Minute, Options{
+		TransformOnStore: func(c *gin.Context, body []byte) []byte {
+			return bytes.Replace(body, []byte("token=secret"), []byte("token="), -1)
+		},
+	}, func(c *gin.Context) {
+		c.String(http.StatusOK, "form token=secret")
+	}))
+
+	expectBody(t, performRequest(r, "GET", "/page"), "form token=secret")
+	expectBody(t, performRequest(r, "GET", "/page"), "form token=")
+}
+
+func TestCachePage_TransformOnServe(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, compress := range []bool{false, true} {
+		r := gin.New()
+		r.GET("/page", CachePageWithOptions(NewInMemoryStore(time.Minute), time.Minute, Options{
+			Compress: compress,
+			TransformOnServe: func(c *gin.Context, body []byte) []byte {
+				return append([]byte("<p>cached</p>"), body...)
+			},
+		}, func(c *gin.Context) {
+			c.Header("Content-Length", "4")
+			c.String(http.StatusOK, "page")
+		}))
+
+		expectBody(t, performRequest(r, "GET", "/page"), "page")
+		w := performRequestWithHeader(r, "GET", "/page", http.Header{"Accept-Encoding": {"gzip"}})
+		expectBody(t, w, "<p>cached</p>page")
+		if length := w.Header().Get("Content-Length"); length != "17" {
+			t.Errorf("Compress %t: expected Content-Length 17, got %q", compress, length)
+		}
+		if encoding := w.Header().Get("Content-Encoding"); encoding != "" {
+			t.Errorf("Compress %t: expected the transformed body to be served uncompressed, got %q", compress, encoding)
+		}
+	}
+}
+
+func TestCachePage_ContentLength(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	body := "the page content"
+	for _, transformed := range []string{"short", "a longer body than the page content"} {
+		for _, compress := range []bool{false, true} {
+			r := gin.New()
+			r.GET("/page", CachePageWithOptions(NewInMemoryStore(time.Minute), time.Minute, Options{
+				Compress:        compress,
+				CompressMinSize: 1,
+				TransformOnStore: func(c *gin.Context, body []byte) []byte {
+					return []byte(transformed)
+				},
+			}, func(c *gin.Context) {
+				c.Header("Content-Length", fmt.Sprint(len(body)))
+				c.String(http.StatusOK, body)
+			}))
+			server := httptest.NewServer(r)
+
+			for i := 0; i < 2; i++ {
+				req, _ := http.NewRequest("GET", server.URL+"/page", nil)
+				req.Header.Set("Accept-Encoding", "gzip")
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("Error requesting the page: %v", err)
+				}
+				data, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err == nil && resp.Header.Get("Content-Encoding") == "gzip" {
+					data, err = gunzipBytes(data)
+				}
+				if i == 1 && (err != nil || string(data) != transformed) {
+					t.Errorf("Compress %t: expected the whole body %q, got %q: %v", compress, transformed, data, err)
+				}
+			}
+			server.Close()
+		}
+	}
+}
+
+func TestVaryKey_Normalization(t *testing.T) {
+	names := varyHeaders(http.Header{"Vary": {"accept-encoding, User-Agent", "Accept-Encoding"}})
+	if len(names) != 2 || names[0] != "Accept-Encoding" || names[1] != "User-Agent" {
+		t.Fatalf("Expected canonical, deduplicated names, got %q", names)
+	}
+	key := func(header http.Header) string {
+		r, _ := http.NewRequest("GET", "/page", nil)
+		r.Header = header
+		return varyKey("page", names, r, applyDefaults(Options{}))
+	}
+	want := key(http.Header{"Accept-Encoding": {"deflate,gzip"}, "User-Agent": {"agent"}})
+	for _, header := range []http.Header{
+		{"Accept-Encoding": {"gzip, deflate"}, "User-Agent": {"agent"}},
+		{"Accept-Encoding": {" GZIP ,Deflate "}, "User-Agent": {"agent"}},
+		{"Accept-Encoding": {"gzip", "deflate"}, "User-Agent": {"agent"}},
+	} {
+		if got := key(header); got != want {
+			t.Errorf("Expected %v to share the variant %q, got %q", header, want, got)
+		}
+	}
+	if key(http.Header{"Accept-Encoding": {"gzip"}, "User-Agent": {"AGENT"}}) == key(http.Header{"Accept-Encoding": {"gzip"}, "User-Agent": {"agent"}}) {
+		t.Errorf("Expected the values of other headers to stay case sensitive")
+	}
+}
+
+func TestCachePage_VaryNormalization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	r := gin.New()
+	r.GET("/page", CachePage(NewInMemoryStore(time.Minute), time.Minute, func(c *gin.Context) {
+		calls++
+		c.Header("Vary", "accept-encoding")
+		c.String(http.StatusOK, fmt.Sprint(calls))
+	}))
+
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", http.Header{"Accept-Encoding": {"gzip, deflate"}}), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", http.Header{"Accept-Encoding": {"Deflate,GZIP"}}), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", http.Header{"Accept-Encoding": {"br"}}), "2")
+}
+
+func TestCacheFunc(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	stores := map[string]CacheStore{
+		"a": NewInMemoryStore(time.Minute),
+		"b": NewInMemoryStore(time.Minute),
+	}
+	calls := 0
+	r := gin.New()
+	r.Use(CacheFunc(func(c *gin.Context) CacheStore {
+		if store, ok := stores[c.GetHeader("X-Tenant")]; ok {
+			return store
+		}
+		return nil
+	}))
+	r.GET("/page", Cached(time.Minute), func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, c.GetHeader("X-Tenant")+fmt.Sprint(calls))
+	})
+
+	a := http.Header{"X-Tenant": {"a"}}
+	b := http.Header{"X-Tenant": {"b"}}
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", a), "a1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", b), "b2")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", a), "a1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", b), "b2")
+	for tenant, body := range map[string]string{"a": "a1", "b": "b2"} {
+		var cache ResponseCache
+		if err := stores[tenant].Get(urlEscape(PageCachePrefix, "/page"), &cache); err != nil || string(cache.Data) != body {
+			t.Errorf("Expected the page of tenant %s in its store, got %q: %v", tenant, cache.Data, err)
+		}
+	}
+
+	// Without a store, the page isn't cached.
+	expectBody(t, performRequest(r, "GET", "/page"), "3")
+	expectBody(t, performRequest(r, "GET", "/page"), "4")
+}
+
+func TestCachePage_KeyCookies(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r := newCountingRouter(store, Options{KeyCookies: []string{"theme", "locale"}})
+
+	fr := http.Header{"Cookie": {"locale=fr; session=1"}}
+	frOtherSession := http.Header{"Cookie": {"session=2; locale=fr"}}
+	en := http.Header{"Cookie": {"locale=en"}}
+	enDark := http.Header{"Cookie": {"locale=en; theme=dark"}}
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", fr), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", frOtherSession), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", en), "2")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", enDark), "3")
+	expectBody(t, performRequest(r, "GET", "/page"), "4")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", http.Header{"Cookie": {"other=1"}}), "4")
+
+	var cache ResponseCache
+	if err := store.Get(urlEscape(PageCachePrefix, "/page locale=fr&theme="), &cache); err != nil {
+		t.Errorf("Expected the cookie values to be appended to the key, got: %v", err)
+	}
+}
+
+func TestCachePage_KeyContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	calls := 0
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if role := c.GetHeader("X-Role"); role != "" {
+			c.Set("role", role)
+		}
+	})
+	r.GET("/page", CachePageWithOptions(store, time.Minute, Options{KeyContext: []string{"role"}}, func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, fmt.Sprint(calls))
+	}))
+
+	admin := http.Header{"X-Role": {"admin"}}
+	user := http.Header{"X-Role": {"user"}}
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", admin), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", user), "2")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", admin), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", user), "2")
+	expectBody(t, performRequest(r, "GET", "/page"), "3")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", http.Header{"X-Role": {"!"}}), "4")
+	expectBody(t, performRequest(r, "GET", "/page"), "3")
+
+	var cache ResponseCache
+	if err := store.Get(urlEscape(PageCachePrefix, "/page ctx:role=admin"), &cache); err != nil {
+		t.Errorf("Expected the role to be appended to the key, got: %v", err)
+	}
+}
+
+func TestCachePage_MustCache(t *testing.T) {
+	maintenance := false
+	r := newCountingRouter(NewInMemoryStore(time.Minute), Options{
+		MustCache:  func(*gin.Context) bool { return maintenance },
+		RetryAfter: 90 * time.Second,
+	})
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+
+	maintenance = true
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+	w := performRequestWithHeader(r, "GET", "/page", http.Header{"Cache-Control": {"no-cache"}})
+	expectBody(t, w, "1")
+	w = performRequest(r, "GET", "/page?uncached")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "90" {
+		t.Errorf("Expected 503 with Retry-After 90 for an uncached page, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// The handler wasn't called meanwhile.
+	maintenance = false
+	expectBody(t, performRequest(r, "GET", "/page?uncached"), "2")
+}
+
+func TestCached_RouteErrorExpire(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	calls := 0
+	r := gin.New()
+	r.HandleMethodNotAllowed = true
+	r.Use(Cache(store), CachedWithOptions(time.Minute, Options{RouteErrorExpire: 10 * time.Second, Methods: []string{"GET", "POST"}}))
+	r.GET("/gone", func(c *gin.Context) {
+		calls++
+		c.String(http.StatusNotFound, fmt.Sprint(calls))
+	})
+	r.NoRoute(func(c *gin.Context) {
+		calls++
+		c.String(http.StatusNotFound, "no route %d", calls)
+	})
+
+	// The error pages of the router are cached, not those of the handlers.
+	expectBody(t, performRequest(r, "GET", "/gone"), "1")
+	expectBody(t, performRequest(r, "GET", "/gone"), "2")
+	for i := 0; i < 2; i++ {
+		w := performRequest(r, "GET", "/missing")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for a missing route, got %d", w.Code)
+		}
+		expectBody(t, w, "no route 3")
+	}
+	var cache ResponseCache
+	if err := store.Get(urlEscape(PageCachePrefix, "/missing"), &cache); err != nil || cache.Status != http.StatusNotFound {
+		t.Errorf("Expected the 404 page to be stored, got %d: %v", cache.Status, err)
+	}
+	if ttl, _ := store.TTL(urlEscape(PageCachePrefix, "/missing")); ttl > 10*time.Second {
+		t.Errorf("Expected the 404 page to be stored for RouteErrorExpire, got %s", ttl)
+	}
+
+	// gin's default messages are captured too.
+	for i := 0; i < 2; i++ {
+		w := performRequest(r, "POST", "/gone")
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected 405, got %d", w.Code)
+		}
+		expectBody(t, w, "405 method not allowed")
+	}
+	if err := store.Get(urlEscape(PageCachePrefix+":POST", "/gone"), &cache); err != nil || string(cache.Data) != "405 method not allowed" {
+		t.Errorf("Expected the 405 page to be stored, got %q: %v", cache.Data, err)
+	}
+
+	// The error pages are invalidated when the routes change.
+	if err := InvalidateTag(store, ROUTE_ERROR_TAG); err != nil {
+		t.Fatalf("Error invalidating the error pages: %v", err)
+	}
+	expectBody(t, performRequest(r, "GET", "/missing"), "no route 4")
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseCacheControl splits a Cache-Control header into its directives. Names
+// are lower cased, directives without an argument map to an empty string.
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, value = part[:i], strings.Trim(strings.TrimSpace(part[i+1:]), `"`)
+		}
+		directives[strings.ToLower(strings.TrimSpace(name))] = value
+	}
+	return directives
+}
+
+// requestDirectives reports whether the client asked to bypass the cache
+// entirely (no-store) or to skip reading a cached copy (no-cache).
+func requestDirectives(r *http.Request) (noStore bool, noCache bool) {
+	directives := parseCacheControl(r.Header.Get("Cache-Control"))
+	_, noStore = directives["no-store"]
+	_, noCache = directives["no-cache"]
+	if strings.EqualFold(strings.TrimSpace(r.Header.Get("Pragma")), "no-cache") {
+		noCache = true
+	}
+	return noStore, noCache
+}
+
+// responseExpire returns how long a response may be stored according to its
+// Cache-Control header, falling back to expire when it doesn't say. s-maxage
+// takes precedence over max-age as the page cache is shared between clients.
+// It returns false when the response must not be stored, either at all
+// (no-store) or by a cache shared between clients (private).
+func responseExpire(header http.Header, expire time.Duration) (time.Duration, bool) {
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	for _, name := range []string{"no-store", "private"} {
+		if _, found := directives[name]; found {
+			return 0, false
+		}
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		value, found := directives[name]
+		if !found {
+			continue
+		}
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+		if seconds <= 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	return expire, true
+}
+
+// staleIfError returns how long a response may be served in place of errors
+// past its expiration, according to the stale-if-error directive of its
+// Cache-Control header, falling back to grace when it doesn't say.
+func staleIfError(header http.Header, grace time.Duration) time.Duration {
+	value, found := parseCacheControl(header.Get("Cache-Control"))["stale-if-error"]
+	if !found {
+		return grace
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return grace
+	}
+	return time.Duration(seconds) * time.Second
+}
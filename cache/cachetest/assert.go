@@ -0,0 +1,89 @@
+package cachetest
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/contrib/cache"
+)
+
+// AssertStored fails the test unless store holds a page at key with body and
+// ttl left before it expires. For pages varying by request headers, key is
+// the key of the stored variant. Compressed bodies are compared as stored.
+func AssertStored(t testing.TB, store *Store, key string, body []byte, ttl time.Duration) {
+	t.Helper()
+	var page cache.ResponseCache
+	if err := store.peek(key, &page); err != nil {
+		t.Errorf("Expected a page to be stored at %q, got %v", key, err)
+		return
+	}
+	if !bytes.Equal(page.Data, body) {
+		t.Errorf("Expected the page at %q to hold %q, got %q", key, body, page.Data)
+	}
+	if left := store.ttl(key); left != ttl {
+		t.Errorf("Expected the page at %q to expire in %s, got %s", key, ttl, left)
+	}
+}
+
+// AssertNotStored fails the test if store holds a live entry at key.
+func AssertNotStored(t testing.TB, store *Store, key string) {
+	t.Helper()
+	var page cache.ResponseCache
+	if err := store.peek(key, &page); err != cache.ErrCacheMiss {
+		t.Errorf("Expected nothing to be stored at %q, got %v", key, err)
+	}
+}
+
+// AssertHit fails the test unless the last lookup of key found it.
+func AssertHit(t testing.TB, store *Store, key string) {
+	t.Helper()
+	if op, found := store.lastGet(key); !found || op.Err != nil {
+		t.Errorf("Expected a hit on %q, got %v", key, op.Err)
+	}
+}
+
+// AssertMiss fails the test unless the last lookup of key missed.
+func AssertMiss(t testing.TB, store *Store, key string) {
+	t.Helper()
+	if op, found := store.lastGet(key); !found || op.Err != cache.ErrCacheMiss {
+		t.Errorf("Expected a miss on %q, got %v", key, op.Err)
+	}
+}
+
+// peek is Get without recording the operation.
+func (s *Store) peek(key string, value interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, found := s.lookup(key)
+	if !found {
+		return cache.ErrCacheMiss
+	}
+	return cache.GobCodec{}.Unmarshal(it.data, value)
+}
+
+// ttl is TTL without recording the operation, 0 for a missing key.
+func (s *Store) ttl(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, found := s.lookup(key)
+	switch {
+	case !found:
+		return 0
+	case it.expires.IsZero():
+		return cache.FOREVER
+	}
+	return it.expires.Sub(s.Clock.Now())
+}
+
+// lastGet returns the last Get of key recorded.
+func (s *Store) lastGet(key string) (Op, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.ops) - 1; i >= 0; i-- {
+		if op := s.ops[i]; op.Name == "Get" && op.Key == key {
+			return op, true
+		}
+	}
+	return Op{}, false
+}
@@ -0,0 +1,106 @@
+package cachetest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/contrib/cache"
+	"github.com/gin-gonic/gin"
+)
+
+func newRouter(store cache.CacheStore) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	r := gin.New()
+	r.GET("/page", cache.CachePage(store, time.Minute, func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, fmt.Sprint(calls))
+	}))
+	return r
+}
+
+func get(r http.Handler, path string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestStore_HitAndMiss(t *testing.T) {
+	store := NewStore(time.Hour)
+	r := newRouter(store)
+	key, err := cache.PageKey("GET", "/page", cache.Options{})
+	if err != nil {
+		t.Fatalf("Unexpected error building the key: %s", err)
+	}
+
+	if w := get(r, "/page"); w.Body.String() != "1" {
+		t.Errorf("Expected the handler to run, got %q", w.Body.String())
+	}
+	AssertMiss(t, store, key)
+	AssertStored(t, store, key, []byte("1"), time.Minute)
+
+	store.Clock.Advance(30 * time.Second)
+	if w := get(r, "/page"); w.Body.String() != "1" {
+		t.Errorf("Expected the cached page, got %q", w.Body.String())
+	}
+	AssertHit(t, store, key)
+	AssertStored(t, store, key, []byte("1"), 30*time.Second)
+
+	store.Clock.Advance(30 * time.Second)
+	AssertNotStored(t, store, key)
+	if w := get(r, "/page"); w.Body.String() != "2" {
+		t.Errorf("Expected the handler to run once the page expired, got %q", w.Body.String())
+	}
+	AssertMiss(t, store, key)
+}
+
+func TestStore_Ops(t *testing.T) {
+	store := NewStore(time.Hour)
+	store.Set("a", 1, cache.DEFAULT)
+	var i int
+	store.Get("b", &i)
+	if n, err := store.Increment("a", 2); err != nil || n != 3 {
+		t.Errorf("Expected 3, got %d: %v", n, err)
+	}
+
+	ops := store.Ops()
+	expected := []Op{{"Set", "a", cache.DEFAULT, nil}, {"Get", "b", 0, cache.ErrCacheMiss}, {"Increment", "a", 0, nil}}
+	if len(ops) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, ops)
+	}
+	for i := range ops {
+		if ops[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], ops[i])
+		}
+	}
+
+	store.Reset()
+	if ops := store.Ops(); len(ops) != 0 {
+		t.Errorf("Expected no operations after a reset, got %v", ops)
+	}
+}
+
+func TestStore_Expiration(t *testing.T) {
+	store := NewStore(time.Hour)
+	store.Set("default", 1, cache.DEFAULT)
+	store.Set("short", 1, time.Second)
+	store.Set("forever", 1, cache.FOREVER)
+
+	store.Clock.Advance(time.Second)
+	var i int
+	if err := store.Get("short", &i); err != cache.ErrCacheMiss {
+		t.Errorf("Expected the entry to expire, got %v", err)
+	}
+	if ttl, err := store.TTL("default"); err != nil || ttl != time.Hour-time.Second {
+		t.Errorf("Expected the default expiration to apply, got %s: %v", ttl, err)
+	}
+
+	store.Clock.Advance(100 * 365 * 24 * time.Hour)
+	if err := store.Get("forever", &i); err != nil || i != 1 {
+		t.Errorf("Expected the entry never to expire, got %d: %v", i, err)
+	}
+}
@@ -0,0 +1,257 @@
+// Package cachetest provides a store and assertions to test handlers using
+// the cache middlewares.
+//
+//	store := cachetest.NewStore(time.Minute)
+//	r.GET("/page", cache.CachePage(store, time.Minute, handler))
+//	...
+//	key, _ := cache.PageKey("GET", "/page", cache.Options{})
+//	cachetest.AssertStored(t, store, key, []byte("body"), time.Minute)
+//	store.Clock.Advance(time.Minute)
+//	cachetest.AssertNotStored(t, store, key)
+package cachetest
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/contrib/cache"
+)
+
+// Clock is a manual time source, which only moves when advanced.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a clock stopped at now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Op is an operation recorded by a Store.
+type Op struct {
+	// Name is the name of the store method, e.g. "Get" or "Set".
+	Name string
+	Key  string
+	// Expire is the expiration passed to Set, Add and Replace.
+	Expire time.Duration
+	// Err is the error returned, e.g. cache.ErrCacheMiss for a miss.
+	Err error
+}
+
+// Store is an in memory cache.CacheStore recording the operations made on it.
+// Entries expire according to its Clock, so tests can fast-forward expiry
+// instead of sleeping; set cache.Options.Now to Clock.Now so the middlewares
+// follow the same clock, e.g. for stale pages. Values are encoded with gob, as
+// the network stores do.
+// It is safe for concurrent use.
+type Store struct {
+	// Clock is the time source of the expirations, stopped at the time the
+	// store was created.
+	Clock *Clock
+
+	mu                sync.Mutex
+	defaultExpiration time.Duration
+	items             map[string]item
+	ops               []Op
+}
+
+type item struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewStore returns an empty store.
+func NewStore(defaultExpiration time.Duration) *Store {
+	return &Store{
+		Clock:             NewClock(time.Now()),
+		defaultExpiration: defaultExpiration,
+		items:             make(map[string]item),
+	}
+}
+
+// Ops returns the operations made on the store so far, oldest first.
+func (s *Store) Ops() []Op {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Op(nil), s.ops...)
+}
+
+// Reset forgets the recorded operations, keeping the entries.
+func (s *Store) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops = nil
+}
+
+func (s *Store) record(name string, key string, expire time.Duration, err error) error {
+	s.ops = append(s.ops, Op{name, key, expire, err})
+	return err
+}
+
+// lookup returns the live entry at key, dropping it if it has expired.
+func (s *Store) lookup(key string) (item, bool) {
+	it, found := s.items[key]
+	if found && !it.expires.IsZero() && !s.Clock.Now().Before(it.expires) {
+		delete(s.items, key)
+		return item{}, false
+	}
+	return it, found
+}
+
+func (s *Store) Get(key string, value interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, found := s.lookup(key)
+	if !found {
+		return s.record("Get", key, 0, cache.ErrCacheMiss)
+	}
+	return s.record("Get", key, 0, cache.GobCodec{}.Unmarshal(it.data, value))
+}
+
+func (s *Store) Set(key string, value interface{}, expire time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record("Set", key, expire, s.store(key, value, expire))
+}
+
+func (s *Store) Add(key string, value interface{}, expire time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.lookup(key); found {
+		return s.record("Add", key, expire, cache.ErrNotStored)
+	}
+	return s.record("Add", key, expire, s.store(key, value, expire))
+}
+
+func (s *Store) Replace(key string, value interface{}, expire time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.lookup(key); !found {
+		return s.record("Replace", key, expire, cache.ErrNotStored)
+	}
+	return s.record("Replace", key, expire, s.store(key, value, expire))
+}
+
+func (s *Store) store(key string, value interface{}, expire time.Duration) error {
+	data, err := cache.GobCodec{}.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if expire == cache.DEFAULT {
+		expire = s.defaultExpiration
+	}
+	var expires time.Time
+	if expire > 0 {
+		expires = s.Clock.Now().Add(expire)
+	}
+	s.items[key] = item{data, expires}
+	return nil
+}
+
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.lookup(key); !found {
+		return s.record("Delete", key, 0, cache.ErrCacheMiss)
+	}
+	delete(s.items, key)
+	return s.record("Delete", key, 0, nil)
+}
+
+func (s *Store) Increment(key string, n uint64) (uint64, error) {
+	return s.add("Increment", key, func(current uint64) uint64 {
+		return current + n
+	})
+}
+
+func (s *Store) Decrement(key string, n uint64) (uint64, error) {
+	return s.add("Decrement", key, func(current uint64) uint64 {
+		if n > current {
+			return 0
+		}
+		return current - n
+	})
+}
+
+// add replaces the integer stored at key with op applied to it, keeping its
+// expiration. Integers are stored as text by the codec.
+func (s *Store) add(name string, key string, op func(uint64) uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, found := s.lookup(key)
+	if !found {
+		return 0, s.record(name, key, 0, cache.ErrCacheMiss)
+	}
+	current, err := strconv.ParseUint(string(it.data), 10, 64)
+	if err != nil {
+		return 0, s.record(name, key, 0, cache.ErrNotSupport)
+	}
+	result := op(current)
+	it.data = []byte(strconv.FormatUint(result, 10))
+	s.items[key] = it
+	return result, s.record(name, key, 0, nil)
+}
+
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = make(map[string]item)
+	return s.record("Flush", "", 0, nil)
+}
+
+// FlushPrefix removes all the keys starting with prefix.
+func (s *Store) FlushPrefix(prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.items {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.items, key)
+		}
+	}
+	return s.record("FlushPrefix", prefix, 0, nil)
+}
+
+// TTL returns the time left before key expires, or cache.FOREVER.
+func (s *Store) TTL(key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, found := s.lookup(key)
+	if !found {
+		return 0, s.record("TTL", key, 0, cache.ErrCacheMiss)
+	}
+	ttl := cache.FOREVER
+	if !it.expires.IsZero() {
+		ttl = it.expires.Sub(s.Clock.Now())
+	}
+	return ttl, s.record("TTL", key, 0, nil)
+}
+
+// Keys returns the live keys starting with prefix.
+func (s *Store) Keys(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.items {
+		if _, found := s.lookup(key); found && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, s.record("Keys", prefix, 0, nil)
+}
@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"encoding/json"
+)
+
+// Codec encodes the values kept by the network stores.
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, ptrValue interface{}) error
+}
+
+// GobCodec encodes values with gob. Integers are stored as text so the store
+// can increment them, and []byte values are stored as is. It is the default
+// codec of the network stores.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(value interface{}) ([]byte, error) {
+	return serialize(value)
+}
+
+func (GobCodec) Unmarshal(data []byte, ptrValue interface{}) error {
+	return deserialize(data, ptrValue)
+}
+
+// JSONCodec encodes values as JSON, so they can be inspected with the store
+// tools, e.g. redis-cli. Integers are stored as text as well. Note that
+// []byte values, such as the body of cached pages, are base64 encoded, and
+// that only exported fields are kept.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec) Unmarshal(data []byte, ptrValue interface{}) error {
+	return json.Unmarshal(data, ptrValue)
+}
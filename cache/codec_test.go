@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testCodecRoundTrip(t *testing.T, codec Codec) {
+	want := ResponseCache{
+		Status:    http.StatusOK,
+		Header:    http.Header{"Content-Type": {"application/octet-stream"}, "X-Multi": {"a", "b"}},
+		Data:      []byte{0x00, 0xff, 0x1f, 0x8b, '"', '\\', 0x80},
+		Timestamp: time.Date(2015, 3, 4, 5, 6, 7, 0, time.UTC),
+		ETag:      `"etag"`,
+	}
+	data, err := codec.Marshal(want)
+	if err != nil {
+		t.Fatalf("Error marshaling: %s", err)
+	}
+	var got ResponseCache
+	if err := codec.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Error unmarshaling: %s", err)
+	}
+	if !bytes.Equal(got.Data, want.Data) {
+		t.Errorf("Expected the binary body to round trip, got %v", got.Data)
+	}
+	if !reflect.DeepEqual(got.Header, want.Header) || got.Status != want.Status || got.ETag != want.ETag || !got.Timestamp.Equal(want.Timestamp) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	// Counters are stored as text so the stores can increment them.
+	if data, err = codec.Marshal(42); err != nil || string(data) != "42" {
+		t.Errorf("Expected an integer to be encoded as text, got %q, %v", data, err)
+	}
+	var i int
+	if err := codec.Unmarshal(data, &i); err != nil || i != 42 {
+		t.Errorf("Expected 42, got %d, %v", i, err)
+	}
+}
+
+func TestGobCodec(t *testing.T) {
+	testCodecRoundTrip(t, GobCodec{})
+}
+
+func TestJSONCodec(t *testing.T) {
+	testCodecRoundTrip(t, JSONCodec{})
+}
@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	gz := gzip.NewWriter(&buffer)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func gunzipBytes(data []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return ioutil.ReadAll(gz)
+}
+
+// Compressor compresses the bodies stored with the Compress option. Its name
+// is stored with each entry so it is decompressed with the same compressor,
+// and is sent as the Content-Encoding of the responses served compressed, so
+// it should be a registered HTTP content coding, e.g. "gzip" or "zstd".
+type Compressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// GzipCompressor compresses with gzip. It is the default Compressor.
+type GzipCompressor struct{}
+
+func (GzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (GzipCompressor) Compress(data []byte) ([]byte, error) {
+	return gzipBytes(data)
+}
+
+func (GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	return gunzipBytes(data)
+}
+
+// IdentityCompressor stores bodies as is. Its entries are always served
+// decompressed, without a Content-Encoding.
+type IdentityCompressor struct{}
+
+func (IdentityCompressor) Name() string {
+	return "identity"
+}
+
+func (IdentityCompressor) Compress(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (IdentityCompressor) Decompress(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// compressorFor returns the compressor of the entries tagged with name: the
+// Compressor option or a built-in one.
+func compressorFor(name string, options Options) (Compressor, error) {
+	if options.Compressor != nil && options.Compressor.Name() == name {
+		return options.Compressor, nil
+	}
+	switch name {
+	case "gzip":
+		return GzipCompressor{}, nil
+	case "identity":
+		return IdentityCompressor{}, nil
+	}
+	return nil, ErrUnknownCompressor
+}
+
+// acceptsEncoding reports whether the client accepts the content coding: the
+// Accept-Encoding header lists it, or else *, with a q above 0.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	if coding == "identity" {
+		return false
+	}
+	wildcard := 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		switch token := strings.TrimSpace(fields[0]); {
+		case strings.EqualFold(token, coding):
+			return quality(fields[1:]) > 0
+		case token == "*":
+			wildcard = quality(fields[1:])
+		}
+	}
+	return wildcard > 0
+}
+
+// quality returns the q parameter among the params of a header element, 1 if
+// there is none.
+func quality(params []string) float64 {
+	q := 1.0
+	for _, param := range params {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, "q=") {
+			if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+				q = v
+			}
+		}
+	}
+	return q
+}
+
+// decompress decodes the body of a compressed entry.
+func decompress(cache *ResponseCache, options Options) error {
+	compressor, err := compressorFor(cache.encoding(), options)
+	if err != nil {
+		return err
+	}
+	data, err := compressor.Decompress(cache.Data)
+	if err != nil {
+		return err
+	}
+	cache.Data, cache.Compressed, cache.Encoding = data, false, ""
+	return nil
+}
+
+// encoding returns the name of the compressor of a compressed entry.
+func (cache *ResponseCache) encoding() string {
+	if cache.Encoding == "" {
+		// Entries stored before compressors were pluggable are gzipped.
+		return "gzip"
+	}
+	return cache.Encoding
+}
+
+// compressible reports whether a body of the given size, with the given
+// response headers, should be compressed before being stored.
+func compressible(header http.Header, size int, options Options) bool {
+	return options.Compress && size >= options.CompressMinSize && header.Get("Content-Encoding") == ""
+}
@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGzipRoundTrip(t *testing.T) {
+	compressed, err := gzipBytes([]byte("body"))
+	if err != nil {
+		t.Errorf("Unexpected error compressing: %s", err)
+	}
+	data, err := gunzipBytes(compressed)
+	if err != nil || string(data) != "body" {
+		t.Errorf("Expected the body back, got %q: %v", data, err)
+	}
+	if _, err := gunzipBytes([]byte("body")); err == nil {
+		t.Errorf("Expected an error decompressing garbage")
+	}
+}
+
+func TestCachePage_Compress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	large := strings.Repeat("large ", 100)
+	r := gin.New()
+	options := Options{Compress: true, CompressMinSize: 100}
+	r.GET("/small", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+		c.String(http.StatusOK, "small")
+	}))
+	r.GET("/large", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+		c.String(http.StatusOK, large)
+	}))
+
+	performRequest(r, "GET", "/small")
+	performRequest(r, "GET", "/large")
+
+	var cache ResponseCache
+	store.Get(urlEscape(PageCachePrefix, "/small"), &cache)
+	if cache.Compressed || string(cache.Data) != "small" {
+		t.Errorf("Expected a small body to be stored uncompressed")
+	}
+	store.Get(urlEscape(PageCachePrefix, "/large"), &cache)
+	if !cache.Compressed || len(cache.Data) >= len(large) {
+		t.Errorf("Expected a large body to be stored compressed")
+	}
+
+	// Identity clients get the decompressed body.
+	w := performRequest(r, "GET", "/large")
+	expectBody(t, w, large)
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected no Content-Encoding, got %q", w.Header().Get("Content-Encoding"))
+	}
+
+	// Gzip clients get the compressed body as is.
+	w = performRequestWithHeader(r, "GET", "/large", http.Header{"Accept-Encoding": {"gzip, deflate"}})
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected Content-Encoding gzip, got %q", w.Header().Get("Content-Encoding"))
+	}
+	data, err := gunzipBytes(w.Body.Bytes())
+	if err != nil || string(data) != large {
+		t.Errorf("Expected the gzipped body, got %v", err)
+	}
+
+	// Clients refusing gzip with q=0 get the decompressed body.
+	w = performRequestWithHeader(r, "GET", "/large", http.Header{"Accept-Encoding": {"gzip;q=0, identity"}})
+	expectBody(t, w, large)
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected gzip;q=0 to refuse the gzipped body, got %q", w.Header().Get("Content-Encoding"))
+	}
+
+	w = performRequestWithHeader(r, "GET", "/small", http.Header{"Accept-Encoding": {"gzip"}})
+	expectBody(t, w, "small")
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected an uncompressed entry to be served as is")
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	for header, accepted := range map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"GZIP":                true,
+		"deflate, gzip;q=0.5": true,
+		"gzip;q=0":            false,
+		"gzip;q=0, identity":  false,
+		" gzip ; q=0.0 , *":   false,
+		"x-gzip":              false,
+		"gzipped":             false,
+		"*":                   true,
+		"*;q=0":               false,
+		"identity, *;q=0.1":   true,
+	} {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", header)
+		if got := acceptsEncoding(r, "gzip"); got != accepted {
+			t.Errorf("Expected %q to accept gzip: %v, got %v", header, accepted, got)
+		}
+	}
+}
+
+// reverseCompressor stands for a third party compressor.
+type reverseCompressor struct{}
+
+func (reverseCompressor) Name() string { return "reverse" }
+
+func (reverseCompressor) Compress(data []byte) ([]byte, error) {
+	reversed := make([]byte, len(data))
+	for i, b := range data {
+		reversed[len(data)-1-i] = b
+	}
+	return reversed, nil
+}
+
+func (c reverseCompressor) Decompress(data []byte) ([]byte, error) {
+	return c.Compress(data)
+}
+
+func TestCompressors(t *testing.T) {
+	for _, compressor := range []Compressor{GzipCompressor{}, IdentityCompressor{}, reverseCompressor{}} {
+		compressed, err := compressor.Compress([]byte("body"))
+		if err != nil {
+			t.Errorf("%s: unexpected error compressing: %s", compressor.Name(), err)
+		}
+		cache := ResponseCache{Data: compressed, Compressed: true, Encoding: compressor.Name()}
+		if err := decompress(&cache, Options{Compressor: compressor}); err != nil || string(cache.Data) != "body" || cache.Compressed {
+			t.Errorf("%s: expected the body back, got %q: %v", compressor.Name(), cache.Data, err)
+		}
+	}
+
+	cache := ResponseCache{Data: []byte("ydob"), Compressed: true, Encoding: "reverse"}
+	if err := decompress(&cache, Options{Compressor: GzipCompressor{}}); err != ErrUnknownCompressor {
+		t.Errorf("Expected ErrUnknownCompressor for an entry of another compressor, got %v", err)
+	}
+}
+
+func TestCachePage_Compressor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	var errs []error
+	options := Options{Compress: true, Compressor: reverseCompressor{}, OnError: func(err error) { errs = append(errs, err) }}
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+		c.String(http.StatusOK, "body")
+	}))
+
+	performRequest(r, "GET", "/page")
+	var cache ResponseCache
+	store.Get(urlEscape(PageCachePrefix, "/page"), &cache)
+	if !cache.Compressed || cache.Encoding != "reverse" || string(cache.Data) != "ydob" {
+		t.Errorf("Expected the entry to be compressed and tagged, got %+v", cache)
+	}
+	w := performRequest(r, "GET", "/page")
+	if w.Body.String() != "body" || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected the decompressed body, got %q", w.Body.String())
+	}
+	w = performRequestWithHeader(r, "GET", "/page", http.Header{"Accept-Encoding": {"reverse"}})
+	if w.Body.String() != "ydob" || w.Header().Get("Content-Encoding") != "reverse" {
+		t.Errorf("Expected the compressed body, got %q", w.Body.String())
+	}
+
+	// Entries of a compressor that isn't configured can't be served.
+	cache.Encoding = "zstd"
+	store.Set(urlEscape(PageCachePrefix, "/page"), cache, DEFAULT)
+	expectBody(t, performRequest(r, "GET", "/page"), "body")
+	if len(errs) != 1 || !errors.Is(errs[0], ErrUnknownCompressor) {
+		t.Errorf("Expected ErrUnknownCompressor to be reported, got %v", errs)
+	}
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// ContextCacheStore is implemented by stores whose operations can honor the
+// deadline and cancellation of a context, typically network backed stores.
+// The middlewares pass the request context to these methods instead of calling
+// the plain CacheStore ones.
+type ContextCacheStore interface {
+	CacheStore
+	GetContext(ctx context.Context, key string, value interface{}) error
+	SetContext(ctx context.Context, key string, value interface{}, expire time.Duration) error
+	AddContext(ctx context.Context, key string, value interface{}, expire time.Duration) error
+	ReplaceContext(ctx context.Context, key string, data interface{}, expire time.Duration) error
+	DeleteContext(ctx context.Context, key string) error
+	IncrementContext(ctx context.Context, key string, data uint64) (uint64, error)
+	DecrementContext(ctx context.Context, key string, data uint64) (uint64, error)
+	FlushContext(ctx context.Context) error
+}
+
+// contextStore adapts a plain CacheStore to ContextCacheStore. Operations
+// can't be interrupted, but aren't started once the context is done.
+type contextStore struct {
+	CacheStore
+}
+
+// withContext returns store as a ContextCacheStore, adapting it if needed.
+func withContext(store CacheStore) ContextCacheStore {
+	if s, ok := store.(ContextCacheStore); ok {
+		return s
+	}
+	return contextStore{store}
+}
+
+func (s contextStore) GetContext(ctx context.Context, key string, value interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Get(key, value)
+}
+
+func (s contextStore) SetContext(ctx context.Context, key string, value interface{}, expire time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Set(key, value, expire)
+}
+
+func (s contextStore) AddContext(ctx context.Context, key string, value interface{}, expire time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Add(key, value, expire)
+}
+
+func (s contextStore) ReplaceContext(ctx context.Context, key string, data interface{}, expire time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Replace(key, data, expire)
+}
+
+func (s contextStore) DeleteContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Delete(key)
+}
+
+func (s contextStore) IncrementContext(ctx context.Context, key string, data uint64) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.Increment(key, data)
+}
+
+func (s contextStore) DecrementContext(ctx context.Context, key string, data uint64) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.Decrement(key, data)
+}
+
+func (s contextStore) FlushContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Flush()
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingStore blocks every context aware read and write until the context
+// is done.
+type blockingStore struct {
+	contextStore
+}
+
+func (blockingStore) GetContext(ctx context.Context, key string, value interface{}) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (blockingStore) SetContext(ctx context.Context, key string, value interface{}, expire time.Duration) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// silentServer returns the address of a server that accepts connections and
+// never replies, standing in for a hung redis or memcached.
+func silentServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	var conns []net.Conn
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+	t.Cleanup(func() {
+		l.Close()
+		<-done
+		for _, c := range conns {
+			c.Close()
+		}
+	})
+	return l.Addr().String()
+}
+
+// expectDeadline calls op with a context that expires shortly, and checks it
+// returns with the deadline error right after.
+func expectDeadline(t *testing.T, name string, op func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := op(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %s to fail with the deadline, got: %v", name, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected %s to return at the deadline, took %s", name, elapsed)
+	}
+}
+
+func TestCachePage_ContextCancellation(t *testing.T) {
+	var errs []error
+	store := blockingStore{contextStore{NewInMemoryStore(time.Minute)}}
+	r := newCountingRouter(store, Options{
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", "/page", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req.WithContext(ctx))
+
+	expectBody(t, w, "1")
+	if len(errs) != 2 || !errors.Is(errs[0], context.DeadlineExceeded) || !errors.Is(errs[1], context.DeadlineExceeded) {
+		t.Errorf("Expected the blocked Get and Set to be cancelled, got %v", errs)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	adapted := withContext(store)
+	if err := adapted.SetContext(context.Background(), "key", "value", DEFAULT); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var value string
+	if err := adapted.GetContext(ctx, "key", &value); err != context.Canceled {
+		t.Errorf("Expected a cancelled context to fail the call, got: %v", err)
+	}
+	if err := adapted.GetContext(context.Background(), "key", &value); err != nil || value != "value" {
+		t.Errorf("Expected to get the value back, got %q: %v", value, err)
+	}
+
+	blocking := blockingStore{contextStore{store}}
+	if withContext(blocking) != ContextCacheStore(blocking) {
+		t.Errorf("Expected context aware stores to be used as is")
+	}
+}
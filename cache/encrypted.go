@@ -0,0 +1,257 @@
+package cache
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// EncryptedStore encrypts the values with AES-GCM before passing them on to
+// the wrapped store, so the pages kept in a shared store, e.g. a redis server,
+// can't be read or altered without the key. Keys are left in the clear.
+//
+// Each value is prefixed with the ID of the key it was encrypted with, so the
+// key can be rotated: values are encrypted with the current key and decrypted
+// with any key added to the store, until the values encrypted with the old
+// keys have expired. Values encrypted with an unknown key, or altered, fail
+// with ErrDecrypt.
+type EncryptedStore struct {
+	store CacheStore
+
+	mu      sync.RWMutex
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewEncryptedStore returns a store encrypting its values in store with key,
+// 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256, and
+// identified by keyID, at most 255 bytes long.
+func NewEncryptedStore(store CacheStore, keyID string, key []byte) (*EncryptedStore, error) {
+	s := &EncryptedStore{store: store, aeads: map[string]cipher.AEAD{}}
+	if err := s.AddKey(keyID, key); err != nil {
+		return nil, err
+	}
+	s.current = keyID
+	return s, nil
+}
+
+// AddKey adds a key to decrypt the values with, e.g. the previous key while
+// rotating it.
+func (s *EncryptedStore) AddKey(keyID string, key []byte) error {
+	if len(keyID) > 255 {
+		return errors.New("cache: key ID too long.")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aeads[keyID] = aead
+	return nil
+}
+
+// Rotate adds key, identified by keyID, and encrypts the values written from
+// now on with it. The previous keys are kept to decrypt the values already
+// stored.
+func (s *EncryptedStore) Rotate(keyID string, key []byte) error {
+	if err := s.AddKey(keyID, key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = keyID
+	return nil
+}
+
+// encrypt serializes value and seals it with the current key, binding it to
+// key so values can't be moved between keys.
+func (s *EncryptedStore) encrypt(key string, value interface{}) ([]byte, error) {
+	plain, err := serialize(value)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	keyID, aead := s.current, s.aeads[s.current]
+	s.mu.RUnlock()
+
+	data := make([]byte, 1+len(keyID)+aead.NonceSize(), 1+len(keyID)+aead.NonceSize()+len(plain)+aead.Overhead())
+	data[0] = byte(len(keyID))
+	copy(data[1:], keyID)
+	if _, err := io.ReadFull(rand.Reader, data[1+len(keyID):]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(data, data[1+len(keyID):], plain, []byte(key)), nil
+}
+
+// decrypt opens data, stored at key, into ptrValue.
+func (s *EncryptedStore) decrypt(key string, data []byte, ptrValue interface{}) error {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return ErrDecrypt
+	}
+	keyID, data := string(data[1:1+int(data[0])]), data[1+int(data[0]):]
+	s.mu.RLock()
+	aead, found := s.aeads[keyID]
+	s.mu.RUnlock()
+	if !found || len(data) < aead.NonceSize() {
+		return ErrDecrypt
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(key))
+	if err != nil {
+		return ErrDecrypt
+	}
+	return deserialize(plain, ptrValue)
+}
+
+func (s *EncryptedStore) Get(key string, value interface{}) error {
+	return s.GetContext(context.Background(), key, value)
+}
+
+func (s *EncryptedStore) Set(key string, value interface{}, expires time.Duration) error {
+	return s.SetContext(context.Background(), key, value, expires)
+}
+
+func (s *EncryptedStore) Add(key string, value interface{}, expires time.Duration) error {
+	return s.AddContext(context.Background(), key, value, expires)
+}
+
+func (s *EncryptedStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.ReplaceContext(context.Background(), key, value, expires)
+}
+
+func (s *EncryptedStore) Delete(key string) error {
+	return s.store.Delete(key)
+}
+
+// Increment returns ErrNotSupport: the store can't add to encrypted values.
+func (s *EncryptedStore) Increment(key string, n uint64) (uint64, error) {
+	return 0, ErrNotSupport
+}
+
+// Decrement returns ErrNotSupport: the store can't subtract from encrypted
+// values.
+func (s *EncryptedStore) Decrement(key string, n uint64) (uint64, error) {
+	return 0, ErrNotSupport
+}
+
+func (s *EncryptedStore) Flush() error {
+	return s.store.Flush()
+}
+
+// GetContext is like Get, passing ctx on to the wrapped store, which is only
+// bounded by it if it implements ContextCacheStore.
+func (s *EncryptedStore) GetContext(ctx context.Context, key string, value interface{}) error {
+	var data []byte
+	if err := withContext(s.store).GetContext(ctx, key, &data); err != nil {
+		return err
+	}
+	return s.decrypt(key, data, value)
+}
+
+// SetContext is like Set, passing ctx on as GetContext does.
+func (s *EncryptedStore) SetContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	data, err := s.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+	return withContext(s.store).SetContext(ctx, key, data, expires)
+}
+
+// AddContext is like Add, passing ctx on as GetContext does.
+func (s *EncryptedStore) AddContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	data, err := s.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+	return withContext(s.store).AddContext(ctx, key, data, expires)
+}
+
+// ReplaceContext is like Replace, passing ctx on as GetContext does.
+func (s *EncryptedStore) ReplaceContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	data, err := s.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+	return withContext(s.store).ReplaceContext(ctx, key, data, expires)
+}
+
+// DeleteContext is like Delete, passing ctx on as GetContext does.
+func (s *EncryptedStore) DeleteContext(ctx context.Context, key string) error {
+	return withContext(s.store).DeleteContext(ctx, key)
+}
+
+// IncrementContext returns ErrNotSupport, as Increment does.
+func (s *EncryptedStore) IncrementContext(ctx context.Context, key string, n uint64) (uint64, error) {
+	return 0, ErrNotSupport
+}
+
+// DecrementContext returns ErrNotSupport, as Decrement does.
+func (s *EncryptedStore) DecrementContext(ctx context.Context, key string, n uint64) (uint64, error) {
+	return 0, ErrNotSupport
+}
+
+// FlushContext is like Flush, passing ctx on as GetContext does.
+func (s *EncryptedStore) FlushContext(ctx context.Context) error {
+	return withContext(s.store).FlushContext(ctx)
+}
+
+// TTL returns the time left before key expires, or ErrNotSupport if the
+// wrapped store can't tell.
+func (s *EncryptedStore) TTL(key string) (time.Duration, error) {
+	ttl, ok := ttlStore(s.store)
+	if !ok {
+		return 0, ErrNotSupport
+	}
+	return ttl.TTL(key)
+}
+
+// FlushPrefix removes all the keys starting with prefix. It requires the
+// wrapped store to implement PrefixFlusher and returns ErrNotSupport
+// otherwise.
+func (s *EncryptedStore) FlushPrefix(prefix string) error {
+	flusher, ok := s.store.(PrefixFlusher)
+	if !ok {
+		return ErrNotSupport
+	}
+	return flusher.FlushPrefix(prefix)
+}
+
+// Keys returns the keys starting with prefix. It requires the wrapped store
+// to implement KeyLister and returns ErrNotSupport otherwise.
+func (s *EncryptedStore) Keys(prefix string) ([]string, error) {
+	lister, ok := s.store.(KeyLister)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	return lister.Keys(prefix)
+}
+
+// Tag adds key to the keys tagged with tag. Tags and keys are stored in the
+// clear, as the keys are. It requires the wrapped store to implement TagStore
+// and returns ErrNotSupport otherwise.
+func (s *EncryptedStore) Tag(tag string, key string, expire time.Duration) error {
+	tagger, ok := tagStore(s.store)
+	if !ok {
+		return ErrNotSupport
+	}
+	return tagger.Tag(tag, key, expire)
+}
+
+// PopTag removes the keys tagged with tag and returns them. It requires the
+// wrapped store to implement TagStore and returns ErrNotSupport otherwise.
+func (s *EncryptedStore) PopTag(tag string) ([]string, error) {
+	tagger, ok := tagStore(s.store)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	return tagger.PopTag(tag)
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+var testKey = bytes.Repeat([]byte("k"), 32)
+
+func newEncryptedStore(t *testing.T, defaultExpiration time.Duration) CacheStore {
+	store, err := NewEncryptedStore(NewInMemoryStore(defaultExpiration), "1", testKey)
+	if err != nil {
+		t.Fatalf("Error creating the store: %v", err)
+	}
+	return store
+}
+
+func TestEncryptedStore_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newEncryptedStore)
+}
+
+func TestEncryptedStore_Expiration(t *testing.T) {
+	expiration(t, newEncryptedStore)
+}
+
+func TestEncryptedStore_Add(t *testing.T) {
+	testAdd(t, newEncryptedStore)
+}
+
+func TestEncryptedStore_Replace(t *testing.T) {
+	testReplace(t, newEncryptedStore)
+}
+
+func TestEncryptedStore_AtRest(t *testing.T) {
+	inner := NewInMemoryStore(time.Minute)
+	store, _ := NewEncryptedStore(inner, "1", testKey)
+	if err := store.Set("key", "secret page", DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %v", err)
+	}
+	var data []byte
+	if err := inner.Get("key", &data); err != nil {
+		t.Fatalf("Error reading the stored value: %v", err)
+	}
+	if bytes.Contains(data, []byte("secret page")) {
+		t.Errorf("Expected the value to be encrypted, got %q", data)
+	}
+
+	// Values can't be moved to another key.
+	inner.Set("other", data, DEFAULT)
+	var value string
+	if err := store.Get("other", &value); err != ErrDecrypt {
+		t.Errorf("Expected ErrDecrypt for a moved value, got %v", err)
+	}
+	if _, err := store.Increment("key", 1); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport incrementing, got %v", err)
+	}
+}
+
+func TestEncryptedStore_WrongKey(t *testing.T) {
+	inner := NewInMemoryStore(time.Minute)
+	store, _ := NewEncryptedStore(inner, "1", testKey)
+	store.Set("key", "value", DEFAULT)
+
+	other, _ := NewEncryptedStore(inner, "1", bytes.Repeat([]byte("x"), 32))
+	var value string
+	if err := other.Get("key", &value); err != ErrDecrypt || value != "" {
+		t.Errorf("Expected ErrDecrypt with the wrong key, got %q: %v", value, err)
+	}
+	unknown, _ := NewEncryptedStore(inner, "2", testKey)
+	if err := unknown.Get("key", &value); err != ErrDecrypt {
+		t.Errorf("Expected ErrDecrypt with an unknown key ID, got %v", err)
+	}
+	inner.Set("garbage", []byte{5, 'a'}, DEFAULT)
+	if err := store.Get("garbage", &value); err != ErrDecrypt {
+		t.Errorf("Expected ErrDecrypt for a truncated value, got %v", err)
+	}
+
+	if _, err := NewEncryptedStore(inner, "1", []byte("short")); err == nil {
+		t.Errorf("Expected an error for an invalid key size")
+	}
+}
+
+func TestEncryptedStore_Rotate(t *testing.T) {
+	store, _ := NewEncryptedStore(NewInMemoryStore(time.Minute), "1", testKey)
+	store.Set("old", "old value", DEFAULT)
+	if err := store.Rotate("2", bytes.Repeat([]byte("n"), 16)); err != nil {
+		t.Fatalf("Error rotating the key: %v", err)
+	}
+	store.Set("new", "new value", DEFAULT)
+
+	var value string
+	if err := store.Get("old", &value); err != nil || value != "old value" {
+		t.Errorf("Expected the old value to be decrypted with the old key, got %q: %v", value, err)
+	}
+	if err := store.Get("new", &value); err != nil || value != "new value" {
+		t.Errorf("Expected the new value, got %q: %v", value, err)
+	}
+
+	// Without the old key, only the new value can be read.
+	rotated, _ := NewEncryptedStore(store.store, "2", bytes.Repeat([]byte("n"), 16))
+	if err := rotated.Get("old", &value); err != ErrDecrypt {
+		t.Errorf("Expected ErrDecrypt without the old key, got %v", err)
+	}
+	if err := rotated.Get("new", &value); err != nil || value != "new value" {
+		t.Errorf("Expected the new value, got %q: %v", value, err)
+	}
+}
+
+func TestEncryptedStore_Context(t *testing.T) {
+	store, err := NewEncryptedStore(blockingStore{contextStore{NewInMemoryStore(time.Minute)}}, "1", testKey)
+	if err != nil {
+		t.Fatalf("Error creating the store: %v", err)
+	}
+	s := withContext(store)
+	expectDeadline(t, "GetContext", func(ctx context.Context) error {
+		var value string
+		return s.GetContext(ctx, "key", &value)
+	})
+	expectDeadline(t, "SetContext", func(ctx context.Context) error {
+		return s.SetContext(ctx, "key", "value", DEFAULT)
+	})
+}
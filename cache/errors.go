@@ -0,0 +1,20 @@
+package cache
+
+// CacheError is the error the middlewares pass to the OnError option and
+// Metrics when a store operation fails, telling which key and operation it
+// was. The underlying error, e.g. ErrNotStored, can be matched with errors.Is.
+type CacheError struct {
+	// Op is the failing operation, "get" or "set".
+	Op string
+	// Key is the store key of the page.
+	Key string
+	Err error
+}
+
+func (e *CacheError) Error() string {
+	return e.Op + " " + e.Key + ": " + e.Err.Error()
+}
+
+func (e *CacheError) Unwrap() error {
+	return e.Err
+}
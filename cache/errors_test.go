@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCacheError(t *testing.T) {
+	err := error(&CacheError{Op: "get", Key: "key", Err: ErrCacheMiss})
+	if !errors.Is(err, ErrCacheMiss) || errors.Is(err, ErrNotStored) {
+		t.Errorf("Expected the error to match the wrapped sentinel only")
+	}
+	if err.Error() != "get key: "+ErrCacheMiss.Error() {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+}
+
+func TestCachePage_OnErrorCacheError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var errs []error
+	options := Options{OnError: func(err error) { errs = append(errs, err) }}
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(failingStore{}, time.Minute, options, func(c *gin.Context) {
+		c.String(http.StatusOK, "body")
+	}))
+	performRequest(r, "GET", "/page")
+
+	key := urlEscape(PageCachePrefix, "/page")
+	if len(errs) != 2 {
+		t.Fatalf("Expected the lookup and store errors, got %v", errs)
+	}
+	for i, op := range []string{"get", "set"} {
+		var cacheErr *CacheError
+		if !errors.As(errs[i], &cacheErr) {
+			t.Fatalf("Expected a *CacheError, got %T", errs[i])
+		}
+		if cacheErr.Op != op || cacheErr.Key != key || !errors.Is(cacheErr, errStoreDown) {
+			t.Errorf("Expected a %s error for %s, got %+v", op, key, cacheErr)
+		}
+	}
+}
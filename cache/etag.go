@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// newETag returns a strong entity tag for body, its sha1 when newHash is nil
+// or its hash with newHash otherwise.
+func newETag(body []byte, newHash func() hash.Hash) string {
+	if newHash == nil {
+		newHash = sha1.New
+	}
+	h := newHash()
+	h.Write(body)
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// scanETag parses the entity tag at the start of s, after optional
+// whitespace, returning its quoted opaque tag, whether it is weak and the rest
+// of s. ok is false when s doesn't start with a valid entity tag.
+func scanETag(s string) (opaque string, weak bool, rest string, ok bool) {
+	s = strings.TrimLeft(s, " \t")
+	if strings.HasPrefix(s, "W/") {
+		weak, s = true, s[2:]
+	}
+	if len(s) < 2 || s[0] != '"' {
+		return "", false, "", false
+	}
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			return s[:i+1], weak, s[i+1:], true
+		case c == 0x21, c >= 0x23 && c != 0x7f:
+		default:
+			return "", false, "", false
+		}
+	}
+	return "", false, "", false
+}
+
+// parseETag parses s holding a single entity tag.
+func parseETag(s string) (opaque string, weak bool, ok bool) {
+	opaque, weak, rest, ok := scanETag(s)
+	if !ok || strings.TrimSpace(rest) != "" {
+		return "", false, false
+	}
+	return opaque, weak, true
+}
+
+// weakMatch reports whether the entity tags a and b match with the weak
+// comparison of RFC 7232: their opaque tags are the same, weak or not.
+func weakMatch(a, b string) bool {
+	opaqueA, _, okA := parseETag(a)
+	opaqueB, _, okB := parseETag(b)
+	return okA && okB && opaqueA == opaqueB
+}
+
+// strongMatch reports whether the entity tags a and b match with the strong
+// comparison of RFC 7232: neither is weak and their opaque tags are the same.
+func strongMatch(a, b string) bool {
+	opaqueA, weakA, okA := parseETag(a)
+	opaqueB, weakB, okB := parseETag(b)
+	return okA && okB && !weakA && !weakB && opaqueA == opaqueB
+}
+
+// etagMatch reports whether header, either * or a list of entity tags as in
+// If-None-Match and If-Match, matches etag. The comparison is strong if strong
+// is true, weak otherwise. A malformed list matches nothing from the first
+// invalid entity tag on.
+func etagMatch(header string, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	match := weakMatch
+	if strong {
+		match = strongMatch
+	}
+	for s := header; ; {
+		if s = strings.TrimLeft(s, " \t,"); s == "" {
+			return false
+		}
+		opaque, weak, rest, ok := scanETag(s)
+		if !ok {
+			return false
+		}
+		candidate := opaque
+		if weak {
+			candidate = "W/" + opaque
+		}
+		if match(candidate, etag) {
+			return true
+		}
+		if s = strings.TrimLeft(rest, " \t"); s != "" && s[0] != ',' {
+			return false
+		}
+	}
+}
+
+// notModified reports whether the request is a conditional request that the
+// cached response satisfies, in which case a 304 is sent instead of the body.
+// If-Modified-Since is only checked without If-None-Match, and ignored when
+// it isn't a valid date.
+func notModified(r *http.Request, cache *ResponseCache) bool {
+	if cache.Status != http.StatusOK {
+		return false
+	}
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return etagMatch(header, cache.ETag, false)
+	}
+	header := r.Header.Get("If-Modified-Since")
+	if header == "" || cache.Header.Get("Last-Modified") == "" || (r.Method != "GET" && r.Method != "HEAD") {
+		return false
+	}
+	since, err := http.ParseTime(header)
+	return err == nil && !lastModified(cache).After(since)
+}
+
+// writeNotModified sends a 304 for a cached response whose headers are
+// already copied to the writer.
+func writeNotModified(w http.ResponseWriter) {
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+}
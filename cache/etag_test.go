@@ -0,0 +1,65 @@
+package cache
+
+import "testing"
+
+func TestETagMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		weak   bool
+		strong bool
+	}{
+		{`"abc"`, `"abc"`, true, true},
+		{`"abc"`, `"abd"`, false, false},
+		{`W/"abc"`, `"abc"`, true, false},
+		{`"abc"`, `W/"abc"`, true, false},
+		{`W/"abc"`, `W/"abc"`, true, false},
+		{`*`, `"abc"`, true, true},
+		{` * `, `W/"abc"`, true, true},
+		{`*`, ``, false, false},
+		{`"abc"`, ``, false, false},
+		{`"x", "abc"`, `"abc"`, true, true},
+		{`"x",W/"abc"`, `"abc"`, true, false},
+		{`"x" , "y"`, `"abc"`, false, false},
+		{`"a,b", "c"`, `"a,b"`, true, true},
+		{`"a,b"`, `"a"`, false, false},
+		{`abc`, `"abc"`, false, false},
+		{`"abc`, `"abc"`, false, false},
+		{`w/"abc"`, `"abc"`, false, false},
+		{`"a b"`, `"a b"`, false, false},
+		{`"x" "abc"`, `"abc"`, false, false},
+		{`,, "abc" ,`, `"abc"`, true, true},
+	}
+	for _, test := range tests {
+		if got := etagMatch(test.header, test.etag, false); got != test.weak {
+			t.Errorf("Weak match of %q with %q: expected %v, got %v", test.header, test.etag, test.weak, got)
+		}
+		if got := etagMatch(test.header, test.etag, true); got != test.strong {
+			t.Errorf("Strong match of %q with %q: expected %v, got %v", test.header, test.etag, test.strong, got)
+		}
+	}
+}
+
+func TestStrongMatch(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		match bool
+	}{
+		{`"abc"`, `"abc"`, true},
+		{` "abc" `, `"abc"`, true},
+		{`W/"abc"`, `"abc"`, false},
+		{`"abc"`, `W/"abc"`, false},
+		{`"abc"`, `"ABC"`, false},
+		{`*`, `"abc"`, false},
+		{`"abc", "abc"`, `"abc"`, false},
+		{``, ``, false},
+	}
+	for _, test := range tests {
+		if got := strongMatch(test.a, test.b); got != test.match {
+			t.Errorf("Strong match of %q with %q: expected %v, got %v", test.a, test.b, test.match, got)
+		}
+		if got := weakMatch(test.a, test.b); test.match && !got {
+			t.Errorf("Expected %q to weakly match %q", test.a, test.b)
+		}
+	}
+}
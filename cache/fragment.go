@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"reflect"
+	"time"
+)
+
+// fragments deduplicates the concurrent renders of the fragments, keyed by
+// fragmentCall.
+var fragments flightGroup
+
+// fragmentCall identifies the render of the fragment at key in store, so the
+// calls for the same key in different stores, e.g. namespaces, don't wait for
+// each other.
+type fragmentCall struct {
+	store CacheStore
+	key   string
+}
+
+// Fragment returns the bytes stored at key, or calls render and stores its
+// result for expire, e.g. to cache an expensive part of an otherwise dynamic
+// page. Concurrent calls for a key that is missing from the same store wait
+// for a single render, unless the store can't be compared, e.g. a struct
+// holding a map.
+// The fragment isn't stored when render fails, its error being returned
+// instead. Store errors are ignored: the fragment is rendered without being
+// cached, as the middlewares do by default.
+func Fragment(store CacheStore, key string, expire time.Duration, render func() ([]byte, error)) ([]byte, error) {
+	var data []byte
+	if store.Get(key, &data) == nil {
+		return data, nil
+	}
+	var err error
+	renderAndStore := func() {
+		if data, err = render(); err == nil {
+			store.Set(key, data, expire)
+		}
+	}
+	if !reflect.TypeOf(store).Comparable() {
+		renderAndStore()
+		return data, err
+	}
+	rendered := fragments.do(fragmentCall{store, key}, renderAndStore)
+	if rendered {
+		return data, err
+	}
+	// Another call rendered the fragment meanwhile, if it didn't fail.
+	if store.Get(key, &data) == nil {
+		return data, nil
+	}
+	return render()
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFragment(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	renders := 0
+	render := func() ([]byte, error) {
+		renders++
+		return []byte("sidebar"), nil
+	}
+
+	for i := 0; i < 2; i++ {
+		data, err := Fragment(store, "sidebar", time.Minute, render)
+		if err != nil || string(data) != "sidebar" {
+			t.Errorf("Expected the fragment, got %q: %v", data, err)
+		}
+	}
+	if renders != 1 {
+		t.Errorf("Expected the second call to be a hit, rendered %d times", renders)
+	}
+}
+
+func TestFragment_RenderError(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	errRender := errors.New("render failed")
+	if _, err := Fragment(store, "sidebar", time.Minute, func() ([]byte, error) { return nil, errRender }); err != errRender {
+		t.Errorf("Expected the render error, got %v", err)
+	}
+	var data []byte
+	if err := store.Get("sidebar", &data); err != ErrCacheMiss {
+		t.Errorf("Expected a failed render not to be cached, got %v", err)
+	}
+	if data, err := Fragment(failingStore{}, "sidebar", time.Minute, func() ([]byte, error) { return []byte("ok"), nil }); err != nil || string(data) != "ok" {
+		t.Errorf("Expected the fragment to be rendered when the store fails, got %q: %v", data, err)
+	}
+}
+
+func TestFragment_SingleFlight(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	var renders int32
+	render := func() ([]byte, error) {
+		atomic.AddInt32(&renders, 1)
+		time.Sleep(50 * time.Millisecond)
+		return []byte("sidebar"), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data, err := Fragment(store, "sidebar", time.Minute, render); err != nil || string(data) != "sidebar" {
+				t.Errorf("Expected the fragment, got %q: %v", data, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&renders); n != 1 {
+		t.Errorf("Expected a single render, got %d", n)
+	}
+}
+
+func TestFragment_SingleFlightPerStore(t *testing.T) {
+	backend := NewInMemoryStore(time.Minute)
+	stores := []CacheStore{NewNamespaceStore(backend, "app1:"), NewNamespaceStore(backend, "app2:")}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan []byte)
+	go func() {
+		data, _ := Fragment(stores[0], "sidebar", time.Minute, func() ([]byte, error) {
+			close(started)
+			<-release
+			return []byte("app1"), nil
+		})
+		done <- data
+	}()
+	<-started
+
+	// The render for the other store doesn't wait for the first one.
+	second := make(chan []byte, 1)
+	go func() {
+		data, _ := Fragment(stores[1], "sidebar", time.Minute, func() ([]byte, error) { return []byte("app2"), nil })
+		second <- data
+	}()
+	select {
+	case data := <-second:
+		if string(data) != "app2" {
+			t.Errorf("Expected the fragment of the second store, got %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected the second store not to wait for the render of the first one")
+	}
+	close(release)
+	if data := <-done; string(data) != "app1" {
+		t.Errorf("Expected the fragment of the first store, got %q", data)
+	}
+}
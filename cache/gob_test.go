@@ -0,0 +1,42 @@
+package cache_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/contrib/cache"
+)
+
+// encodeInterface encodes value as an interface, as stores of other packages
+// may do.
+func encodeInterface(value interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(&value)
+	return b.Bytes(), err
+}
+
+func TestRegisterGobTypes(t *testing.T) {
+	cache.RegisterGobTypes()
+	data, err := encodeInterface(cache.ResponseCache{
+		Status: http.StatusOK,
+		Header: http.Header{"Content-Type": {"text/plain"}},
+		Data:   []byte("body"),
+	})
+	if err != nil {
+		t.Fatalf("Error encoding a response: %s", err)
+	}
+
+	var value interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		t.Fatalf("Error decoding a response: %s", err)
+	}
+	response, ok := value.(cache.ResponseCache)
+	if !ok {
+		t.Fatalf("Expected a ResponseCache, got %T", value)
+	}
+	if response.Status != http.StatusOK || string(response.Data) != "body" || response.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected the response to round trip, got %+v", response)
+	}
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StoreErrorPolicy tells the middlewares how to serve a request when the
+// store fails, as opposed to just missing the page.
+type StoreErrorPolicy int
+
+const (
+	// FailOpen serves the request from the handler, as on a miss.
+	FailOpen StoreErrorPolicy = iota
+	// FailClosed responds with 503 Service Unavailable, to protect an origin
+	// that can't take the load of an uncached site.
+	FailClosed
+	// CircuitBreaker fails open, and stops using the store for BreakerCooldown
+	// after BreakerThreshold consecutive failed lookups, so requests don't wait
+	// for a store that is down.
+	CircuitBreaker
+)
+
+// storeGuard applies the OnStoreError policy of a middleware instance.
+type storeGuard struct {
+	policy    StoreErrorPolicy
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	closed    gin.HandlerFunc
+	// latency and window are the LatencyThreshold and LatencyWindow
+	// options.
+	latency time.Duration
+	window  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	// The lookups timed since windowStart, and the end of the bypass once
+	// they were too slow.
+	windowStart time.Time
+	lookups     int
+	total       time.Duration
+	slowUntil   time.Time
+}
+
+// minLatencyLookups is the number of lookups a window must have before their
+// average is compared to LatencyThreshold, so a single slow lookup, e.g. on a
+// new connection, doesn't bypass the cache.
+const minLatencyLookups = 10
+
+func newStoreGuard(options Options) *storeGuard {
+	return &storeGuard{
+		policy:    options.OnStoreError,
+		threshold: options.BreakerThreshold,
+		cooldown:  options.BreakerCooldown,
+		now:       options.Now,
+		closed:    options.FailClosedHandler,
+		latency:   options.LatencyThreshold,
+		window:    options.LatencyWindow,
+	}
+}
+
+// available reports whether the store may be used, that is unless the
+// circuit breaker is open or the lookups are too slow.
+func (g *storeGuard) available() bool {
+	if g.policy != CircuitBreaker && g.latency <= 0 {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	return !now.Before(g.openUntil) && !now.Before(g.slowUntil)
+}
+
+// lookup runs fetch, timing it when LatencyThreshold is set. Once the
+// average lookup of a window is slower than the threshold, over at least
+// minLatencyLookups, the store isn't used for a window, after which lookups
+// are timed again.
+func (g *storeGuard) lookup(fetch func()) {
+	if g.latency <= 0 {
+		fetch()
+		return
+	}
+	start := g.now()
+	fetch()
+	end := g.now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if end.Sub(g.windowStart) >= g.window {
+		g.windowStart, g.lookups, g.total = end, 0, 0
+	}
+	g.lookups++
+	g.total += end.Sub(start)
+	if g.lookups >= minLatencyLookups && g.total/time.Duration(g.lookups) > g.latency {
+		g.slowUntil = end.Add(g.window)
+		g.windowStart, g.lookups, g.total = g.slowUntil, 0, 0
+	}
+}
+
+// failed records the outcome of a lookup and reports whether the request was
+// answered because of it.
+func (g *storeGuard) failed(x *exchange, err error) bool {
+	switch g.policy {
+	case FailClosed:
+		if err == nil {
+			return false
+		}
+		if g.closed == nil {
+			x.w.WriteHeader(http.StatusServiceUnavailable)
+			x.w.WriteHeaderNow()
+			return true
+		}
+		x.c.Abort()
+		g.closed(x.c)
+		return true
+	case CircuitBreaker:
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if err == nil {
+			g.failures = 0
+		} else if g.failures++; g.failures >= g.threshold {
+			g.failures = 0
+			g.openUntil = g.now().Add(g.cooldown)
+		}
+	}
+	return false
+}
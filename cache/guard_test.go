@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// flakyStore fails Get while down is set, counting the calls.
+type flakyStore struct {
+	CacheStore
+	down bool
+	gets int
+}
+
+func (s *flakyStore) Get(key string, value interface{}) error {
+	s.gets++
+	if s.down {
+		return errStoreDown
+	}
+	return s.CacheStore.Get(key, value)
+}
+
+func TestOnStoreError_FailOpen(t *testing.T) {
+	r := newCountingRouter(failingStore{}, Options{})
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+	expectBody(t, performRequest(r, "GET", "/page"), "2")
+}
+
+func TestOnStoreError_FailClosed(t *testing.T) {
+	store := &flakyStore{CacheStore: NewInMemoryStore(time.Minute)}
+	r := newCountingRouter(store, Options{OnStoreError: FailClosed})
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+
+	store.down = true
+	if w := performRequest(r, "GET", "/page"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 when the store fails, got %d", w.Code)
+	}
+
+	// Misses aren't failures.
+	store.down = false
+	expectBody(t, performRequest(r, "GET", "/page?new"), "2")
+}
+
+func TestOnStoreError_CircuitBreaker(t *testing.T) {
+	store := &flakyStore{CacheStore: NewInMemoryStore(time.Minute), down: true}
+	r := newCountingRouter(store, Options{
+		OnStoreError:     CircuitBreaker,
+		BreakerThreshold: 2,
+		BreakerCooldown:  100 * time.Millisecond,
+	})
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+	expectBody(t, performRequest(r, "GET", "/page"), "2")
+	expectBody(t, performRequest(r, "GET", "/page"), "3")
+	if store.gets != 2 {
+		t.Errorf("Expected the store not to be used once the breaker is open, got %d lookups", store.gets)
+	}
+
+	// The page stored by the second request is served once the store is
+	// used again.
+	store.down = false
+	time.Sleep(150 * time.Millisecond)
+	expectBody(t, performRequest(r, "GET", "/page"), "2")
+	if store.gets != 3 {
+		t.Errorf("Expected the store to be used after the cooldown, got %d lookups", store.gets)
+	}
+}
+
+// slowStore advances clock by delay on every Get, counting the calls.
+type slowStore struct {
+	CacheStore
+	clock *fakeClock
+	delay time.Duration
+	gets  int
+}
+
+func (s *slowStore) Get(key string, value interface{}) error {
+	s.gets++
+	s.clock.Advance(s.delay)
+	return s.CacheStore.Get(key, value)
+}
+
+func TestLatencyThreshold(t *testing.T) {
+	clock := newFakeClock()
+	store := &slowStore{CacheStore: NewInMemoryStore(time.Minute), clock: clock}
+	r := newCountingRouter(store, Options{
+		Now:              clock.Now,
+		LatencyThreshold: 50 * time.Millisecond,
+		LatencyWindow:    10 * time.Second,
+	})
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+
+	// Slow lookups bypass the cache for a window, once there are enough of
+	// them to tell.
+	store.delay = 200 * time.Millisecond
+	for i := 0; i < 8; i++ {
+		expectBody(t, performRequest(r, "GET", "/page"), "1")
+	}
+	expectBody(t, performRequest(r, "GET", "/page"), "2")
+	expectBody(t, performRequest(r, "GET", "/page"), "3")
+	if store.gets != 10 {
+		t.Errorf("Expected the store not to be used while it is slow, got %d lookups", store.gets)
+	}
+
+	// The cache is used again once the window has passed.
+	store.delay = 0
+	clock.Advance(10 * time.Second)
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+	if store.gets != 11 {
+		t.Errorf("Expected the store to be used after the window, got %d lookups", store.gets)
+	}
+}
+
+func TestLatencyThreshold_Outlier(t *testing.T) {
+	clock := newFakeClock()
+	store := &slowStore{CacheStore: NewInMemoryStore(time.Minute), clock: clock}
+	r := newCountingRouter(store, Options{
+		Now:              clock.Now,
+		LatencyThreshold: 50 * time.Millisecond,
+		LatencyWindow:    10 * time.Second,
+	})
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+
+	// A single slow lookup is averaged with the next ones.
+	store.delay = 400 * time.Millisecond
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+	store.delay = 0
+	for i := 0; i < 20; i++ {
+		expectBody(t, performRequest(r, "GET", "/page"), "1")
+	}
+	if store.gets != 22 {
+		t.Errorf("Expected the store to be used after a single slow lookup, got %d lookups", store.gets)
+	}
+}
+
+func TestOnStoreError_FailClosedHandler(t *testing.T) {
+	r := newCountingRouter(failingStore{}, Options{
+		OnStoreError: FailClosed,
+		FailClosedHandler: func(c *gin.Context) {
+			c.String(http.StatusServiceUnavailable, "maintenance")
+		},
+	})
+	w := performRequest(r, "GET", "/page")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 when the store fails, got %d", w.Code)
+	}
+	expectBody(t, w, "maintenance")
+	expectBody(t, performRequest(r, "GET", "/page"), "maintenance")
+}
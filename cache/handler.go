@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler caches the responses of next, a net/http handler, as CachePage
+// does for gin handlers, so the cache can be used outside of gin.
+func Handler(store CacheStore, expire time.Duration, next http.Handler) http.Handler {
+	return HandlerWithOptions(store, expire, Options{}, next)
+}
+
+// HandlerWithOptions is like Handler with options. The requests are served
+// by the same core as CachePageWithOptions, without going through a gin
+// engine: next gets a writer able to flush and hijack the connection as the
+// one of the server, and the options taking a *gin.Context, e.g. KeyFunc or
+// Skip, get one holding the request, see newContext. RouteErrorExpire doesn't
+// apply, as there is no router. Requests with a method that isn't cached are
+// passed straight to next.
+func HandlerWithOptions(store CacheStore, expire time.Duration, options Options, next http.Handler) http.Handler {
+	options.RouteErrorExpire = 0
+	p := newPageCache(options)
+	contextStore := withContext(store)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cacheableMethod(r.Method, p.options) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writer := newResponseWriter(w)
+		p.serve(handlerExchange(writer, r, next), contextStore, expire)
+		// As gin does once the handlers are done, e.g. for a cached HEAD.
+		writer.WriteHeaderNow()
+	})
+}
+
+// handlerExchange returns the exchange of a request served by next, writing
+// to w.
+func handlerExchange(w gin.ResponseWriter, r *http.Request, next http.Handler) *exchange {
+	return &exchange{
+		w: w,
+		r: r,
+		c: newContext(w, r),
+		next: func(w gin.ResponseWriter) {
+			next.ServeHTTP(w, r)
+		},
+		detach: func(w gin.ResponseWriter) *exchange {
+			// The original request, along with its context, is over by the
+			// time the store is updated.
+			return handlerExchange(w, r.WithContext(context.Background()), next)
+		},
+	}
+}
+
+// bareEngine is the engine of the gin contexts of the requests served outside
+// of a router, e.g. by Handler. Unlike the one of gin.New, which logs a
+// warning in debug mode, it trusts no proxy, so ClientIP is the address of
+// the peer.
+var bareEngine = &gin.Engine{MaxMultipartMemory: 32 << 20}
+
+// newContext returns a gin context of bareEngine for r, writing to w, as gin
+// creates for the requests it routes, except for the route: FullPath is empty.
+func newContext(w gin.ResponseWriter, r *http.Request) *gin.Context {
+	c := gin.CreateTestContextOnly(w, bareEngine)
+	c.Request = r
+	c.Writer = w
+	return c
+}
+
+// responseWriter is the gin.ResponseWriter of the requests served by
+// Handler, over the writer of the server. Like the one of gin, it holds the
+// status until the body is written.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w, status: http.StatusOK, size: -1}
+}
+
+// Unwrap returns the writer of the server, for http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if code > 0 && !w.Written() {
+		w.status = code
+	}
+}
+
+func (w *responseWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+	n, err := w.ResponseWriter.Write(data)
+	w.size += n
+	return n, err
+}
+
+func (w *responseWriter) WriteString(s string) (int, error) {
+	w.WriteHeaderNow()
+	n, err := io.WriteString(w.ResponseWriter, s)
+	w.size += n
+	return n, err
+}
+
+func (w *responseWriter) Status() int {
+	return w.status
+}
+
+func (w *responseWriter) Size() int {
+	return w.size
+}
+
+func (w *responseWriter) Written() bool {
+	return w.size != -1
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.size > 0 {
+		return nil, nil, errors.New("cache: response body already written")
+	}
+	if w.size < 0 {
+		w.size = 0
+	}
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *responseWriter) Flush() {
+	w.WriteHeaderNow()
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// CloseNotify returns a channel never receiving when the writer of the
+// server can't notify.
+func (w *responseWriter) CloseNotify() <-chan bool {
+	if notifier, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+func (w *responseWriter) Pusher() http.Pusher {
+	if pusher, ok := w.ResponseWriter.(http.Pusher); ok {
+		return pusher
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newCountingHandler(store CacheStore, options Options) (http.Handler, *int) {
+	calls := 0
+	return HandlerWithOptions(store, time.Minute, options, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-Calls", fmt.Sprint(calls))
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		fmt.Fprint(w, calls)
+	})), &calls
+}
+
+func serve(h http.Handler, method, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	h, calls := newCountingHandler(store, Options{})
+
+	expectBody(t, serve(h, "GET", "/page?a=1"), "1")
+	w := serve(h, "GET", "/page?a=1")
+	expectBody(t, w, "1")
+	if w.Code != http.StatusOK || w.Header().Get("X-Calls") != "1" {
+		t.Errorf("Expected the cached response to be replayed, got %d with X-Calls %q", w.Code, w.Header().Get("X-Calls"))
+	}
+	expectBody(t, serve(h, "GET", "/"), "2")
+	expectBody(t, serve(h, "GET", "/"), "2")
+
+	if w := serve(h, "HEAD", "/page?a=1"); w.Code != http.StatusOK || *calls != 2 {
+		t.Errorf("Expected HEAD to be served from the cached page, got %d after %d calls", w.Code, *calls)
+	}
+	expectBody(t, serve(h, "POST", "/page?a=1"), "3")
+	expectBody(t, serve(h, "PROPFIND", "/page?a=1"), "4")
+
+	if w := serve(h, "GET", "/missing"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the status of next to be kept, got %d", w.Code)
+	}
+	expectBody(t, serve(h, "GET", "/missing"), "6")
+}
+
+func TestHandler_Key(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	h := Handler(store, time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "page")
+	}))
+	serve(h, "GET", "/page?a=1")
+
+	key, _ := PageKey("GET", "/page?a=1", Options{})
+	var cache ResponseCache
+	if err := store.Get(key, &cache); err != nil || string(cache.Data) != "page" {
+		t.Errorf("Expected the page to be stored under the CachePage key, got %q: %v", cache.Data, err)
+	}
+}
+
+func TestHandler_NoEngine(t *testing.T) {
+	var debug bytes.Buffer
+	defaultWriter, mode := gin.DefaultWriter, gin.Mode()
+	gin.DefaultWriter = &debug
+	gin.SetMode(gin.DebugMode)
+	defer func() {
+		gin.DefaultWriter = defaultWriter
+		gin.SetMode(mode)
+	}()
+
+	var fullPaths []string
+	options := Options{
+		Skip: func(c *gin.Context) bool {
+			fullPaths = append(fullPaths, c.FullPath())
+			return false
+		},
+	}
+	h := HandlerWithOptions(NewInMemoryStore(time.Minute), time.Minute, options, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+		w.(http.Flusher).Flush()
+	}))
+	for i := 0; i < 2; i++ {
+		if w := serve(h, "GET", "/empty"); w.Code != http.StatusNoContent {
+			t.Errorf("Expected the status written without a body to be sent, got %d", w.Code)
+		}
+	}
+	if w := serve(h, "HEAD", "/empty"); w.Code != http.StatusNoContent {
+		t.Errorf("Expected the status of the cached page for HEAD, got %d", w.Code)
+	}
+
+	if debug.Len() != 0 {
+		t.Errorf("Expected no gin debug output, got %q", debug.String())
+	}
+	for _, fullPath := range fullPaths {
+		if fullPath != "" {
+			t.Errorf("Expected the requests not to be routed, got the route %q", fullPath)
+		}
+	}
+}
+
+func TestHandler_ClientIP(t *testing.T) {
+	options := Options{
+		KeyFunc: func(c *gin.Context) string {
+			return c.ClientIP() + c.Request.URL.Path
+		},
+	}
+	h, calls := newCountingHandler(NewInMemoryStore(time.Minute), options)
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/page", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", "192.0.2.9")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	expectBody(t, request("192.0.2.1:1234"), "1")
+	expectBody(t, request("192.0.2.1:5678"), "1")
+	expectBody(t, request("192.0.2.2:1234"), "2")
+	if *calls != 2 {
+		t.Errorf("Expected a page per client address, got %d calls", *calls)
+	}
+}
+
+func TestHandler_StaleWhileRevalidate(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	var calls int32
+	h := HandlerWithOptions(store, time.Minute, Options{StaleWhileRevalidate: time.Minute}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, atomic.AddInt32(&calls, 1))
+	}))
+	key := urlEscape(PageCachePrefix, "/page")
+
+	expectBody(t, serve(h, "GET", "/page"), "1")
+	makeStale(store, key)
+	expectBody(t, serve(h, "GET", "/page"), "1")
+	waitForBody(t, store, key, "2")
+}
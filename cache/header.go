@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"mime"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// defaultExcludedHeaders are the response headers never stored nor replayed,
+// as they are specific to the client the response was generated for.
+var defaultExcludedHeaders = []string{"Set-Cookie", "Set-Cookie2", "Authorization", "Proxy-Authorization"}
+
+// defaultAppendedHeaders are the list valued response headers whose cached
+// values are merged with the ones set by upstream middlewares on replay.
+var defaultAppendedHeaders = []string{"Vary", "Link", "Via"}
+
+// AccessControlPolicy tells the middlewares whether to cache the CORS
+// Access-Control-* response headers.
+type AccessControlPolicy int
+
+const (
+	// DefaultAccessControl skips the headers in Cached, which caches the
+	// pages of all the routes it is used on, and keeps them otherwise.
+	DefaultAccessControl AccessControlPolicy = iota
+	// SkipAccessControl neither stores nor replays the headers, leaving them
+	// to a CORS middleware running on every request.
+	SkipAccessControl
+	// KeepAccessControl stores and replays the headers like the others.
+	KeepAccessControl
+)
+
+// excludedHeader reports whether the header is in the ExcludeHeaders option,
+// an Access-Control-* header skipped by the AccessControl option or a Link
+// header skipped by SkipLinkHeaders.
+func excludedHeader(name string, options Options) bool {
+	if options.SkipLinkHeaders && textproto.CanonicalMIMEHeaderKey(name) == "Link" {
+		return true
+	}
+	if options.AccessControl == SkipAccessControl && strings.HasPrefix(textproto.CanonicalMIMEHeaderKey(name), "Access-Control-") {
+		return true
+	}
+	return containsHeader(options.ExcludeHeaders, name)
+}
+
+func containsHeader(names []string, name string) bool {
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	for _, n := range names {
+		if textproto.CanonicalMIMEHeaderKey(n) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// replayHeader sets the cached values of a header on the response. They
+// replace the values set by upstream middlewares, unless the header is in the
+// AppendHeaders option, in which case the missing values are added. Values
+// are compared element by element, so a cached "<a>, <b>" only adds "<b>" to
+// a response already linking to "<a>".
+func replayHeader(header http.Header, name string, vals []string, options Options) {
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	if !containsHeader(options.AppendHeaders, name) {
+		header[name] = append([]string(nil), vals...)
+		return
+	}
+	for _, v := range vals {
+		present := make(map[string]bool)
+		for _, val := range header[name] {
+			for _, element := range listElements(val) {
+				present[element] = true
+			}
+		}
+		elements := listElements(v)
+		var missing []string
+		for _, element := range elements {
+			if !present[element] {
+				missing = append(missing, element)
+				present[element] = true
+			}
+		}
+		if len(missing) == len(elements) && len(missing) > 0 {
+			header[name] = append(header[name], v)
+		} else {
+			header[name] = append(header[name], missing...)
+		}
+	}
+}
+
+// listElements splits a list valued header into its trimmed elements. Commas
+// within quoted strings, e.g. a Link title, or within the <> of a Link url
+// don't separate elements.
+func listElements(v string) []string {
+	var elements []string
+	quoted, bracketed, start := false, false, 0
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '<':
+			bracketed = true
+		case c == '>':
+			bracketed = false
+		case c == ',' && !bracketed:
+			if element := strings.TrimSpace(v[start:i]); element != "" {
+				elements = append(elements, element)
+			}
+			start = i + 1
+		}
+	}
+	if element := strings.TrimSpace(v[start:]); element != "" {
+		elements = append(elements, element)
+	}
+	return elements
+}
+
+// setsCookie reports whether a response header sets cookies.
+func setsCookie(header http.Header) bool {
+	return len(header["Set-Cookie"]) > 0 || len(header["Set-Cookie2"]) > 0
+}
+
+// storedHeader returns a copy of header without the excluded headers.
+func storedHeader(header http.Header, options Options) http.Header {
+	stored := make(http.Header, len(header))
+	trailers := declaredTrailers(header)
+	for k, vals := range header {
+		if excludedHeader(k, options) || trailers[k] || strings.HasPrefix(k, http.TrailerPrefix) {
+			continue
+		}
+		stored[k] = append([]string(nil), vals...)
+	}
+	return stored
+}
+
+// storedTrailer returns the trailers set on header by the handler, either
+// declared in the Trailer header or set with the http.TrailerPrefix, or nil if
+// there are none.
+func storedTrailer(header http.Header, options Options) http.Header {
+	var stored http.Header
+	trailers := declaredTrailers(header)
+	for k, vals := range header {
+		name := k
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			name = textproto.CanonicalMIMEHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))
+		} else if !trailers[k] {
+			continue
+		}
+		if excludedHeader(name, options) {
+			continue
+		}
+		if stored == nil {
+			stored = make(http.Header)
+		}
+		stored[name] = append(stored[name], vals...)
+	}
+	return stored
+}
+
+// declaredTrailers returns the canonical names listed in the Trailer header.
+func declaredTrailers(header http.Header) map[string]bool {
+	trailers := make(map[string]bool)
+	for _, vals := range header["Trailer"] {
+		for _, name := range strings.Split(vals, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				trailers[textproto.CanonicalMIMEHeaderKey(name)] = true
+			}
+		}
+	}
+	return trailers
+}
+
+// setTrailer sets the cached trailers on a response whose body was written.
+func setTrailer(header http.Header, trailer http.Header) {
+	for k, vals := range trailer {
+		header[http.TrailerPrefix+k] = append([]string(nil), vals...)
+	}
+}
+
+// mediaType returns the lower cased media type of the Content-Type header,
+// without its parameters, or an empty string if the header is absent.
+func mediaType(header http.Header) string {
+	contentType := header.Get("Content-Type")
+	if mediatype, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediatype
+	}
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
@@ -0,0 +1,268 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newCookieRouter(store CacheStore, options Options) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+		c.Header("Set-Cookie", "session=secret")
+		c.Header("X-Custom", "custom")
+		c.String(http.StatusOK, "body")
+	}))
+	return r
+}
+
+func TestCachePage_ExcludeHeaders(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r := newCookieRouter(store, Options{AllowSetCookie: true})
+
+	w := performRequest(r, "GET", "/page")
+	if w.Header().Get("Set-Cookie") != "session=secret" {
+		t.Errorf("Expected the generating request to get its cookie")
+	}
+
+	var cache ResponseCache
+	store.Get(urlEscape(PageCachePrefix, "/page"), &cache)
+	if _, found := cache.Header["Set-Cookie"]; found {
+		t.Errorf("Expected Set-Cookie not to be stored")
+	}
+
+	w = performRequest(r, "GET", "/page")
+	if _, found := w.Header()["Set-Cookie"]; found {
+		t.Errorf("Expected no Set-Cookie on a cache hit, got %q", w.Header().Get("Set-Cookie"))
+	}
+	if w.Header().Get("X-Custom") != "custom" {
+		t.Errorf("Expected other headers to be replayed")
+	}
+}
+
+func TestCachePage_ExcludeHeadersOnReplay(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	// An entry stored before the header was excluded.
+	store.Set(urlEscape(PageCachePrefix, "/page"), ResponseCache{
+		Status: http.StatusOK,
+		Header: http.Header{"Set-Cookie": {"session=secret"}},
+		Data:   []byte("body"),
+	}, DEFAULT)
+
+	w := performRequest(newCookieRouter(store, Options{}), "GET", "/page")
+	expectBody(t, w, "body")
+	if _, found := w.Header()["Set-Cookie"]; found {
+		t.Errorf("Expected stored Set-Cookie headers to be dropped on replay")
+	}
+}
+
+func TestCachePage_KeepAllHeaders(t *testing.T) {
+	r := newCookieRouter(NewInMemoryStore(time.Minute), Options{AllowSetCookie: true, ExcludeHeaders: []string{}})
+	performRequest(r, "GET", "/page")
+	w := performRequest(r, "GET", "/page")
+	if w.Header().Get("Set-Cookie") != "session=secret" {
+		t.Errorf("Expected Set-Cookie to be replayed when no header is excluded")
+	}
+}
+
+func TestCachePage_SkipSetCookie(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r := newCookieRouter(store, Options{})
+
+	w := performRequest(r, "GET", "/page")
+	if w.Header().Get("Set-Cookie") != "session=secret" {
+		t.Errorf("Expected the generating request to get its cookie")
+	}
+	var cache ResponseCache
+	if err := store.Get(urlEscape(PageCachePrefix, "/page"), &cache); err != ErrCacheMiss {
+		t.Errorf("Expected a response setting a cookie not to be stored, got: %v", err)
+	}
+}
+
+func TestCachePage_ReplaceHeadersOnReplay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.Writer.Header().Add("Vary", "Origin")
+	})
+	calls := 0
+	r.GET("/page", CachePage(NewInMemoryStore(time.Minute), time.Minute, func(c *gin.Context) {
+		calls++
+		c.Writer.Header().Add("Vary", "Accept-Language")
+		c.String(http.StatusOK, "body")
+	}))
+
+	performRequest(r, "GET", "/page")
+	w := performRequest(r, "GET", "/page")
+	if calls != 1 {
+		t.Fatalf("Expected the second request to be a hit, the handler ran %d times", calls)
+	}
+	if got := w.Header()["Content-Type"]; len(got) != 1 {
+		t.Errorf("Expected a single Content-Type on a hit, got %q", got)
+	}
+	if got := w.Header()["Vary"]; len(got) != 2 || got[0] != "Origin" || got[1] != "Accept-Language" {
+		t.Errorf("Expected the Vary values to be merged without duplicates, got %q", got)
+	}
+}
+
+func TestCachePage_LinkHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	links := []string{`</style.css>; rel=preload; as=style`, `</app.js>; rel=preload; as=script, </font.woff2>; rel=preload; as=font; title="a, b"`}
+	for _, skip := range []bool{false, true} {
+		r := gin.New()
+		r.Use(func(c *gin.Context) {
+			c.Writer.Header().Add("Link", `</style.css>; rel=preload; as=style`)
+		})
+		r.GET("/page", CachePageWithOptions(NewInMemoryStore(time.Minute), time.Minute, Options{SkipLinkHeaders: skip}, func(c *gin.Context) {
+			for _, link := range links {
+				c.Writer.Header().Add("Link", link)
+			}
+			c.String(http.StatusOK, "page")
+		}))
+
+		performRequest(r, "GET", "/page")
+		got := performRequest(r, "GET", "/page").Header()["Link"]
+		want := []string{links[0], links[1]}
+		if skip {
+			want = want[:1]
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("SkipLinkHeaders %t: expected the Link headers %q, got %q", skip, want, got)
+		}
+	}
+}
+
+func TestListElements(t *testing.T) {
+	for v, want := range map[string][]string{
+		"":                                nil,
+		"gzip":                            {"gzip"},
+		" Origin ,Accept-Language,, ":     {"Origin", "Accept-Language"},
+		`</a,b>; rel=preload, </c>`:       {`</a,b>; rel=preload`, `</c>`},
+		`</a>; title="x, \"y\", z", </b>`: {`</a>; title="x, \"y\", z"`, `</b>`},
+	} {
+		if got := listElements(v); strings.Join(got, "|") != strings.Join(want, "|") || len(got) != len(want) {
+			t.Errorf("Expected %q to be split into %q, got %q", v, want, got)
+		}
+	}
+}
+
+func TestCachePage_Trailer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	calls := 0
+	r.GET("/trailer", CachePage(NewInMemoryStore(time.Minute), time.Minute, func(c *gin.Context) {
+		calls++
+		c.Header("Trailer", "X-Checksum")
+		c.String(http.StatusOK, "body")
+		c.Writer.Header().Set("X-Checksum", "abc")
+		c.Writer.Header().Set(http.TrailerPrefix+"X-Count", "1")
+	}))
+
+	for i := 0; i < 2; i++ {
+		w := performRequest(r, "GET", "/trailer")
+		res := w.Result()
+		if res.Trailer.Get("X-Checksum") != "abc" || res.Trailer.Get("X-Count") != "1" {
+			t.Errorf("Request %d: expected the trailers to be sent, got %v", i, res.Trailer)
+		}
+		if res.Header.Get("X-Checksum") != "" {
+			t.Errorf("Request %d: expected the trailer not to be sent as a header", i)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected the second request to be a hit, the handler ran %d times", calls)
+	}
+}
+
+func TestCached_AccessControl(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tc := range []struct {
+		policy AccessControlPolicy
+		cors   bool
+	}{
+		{DefaultAccessControl, false},
+		{SkipAccessControl, false},
+		{KeepAccessControl, true},
+	} {
+		store := NewInMemoryStore(time.Minute)
+		r := gin.New()
+		r.Use(Cache(store))
+		r.GET("/page", CachedWithOptions(time.Minute, Options{AccessControl: tc.policy}), func(c *gin.Context) {
+			c.Header("Access-Control-Allow-Origin", "*")
+			c.String(http.StatusOK, "body")
+		})
+
+		performRequest(r, "GET", "/page")
+		var cache ResponseCache
+		store.Get(urlEscape(PageCachePrefix, "/page"), &cache)
+		if _, found := cache.Header["Access-Control-Allow-Origin"]; found != tc.cors {
+			t.Errorf("Policy %d: expected Access-Control headers stored: %t", tc.policy, tc.cors)
+		}
+		w := performRequest(r, "GET", "/page")
+		if _, found := w.Header()["Access-Control-Allow-Origin"]; found != tc.cors {
+			t.Errorf("Policy %d: expected Access-Control headers on a hit: %t", tc.policy, tc.cors)
+		}
+	}
+}
+
+func TestCachePage_SkipAccessControl(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(store, time.Minute, Options{AccessControl: SkipAccessControl}, func(c *gin.Context) {
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.String(http.StatusOK, "body")
+	}))
+
+	performRequest(r, "GET", "/page")
+	w := performRequest(r, "GET", "/page")
+	if _, found := w.Header()["Access-Control-Allow-Origin"]; found {
+		t.Errorf("Expected Access-Control headers to be skipped on a hit")
+	}
+}
+
+func TestMediaType(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                          "",
+		"text/html":                 "text/html",
+		"Text/HTML; charset=utf-8":  "text/html",
+		"application/json;charset=": "application/json",
+	} {
+		if got := mediaType(http.Header{"Content-Type": {header}}); got != want {
+			t.Errorf("%q: expected %q, got %q", header, want, got)
+		}
+	}
+}
+
+func TestCachePage_CacheableContentType(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	options := Options{CacheableContentType: func(mediaType string) bool {
+		return mediaType == "text/html" || mediaType == "application/json"
+	}}
+	r := gin.New()
+	r.GET("/:type", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+		switch c.Param("type") {
+		case "html":
+			c.Data(http.StatusOK, "text/html; charset=utf-8", []byte("<p>"))
+		case "json":
+			c.JSON(http.StatusOK, gin.H{"ok": true})
+		case "image":
+			c.Data(http.StatusOK, "image/png", []byte("png"))
+		default:
+			c.Writer.Write([]byte("untyped"))
+		}
+	}))
+
+	for path, cached := range map[string]bool{"/html": true, "/json": true, "/image": false, "/none": false} {
+		performRequest(r, "GET", path)
+		var cache ResponseCache
+		if err := store.Get(urlEscape(PageCachePrefix, path), &cache); (err == nil) != cached {
+			t.Errorf("%s: expected cached %t, got %v", path, cached, err)
+		}
+	}
+}
@@ -1,21 +1,261 @@
 package cache
 
 import (
-	"github.com/robfig/go-cache"
+	"container/list"
+	"hash/fnv"
 	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 )
 
+// sweepInterval is how often the background janitor removes expired entries.
+const sweepInterval = time.Minute
+
+// expiredScan is how many of the least recently used entries are checked for
+// expired ones to evict in priority once the limits are exceeded.
+const expiredScan = 64
+
+// admissionWindow is how many keys not admitted yet have their writes
+// counted, see SetAdmission.
+const admissionWindow = 10000
+
+// InMemoryStore is a process local CacheStore. Entries are kept in least
+// recently used order and the oldest ones are evicted once the configured
+// entry count or byte size is exceeded, expired entries first. Expired entries
+// are also dropped lazily on access and periodically by a background janitor. It is safe for concurrent use.
 type InMemoryStore struct {
-	cache.Cache
+	*inMemoryCache
+}
+
+type inMemoryCache struct {
+	sync.Mutex
+	defaultExpiration time.Duration
+	maxEntries        int
+	maxBytes          int
+	bytes             int
+	lru               *list.List
+	items             map[string]*list.Element
+	tags              map[string]*inMemoryTag
+	stop              chan struct{}
+	now               func() time.Time
+	// highWater is the utilization of the limits past which onHighWater is
+	// called, see OnHighWater.
+	highWater      float64
+	onHighWater    func()
+	aboveHighWater bool
+	// admitWrites is the number of writes admitting a new key, and pending
+	// counts the writes of the keys not admitted yet by hash, see
+	// SetAdmission.
+	admitWrites int
+	pending     map[uint64]int
+}
+
+// inMemoryTag is the set of keys sharing a tag, see Tag.
+type inMemoryTag struct {
+	keys    map[string]struct{}
+	expires time.Time
 }
 
+type inMemoryItem struct {
+	key     string
+	value   interface{}
+	size    int
+	expires time.Time
+}
+
+// NewInMemoryStore returns an unbounded in memory store.
 func NewInMemoryStore(defaultExpiration time.Duration) *InMemoryStore {
-	return &InMemoryStore{*cache.New(defaultExpiration, time.Minute)}
+	return NewInMemoryStoreWithLimits(defaultExpiration, 0, 0)
+}
+
+// NewInMemoryStoreWithLimits returns an in memory store holding at most
+// maxEntries entries taking at most maxBytes bytes. A limit of 0 disables it.
+func NewInMemoryStoreWithLimits(defaultExpiration time.Duration, maxEntries int, maxBytes int) *InMemoryStore {
+	c := &inMemoryCache{
+		defaultExpiration: defaultExpiration,
+		maxEntries:        maxEntries,
+		maxBytes:          maxBytes,
+		lru:               list.New(),
+		items:             make(map[string]*list.Element),
+		tags:              make(map[string]*inMemoryTag),
+		stop:              make(chan struct{}),
+		now:               time.Now,
+	}
+	go c.janitor(sweepInterval)
+	// The janitor only references the inner cache, so the store itself can be
+	// garbage collected, at which point the janitor is stopped.
+	store := &InMemoryStore{c}
+	runtime.SetFinalizer(store, func(s *InMemoryStore) { close(s.stop) })
+	return store
+}
+
+func (c *inMemoryCache) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.deleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *inMemoryCache) deleteExpired() {
+	c.Lock()
+	defer c.Unlock()
+	now := c.now()
+	for _, e := range c.items {
+		if c.expired(e, now) {
+			c.remove(e)
+		}
+	}
+	for name, tag := range c.tags {
+		if !tag.expires.IsZero() && now.After(tag.expires) {
+			delete(c.tags, name)
+		}
+	}
+	c.updateHighWater()
+}
+
+func (c *inMemoryCache) expired(e *list.Element, now time.Time) bool {
+	expires := e.Value.(*inMemoryItem).expires
+	return !expires.IsZero() && now.After(expires)
+}
+
+// lookup returns the live element for key, dropping it if it has expired.
+func (c *inMemoryCache) lookup(key string) (*list.Element, bool) {
+	e, found := c.items[key]
+	if !found {
+		return nil, false
+	}
+	if c.expired(e, c.now()) {
+		c.remove(e)
+		return nil, false
+	}
+	return e, true
+}
+
+func (c *inMemoryCache) remove(e *list.Element) {
+	item := c.lru.Remove(e).(*inMemoryItem)
+	delete(c.items, item.key)
+	c.bytes -= item.size
+}
+
+func (c *inMemoryCache) expiration(expires time.Duration) time.Time {
+	if expires == DEFAULT {
+		expires = c.defaultExpiration
+	}
+	if expires <= 0 {
+		return time.Time{}
+	}
+	return c.now().Add(expires)
+}
+
+// store inserts or overwrites key and evicts the least recently used entries
+// until the store fits in its limits again.
+func (c *inMemoryCache) store(key string, value interface{}, expires time.Duration) error {
+	size := sizeOf(value)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return ErrNotStored
+	}
+	if e, found := c.items[key]; found {
+		c.remove(e)
+	} else if !c.admit(key) {
+		return ErrNotAdmitted
+	}
+	item := &inMemoryItem{key, value, size, c.expiration(expires)}
+	c.items[key] = c.lru.PushFront(item)
+	c.bytes += size
+
+	if c.overLimits() {
+		c.evictExpired()
+	}
+	for c.overLimits() {
+		c.remove(c.lru.Back())
+	}
+	c.updateHighWater()
+	return nil
+}
+
+// admit counts a write of the new key and reports whether it is admitted.
+func (c *inMemoryCache) admit(key string) bool {
+	if c.admitWrites <= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	if c.pending[sum]+1 >= c.admitWrites {
+		delete(c.pending, sum)
+		return true
+	}
+	if len(c.pending) >= admissionWindow {
+		c.pending = make(map[uint64]int)
+	}
+	c.pending[sum]++
+	return false
+}
+
+// evictExpired removes the expired entries among the least recently used
+// ones, so they are evicted before live entries.
+func (c *inMemoryCache) evictExpired() {
+	now := c.now()
+	e := c.lru.Back()
+	for i := 0; e != nil && i < expiredScan; i++ {
+		prev := e.Prev()
+		if c.expired(e, now) {
+			c.remove(e)
+		}
+		e = prev
+	}
+}
+
+// utilization returns the fraction of the closest limit in use, or 0 for an
+// unbounded store.
+func (c *inMemoryCache) utilization() float64 {
+	var used float64
+	if c.maxEntries > 0 {
+		used = float64(c.lru.Len()) / float64(c.maxEntries)
+	}
+	if c.maxBytes > 0 {
+		if bytes := float64(c.bytes) / float64(c.maxBytes); bytes > used {
+			used = bytes
+		}
+	}
+	return used
+}
+
+// updateHighWater calls the OnHighWater callback when the utilization
+// crosses the threshold upwards.
+func (c *inMemoryCache) updateHighWater() {
+	if c.onHighWater == nil {
+		return
+	}
+	above := c.utilization() >= c.highWater
+	if above && !c.aboveHighWater {
+		go c.onHighWater()
+	}
+	c.aboveHighWater = above
+}
+
+func (c *inMemoryCache) overLimits() bool {
+	return (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) ||
+		(c.maxBytes > 0 && c.bytes > c.maxBytes)
 }
 
 func (c *InMemoryStore) Get(key string, value interface{}) error {
-	val, found := c.Cache.Get(key)
+	c.Lock()
+	e, found := c.lookup(key)
+	var val interface{}
+	if found {
+		c.lru.MoveToFront(e)
+		val = e.Value.(*inMemoryItem).value
+	}
+	c.Unlock()
 	if !found {
 		return ErrCacheMiss
 	}
@@ -28,51 +268,240 @@ func (c *InMemoryStore) Get(key string, value interface{}) error {
 	return ErrNotStored
 }
 
+// TTL returns the time left before key expires, or FOREVER.
+func (c *InMemoryStore) TTL(key string) (time.Duration, error) {
+	c.Lock()
+	defer c.Unlock()
+	e, found := c.lookup(key)
+	if !found {
+		return 0, ErrCacheMiss
+	}
+	expires := e.Value.(*inMemoryItem).expires
+	if expires.IsZero() {
+		return FOREVER, nil
+	}
+	return expires.Sub(c.now()), nil
+}
+
 func (c *InMemoryStore) Set(key string, value interface{}, expires time.Duration) error {
-	// NOTE: go-cache understands the values of DEFAULT and FOREVER
-	c.Cache.Set(key, value, expires)
-	return nil
+	c.Lock()
+	defer c.Unlock()
+	return c.store(key, value, expires)
 }
 
 func (c *InMemoryStore) Add(key string, value interface{}, expires time.Duration) error {
-	err := c.Cache.Add(key, value, expires)
-	if err == cache.ErrKeyExists {
+	c.Lock()
+	defer c.Unlock()
+	if _, found := c.lookup(key); found {
 		return ErrNotStored
 	}
-	return err
+	return c.store(key, value, expires)
 }
 
 func (c *InMemoryStore) Replace(key string, value interface{}, expires time.Duration) error {
-	if err := c.Cache.Replace(key, value, expires); err != nil {
+	c.Lock()
+	defer c.Unlock()
+	if _, found := c.lookup(key); !found {
 		return ErrNotStored
 	}
-	return nil
+	return c.store(key, value, expires)
 }
 
 func (c *InMemoryStore) Delete(key string) error {
-	if found := c.Cache.Delete(key); !found {
+	c.Lock()
+	defer c.Unlock()
+	e, found := c.lookup(key)
+	if !found {
 		return ErrCacheMiss
 	}
+	c.remove(e)
+	c.updateHighWater()
 	return nil
 }
 
 func (c *InMemoryStore) Increment(key string, n uint64) (uint64, error) {
-	newValue, err := c.Cache.Increment(key, n)
-	if err == cache.ErrCacheMiss {
-		return 0, ErrCacheMiss
-	}
-	return newValue, err
+	return c.add(key, func(current uint64) uint64 {
+		return current + n
+	})
 }
 
 func (c *InMemoryStore) Decrement(key string, n uint64) (uint64, error) {
-	newValue, err := c.Cache.Decrement(key, n)
-	if err == cache.ErrCacheMiss {
+	return c.add(key, func(current uint64) uint64 {
+		if n > current {
+			return 0
+		}
+		return current - n
+	})
+}
+
+// add replaces the integer stored at key with op applied to it, keeping the
+// original type of the stored value.
+func (c *InMemoryStore) add(key string, op func(uint64) uint64) (uint64, error) {
+	c.Lock()
+	defer c.Unlock()
+	e, found := c.lookup(key)
+	if !found {
 		return 0, ErrCacheMiss
 	}
-	return newValue, err
+	item := e.Value.(*inMemoryItem)
+
+	current := reflect.ValueOf(item.value)
+	next := reflect.New(current.Type()).Elem()
+	var result uint64
+	switch current.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		result = op(uint64(current.Int()))
+		next.SetInt(int64(result))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		result = op(current.Uint())
+		next.SetUint(result)
+	default:
+		return 0, ErrNotSupport
+	}
+	item.value = next.Interface()
+	c.lru.MoveToFront(e)
+	return result, nil
 }
 
 func (c *InMemoryStore) Flush() error {
-	c.Cache.Flush()
+	c.Lock()
+	defer c.Unlock()
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+	c.tags = make(map[string]*inMemoryTag)
+	c.bytes = 0
+	c.updateHighWater()
+	return nil
+}
+
+// FlushPrefix removes all the keys starting with prefix.
+func (c *InMemoryStore) FlushPrefix(prefix string) error {
+	c.Lock()
+	defer c.Unlock()
+	for key, e := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(e)
+		}
+	}
+	c.updateHighWater()
 	return nil
 }
+
+// Keys returns a snapshot of the live keys starting with prefix.
+func (c *InMemoryStore) Keys(prefix string) ([]string, error) {
+	c.Lock()
+	defer c.Unlock()
+	now := c.now()
+	var keys []string
+	for key, e := range c.items {
+		if strings.HasPrefix(key, prefix) && !c.expired(e, now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Tag adds key to the keys tagged with tag. Tags don't count towards the
+// limits of the store.
+func (c *InMemoryStore) Tag(tag string, key string, expire time.Duration) error {
+	c.Lock()
+	defer c.Unlock()
+	expires := c.expiration(expire)
+	t, found := c.tags[tag]
+	if !found || (!t.expires.IsZero() && c.now().After(t.expires)) {
+		t = &inMemoryTag{keys: make(map[string]struct{}), expires: expires}
+		c.tags[tag] = t
+	} else if !t.expires.IsZero() && (expires.IsZero() || expires.After(t.expires)) {
+		// The set lives as long as its longest lived key.
+		t.expires = expires
+	}
+	t.keys[key] = struct{}{}
+	return nil
+}
+
+// PopTag removes the keys tagged with tag and returns them.
+func (c *InMemoryStore) PopTag(tag string) ([]string, error) {
+	c.Lock()
+	defer c.Unlock()
+	t, found := c.tags[tag]
+	if !found {
+		return nil, nil
+	}
+	delete(c.tags, tag)
+	keys := make([]string, 0, len(t.keys))
+	for key := range t.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SetClock sets the time source the entries expire from, time.Now by
+// default, so tests can expire entries without sleeping. It should be set
+// before the store is used.
+func (c *InMemoryStore) SetClock(now func() time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = now
+}
+
+// SetAdmission makes the store only admit a new key on its writes-th write,
+// e.g. 2 for the pages requested at least twice, so a flood of keys written
+// once, such as urls with random query strings, doesn't evict the others.
+// The writes of a key not admitted yet fail with ErrNotAdmitted. They are
+// counted for the last 10000 such keys, by hash, so a key can rarely be
+// admitted early. A value of 1 or less admits every key, the default.
+func (c *InMemoryStore) SetAdmission(writes int) {
+	c.Lock()
+	defer c.Unlock()
+	c.admitWrites = writes
+	c.pending = make(map[uint64]int)
+}
+
+// OnHighWater sets cb to be called, in a new goroutine, whenever the store
+// goes from under to over fraction of its entry count or byte size limit,
+// e.g. 0.9, so the application can shed load or log before entries get
+// evicted. It has no effect on an unbounded store.
+func (c *InMemoryStore) OnHighWater(fraction float64, cb func()) {
+	c.Lock()
+	defer c.Unlock()
+	c.highWater, c.onHighWater = fraction, cb
+	c.aboveHighWater = c.utilization() >= fraction
+}
+
+// Len returns the number of entries in the store, including the expired ones
+// not removed yet.
+func (c *InMemoryStore) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.lru.Len()
+}
+
+// Size returns the approximate size of the entries in the store, in bytes.
+func (c *InMemoryStore) Size() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.bytes
+}
+
+// sizeOf approximates the memory taken by a cached value.
+func sizeOf(value interface{}) int {
+	switch v := value.(type) {
+	case []byte:
+		return len(v)
+	case string:
+		return len(v)
+	case ResponseCache:
+		size := len(v.Data)
+		for k, vals := range v.Header {
+			size += len(k)
+			for _, val := range vals {
+				size += len(val)
+			}
+		}
+		return size
+	}
+	if value == nil {
+		return 0
+	}
+	return int(reflect.TypeOf(value).Size())
+}
@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
@@ -22,6 +24,10 @@ func TestInMemoryCache_Expiration(t *testing.T) {
 	expiration(t, newInMemoryStore)
 }
 
+func TestInMemoryCache_ExpirationConstants(t *testing.T) {
+	expirationConstants(t, newInMemoryStore)
+}
+
 func TestInMemoryCache_EmptyCache(t *testing.T) {
 	emptyCache(t, newInMemoryStore)
 }
@@ -33,3 +39,234 @@ func TestInMemoryCache_Replace(t *testing.T) {
 func TestInMemoryCache_Add(t *testing.T) {
 	testAdd(t, newInMemoryStore)
 }
+
+func TestInMemoryCache_TTL(t *testing.T) {
+	testTTL(t, newInMemoryStore)
+}
+
+func TestInMemoryCache_EvictionOrder(t *testing.T) {
+	cache := NewInMemoryStoreWithLimits(time.Hour, 2, 0)
+	cache.Set("a", 1, DEFAULT)
+	cache.Set("b", 2, DEFAULT)
+
+	// Touch "a" so that "b" becomes the least recently used entry.
+	var i int
+	if err := cache.Get("a", &i); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	cache.Set("c", 3, DEFAULT)
+
+	if err := cache.Get("b", &i); err != ErrCacheMiss {
+		t.Errorf("Expected b to be evicted, got: %v", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if err := cache.Get(key, &i); err != nil {
+			t.Errorf("Expected %s to survive eviction, got: %s", key, err)
+		}
+	}
+}
+
+func TestInMemoryCache_MaxBytes(t *testing.T) {
+	cache := NewInMemoryStoreWithLimits(time.Hour, 0, 10)
+	cache.Set("a", []byte("12345"), DEFAULT)
+	cache.Set("b", []byte("12345"), DEFAULT)
+	cache.Set("c", []byte("1"), DEFAULT)
+
+	var b []byte
+	if err := cache.Get("a", &b); err != ErrCacheMiss {
+		t.Errorf("Expected a to be evicted, got: %v", err)
+	}
+	if cache.bytes != 6 {
+		t.Errorf("Expected 6 bytes accounted, got %d", cache.bytes)
+	}
+	if err := cache.Set("big", make([]byte, 11), DEFAULT); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored for an oversized value, got: %v", err)
+	}
+}
+
+func TestInMemoryCache_Sweep(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewInMemoryStore(time.Hour)
+	cache.SetClock(clock.Now)
+	cache.Set("short", 1, 10*time.Millisecond)
+	cache.Set("long", 1, DEFAULT)
+	clock.Advance(20 * time.Millisecond)
+
+	cache.deleteExpired()
+	if _, found := cache.items["short"]; found {
+		t.Errorf("Expected expired entry to be swept")
+	}
+	if _, found := cache.items["long"]; !found {
+		t.Errorf("Expected live entry to survive the sweep")
+	}
+}
+
+func TestInMemoryCache_Clock(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewInMemoryStore(time.Hour)
+	cache.SetClock(clock.Now)
+	cache.Set("a", 1, time.Minute)
+
+	clock.Advance(20 * time.Second)
+	if ttl, err := cache.TTL("a"); err != nil || ttl != 40*time.Second {
+		t.Errorf("Expected 40s left, got %s: %v", ttl, err)
+	}
+	clock.Advance(41 * time.Second)
+	var i int
+	if err := cache.Get("a", &i); err != ErrCacheMiss {
+		t.Errorf("Expected the entry to expire on the clock, got %v", err)
+	}
+}
+
+func TestInMemoryCache_Concurrent(t *testing.T) {
+	cache := NewInMemoryStoreWithLimits(time.Hour, 50, 0)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("%d-%d", g, i%100)
+				cache.Set(key, i, DEFAULT)
+				var v int
+				cache.Get(key, &v)
+				cache.Delete(key)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := cache.lru.Len(); n != len(cache.items) || n > 50 {
+		t.Errorf("Inconsistent store after concurrent access: %d list, %d map", n, len(cache.items))
+	}
+}
+
+func TestInMemoryCache_ConcurrentIncrement(t *testing.T) {
+	cache := NewInMemoryStore(time.Hour)
+	cache.Set("counter", uint64(0), DEFAULT)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				cache.Increment("counter", 2)
+				cache.Decrement("counter", 1)
+			}
+		}()
+	}
+	wg.Wait()
+	var counter uint64
+	if err := cache.Get("counter", &counter); err != nil || counter != 800 {
+		t.Errorf("Expected concurrent updates to sum to 800, got %d, %v", counter, err)
+	}
+}
+
+func TestInMemoryCache_IncrementNotInteger(t *testing.T) {
+	cache := NewInMemoryStore(time.Hour)
+	cache.Set("string", "value", DEFAULT)
+	if _, err := cache.Increment("string", 1); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport incrementing a string, got: %v", err)
+	}
+}
+
+func TestInMemoryCache_OnHighWater(t *testing.T) {
+	cache := NewInMemoryStoreWithLimits(time.Hour, 10, 0)
+	fired := make(chan struct{}, 10)
+	cache.OnHighWater(0.8, func() { fired <- struct{}{} })
+	expectFired := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			select {
+			case <-fired:
+			case <-time.After(time.Second):
+				t.Fatalf("Expected the callback to fire")
+			}
+		}
+		select {
+		case <-fired:
+			t.Fatalf("Expected the callback to fire only %d times", n)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	for i := 0; i < 7; i++ {
+		cache.Set(fmt.Sprint(i), i, DEFAULT)
+	}
+	expectFired(0)
+	// Crossing the threshold fires once, staying over it doesn't.
+	cache.Set("7", 7, DEFAULT)
+	cache.Set("8", 8, DEFAULT)
+	expectFired(1)
+	// Going under the threshold and over it again fires again.
+	cache.Delete("8")
+	cache.Delete("7")
+	cache.Set("7", 7, DEFAULT)
+	expectFired(1)
+}
+
+func TestInMemoryCache_SizeAccounting(t *testing.T) {
+	cache := NewInMemoryStoreWithLimits(time.Hour, 0, 100)
+	cache.Set("a", "12345", DEFAULT)
+	cache.Set("b", []byte("123"), DEFAULT)
+	if cache.Len() != 2 || cache.Size() != 8 {
+		t.Errorf("Expected 2 entries of 8 bytes, got %d of %d", cache.Len(), cache.Size())
+	}
+	cache.Set("a", "1", DEFAULT)
+	if cache.Size() != 4 {
+		t.Errorf("Expected an overwrite to replace the size of the entry, got %d", cache.Size())
+	}
+	cache.Delete("b")
+	if cache.Len() != 1 || cache.Size() != 1 {
+		t.Errorf("Expected 1 entry of 1 byte, got %d of %d", cache.Len(), cache.Size())
+	}
+	cache.Flush()
+	if cache.Len() != 0 || cache.Size() != 0 {
+		t.Errorf("Expected an empty store, got %d entries of %d bytes", cache.Len(), cache.Size())
+	}
+}
+
+func TestInMemoryCache_EvictExpiredFirst(t *testing.T) {
+	cache := NewInMemoryStoreWithLimits(time.Hour, 2, 0)
+	cache.Set("live", 1, DEFAULT)
+	cache.Set("short", 1, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	// "live" is the least recently used entry, but "short" has expired.
+	cache.Set("new", 1, DEFAULT)
+
+	var i int
+	if err := cache.Get("live", &i); err != nil {
+		t.Errorf("Expected the expired entry to be evicted before the live one, got: %v", err)
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", cache.Len())
+	}
+}
+
+func TestInMemoryCache_Admission(t *testing.T) {
+	cache := NewInMemoryStore(time.Hour)
+	cache.SetAdmission(3)
+	for i := 0; i < 2; i++ {
+		if err := cache.Set("key", i, DEFAULT); err != ErrNotAdmitted {
+			t.Errorf("Expected write %d of a new key not to be admitted, got %v", i+1, err)
+		}
+	}
+	if err := cache.Set("key", 2, DEFAULT); err != nil {
+		t.Errorf("Expected the third write to be admitted, got %v", err)
+	}
+	// Admitted keys are written as usual.
+	if err := cache.Set("key", 3, DEFAULT); err != nil {
+		t.Errorf("Expected an admitted key to be overwritten, got %v", err)
+	}
+	var value int
+	if err := cache.Get("key", &value); err != nil || value != 3 {
+		t.Errorf("Expected 3, got %d: %v", value, err)
+	}
+	if err := cache.Add("other", 1, DEFAULT); err != ErrNotAdmitted {
+		t.Errorf("Expected Add of a new key not to be admitted, got %v", err)
+	}
+
+	cache.SetAdmission(0)
+	if err := cache.Set("new", 1, DEFAULT); err != nil {
+		t.Errorf("Expected every key to be admitted, got %v", err)
+	}
+}
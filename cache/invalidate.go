@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"net/url"
+)
+
+// PrefixFlusher is implemented by stores able to remove all the keys sharing
+// a prefix.
+type PrefixFlusher interface {
+	FlushPrefix(prefix string) error
+}
+
+// InvalidateURL removes the cached page for u, e.g. "/products/42?page=2", as
+// stored by the middlewares with default options.
+func InvalidateURL(store CacheStore, u string) error {
+	return InvalidateURLWithOptions(store, u, Options{})
+}
+
+// InvalidateURLWithOptions removes the cached page for u as stored by the
+// middlewares configured with options, for every cached method. Pages keyed by a KeyFunc can't be
+// derived from their url and must be deleted by key.
+func InvalidateURLWithOptions(store CacheStore, u string, options Options) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	options = applyDefaults(options)
+	err = ErrCacheMiss
+	for _, method := range options.Methods {
+		switch deleteErr := store.Delete(urlKey(parsed, method, options)); deleteErr {
+		case nil:
+			err = nil
+		case ErrCacheMiss:
+		default:
+			return deleteErr
+		}
+	}
+	return err
+}
+
+// InvalidatePrefix removes the cached pages whose url starts with prefix. It
+// requires the store to implement PrefixFlusher and returns ErrNotSupport
+// otherwise. Urls long enough to be stored under a hashed key aren't matched.
+func InvalidatePrefix(store CacheStore, prefix string) error {
+	return InvalidatePrefixWithOptions(store, prefix, Options{})
+}
+
+// InvalidatePrefixWithOptions is like InvalidatePrefix for the pages stored
+// by the middlewares configured with options.
+func InvalidatePrefixWithOptions(store CacheStore, prefix string, options Options) error {
+	flusher, ok := store.(PrefixFlusher)
+	if !ok {
+		return ErrNotSupport
+	}
+	options = applyDefaults(options)
+	for _, method := range options.Methods {
+		if err := flusher.FlushPrefix(methodPrefix(method, options) + ":" + url.QueryEscape(prefix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FlushPages removes all the pages stored by the middlewares with the default
+// prefix, leaving the other keys of the store alone. It requires the store to
+// implement PrefixFlusher and returns ErrNotSupport otherwise.
+func FlushPages(store CacheStore) error {
+	return FlushPagesWithOptions(store, Options{})
+}
+
+// FlushPagesWithOptions is like FlushPages for the pages stored by the
+// middlewares configured with options.
+func FlushPagesWithOptions(store CacheStore, options Options) error {
+	flusher, ok := store.(PrefixFlusher)
+	if !ok {
+		return ErrNotSupport
+	}
+	return flusher.FlushPrefix(applyDefaults(options).Prefix + ":")
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInvalidateURL(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r := newCountingRouter(store, Options{})
+	expectBody(t, performRequest(r, "GET", "/page?a=1"), "1")
+	expectBody(t, performRequest(r, "GET", "/page?a=1"), "1")
+
+	if err := InvalidateURL(store, "/page?a=1"); err != nil {
+		t.Errorf("Unexpected error invalidating: %s", err)
+	}
+	expectBody(t, performRequest(r, "GET", "/page?a=1"), "2")
+
+	if err := InvalidateURL(store, "/notcached"); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss invalidating an uncached url, got: %v", err)
+	}
+}
+
+func TestInvalidateURLWithOptions(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	options := Options{NormalizeQuery: true}
+	r := newCountingRouter(store, options)
+	expectBody(t, performRequest(r, "GET", "/page?b=2&a=1"), "1")
+
+	if err := InvalidateURLWithOptions(store, "/page?a=1&b=2", options); err != nil {
+		t.Errorf("Unexpected error invalidating: %s", err)
+	}
+	expectBody(t, performRequest(r, "GET", "/page?b=2&a=1"), "2")
+}
+
+func TestInvalidatePrefix(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r := newCountingRouter(store, Options{})
+	expectBody(t, performRequest(r, "GET", "/page?a=1"), "1")
+	expectBody(t, performRequest(r, "GET", "/page?a=2"), "2")
+	store.Set("unrelated", 1, DEFAULT)
+
+	if err := InvalidatePrefix(store, "/page?"); err != nil {
+		t.Errorf("Unexpected error invalidating: %s", err)
+	}
+	expectBody(t, performRequest(r, "GET", "/page?a=1"), "3")
+	expectBody(t, performRequest(r, "GET", "/page?a=2"), "4")
+	var i int
+	if err := store.Get("unrelated", &i); err != nil {
+		t.Errorf("Expected unrelated key to survive, got: %s", err)
+	}
+
+	if err := InvalidatePrefix(failingStore{}, "/page"); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport for a store without prefix support, got: %v", err)
+	}
+}
+
+func TestFlushPages(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r := newCountingRouter(store, Options{})
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+	store.Set("unrelated", 1, DEFAULT)
+
+	if err := FlushPages(store); err != nil {
+		t.Errorf("Unexpected error flushing pages: %s", err)
+	}
+	expectBody(t, performRequest(r, "GET", "/page"), "2")
+	var i int
+	if err := store.Get("unrelated", &i); err != nil {
+		t.Errorf("Expected unrelated key to survive, got: %s", err)
+	}
+
+	if err := FlushPages(failingStore{}); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport for a store without prefix support, got: %v", err)
+	}
+}
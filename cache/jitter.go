@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// jitterRand returns a random number in [0.0,1.0). It is replaced by the tests.
+var jitterRand = lockedRand(rand.New(rand.NewSource(time.Now().UnixNano())))
+
+// lockedRand makes r safe for concurrent use.
+func lockedRand(r *rand.Rand) func() float64 {
+	var mu sync.Mutex
+	return func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return r.Float64()
+	}
+}
+
+// jitter spreads expire randomly by up to ExpireJitter of it, capped at
+// ExpireJitterMax, in either direction. DEFAULT and FOREVER are left as is.
+func jitter(expire time.Duration, options Options) time.Duration {
+	if expire <= 0 || (options.ExpireJitter <= 0 && options.ExpireJitterMax <= 0) {
+		return expire
+	}
+	spread := options.ExpireJitterMax
+	if options.ExpireJitter > 0 {
+		spread = time.Duration(float64(expire) * options.ExpireJitter)
+		if options.ExpireJitterMax > 0 && spread > options.ExpireJitterMax {
+			spread = options.ExpireJitterMax
+		}
+	}
+	jittered := expire + time.Duration((2*jitterRand()-1)*float64(spread))
+	if jittered < time.Second {
+		// Keep a valid expiration for the stores counting in seconds.
+		return time.Second
+	}
+	return jittered
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	saved := jitterRand
+	defer func() { jitterRand = saved }()
+	jitterRand = lockedRand(rand.New(rand.NewSource(1)))
+
+	store := newRecordingStore()
+	r := newCountingRouter(store, Options{ExpireJitter: 0.1})
+	performRequest(r, "GET", "/page?a")
+	performRequest(r, "GET", "/page?b")
+
+	a := store.expires[urlEscape(PageCachePrefix, "/page?a")]
+	b := store.expires[urlEscape(PageCachePrefix, "/page?b")]
+	if a == b {
+		t.Errorf("Expected pages stored together to get different expirations, got %s", a)
+	}
+	for _, expire := range []time.Duration{a, b} {
+		if expire < 54*time.Second || expire > 66*time.Second {
+			t.Errorf("Expected an expiration within 10%% of a minute, got %s", expire)
+		}
+	}
+}
+
+func TestJitter_Bounds(t *testing.T) {
+	saved := jitterRand
+	defer func() { jitterRand = saved }()
+
+	for _, tc := range []struct {
+		random  float64
+		options Options
+		expire  time.Duration
+		want    time.Duration
+	}{
+		{0.0, Options{ExpireJitter: 0.5}, time.Minute, 30 * time.Second},
+		{0.5, Options{ExpireJitter: 0.5}, time.Minute, time.Minute},
+		{1.0, Options{ExpireJitter: 0.5, ExpireJitterMax: 10 * time.Second}, time.Minute, 70 * time.Second},
+		{0.0, Options{ExpireJitterMax: 10 * time.Second}, time.Minute, 50 * time.Second},
+		{0.0, Options{ExpireJitterMax: time.Hour}, time.Minute, time.Second},
+		{0.0, Options{ExpireJitter: 0.5}, FOREVER, FOREVER},
+		{0.0, Options{}, time.Minute, time.Minute},
+	} {
+		random := tc.random
+		jitterRand = func() float64 { return random }
+		if got := jitter(tc.expire, tc.options); got != tc.want {
+			t.Errorf("Expected %s jittered by %+v with %v to be %s, got %s", tc.expire, tc.options, tc.random, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pageKey returns the store key of the page requested in x: the url of the
+// page, followed by a segment for each of the KeyCookies, KeyLanguages and
+// KeyContext options in use. Each segment starts with a space, which request
+// URIs can't contain, so a segment can't be forged with the url.
+func pageKey(x *exchange, options Options) string {
+	prefix := methodPrefix(keyMethod(x.r.Method, options), options)
+	if options.KeyFunc != nil {
+		return pageEscape(prefix, options.KeyFunc(x.c), options)
+	}
+	host := x.r.Host
+	if host == "" {
+		host = x.r.URL.Host
+	}
+	return pageEscape(prefix, siteOf(requestScheme(x.r), host, options)+requestURI(x.r.URL, options)+cookiesOf(x.r, options)+languageOf(x.r, options)+contextOf(x.c, options), options)
+}
+
+// cookiesOf returns the key segment, see pageKey, holding the values of the
+// KeyCookies, sorted by name.
+func cookiesOf(r *http.Request, options Options) string {
+	if len(options.KeyCookies) == 0 {
+		return ""
+	}
+	values := url.Values{}
+	for _, name := range options.KeyCookies {
+		value := ""
+		if cookie, err := r.Cookie(name); err == nil {
+			value = cookie.Value
+		}
+		values.Set(name, value)
+	}
+	return " " + values.Encode()
+}
+
+// PageKey returns the key under which the middlewares configured with
+// options store the page at u, e.g. "/products/42?page=2", requested with
+// method. Pages keyed by a KeyFunc or by KeyCookies can't be derived from
+// their url.
+func PageKey(method string, u string, options Options) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	return urlKey(parsed, method, applyDefaults(options)), nil
+}
+
+// contextOf returns the key segment, see pageKey, holding the values of the
+// KeyContext, in order. A missing value is written as "!", which escaped
+// values can't contain.
+func contextOf(c *gin.Context, options Options) string {
+	if len(options.KeyContext) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(" ctx:")
+	for i, name := range options.KeyContext {
+		if i > 0 {
+			b.WriteString("&")
+		}
+		b.WriteString(url.QueryEscape(name))
+		if value, ok := c.Get(name); ok {
+			b.WriteString("=" + url.QueryEscape(fmt.Sprint(value)))
+		} else {
+			b.WriteString("!")
+		}
+	}
+	return b.String()
+}
+
+// urlKey returns the store key of the page at u requested with method when
+// the key isn't customized by KeyFunc. u must be absolute when the key
+// includes the host.
+func urlKey(u *url.URL, method string, options Options) string {
+	return pageEscape(methodPrefix(method, options), siteOf(u.Scheme, u.Host, options)+requestURI(u, options), options)
+}
+
+// siteOf returns the part of the key identifying the site, according to the
+// KeyHost and KeyScheme options.
+func siteOf(scheme string, host string, options Options) string {
+	if !options.KeyHost {
+		return ""
+	}
+	if options.KeyScheme && scheme != "" {
+		return scheme + "://" + strings.ToLower(host)
+	}
+	return strings.ToLower(host)
+}
+
+// requestScheme returns the scheme the client used, as forwarded by a proxy.
+func requestScheme(r *http.Request) string {
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		return strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func requestURI(u *url.URL, options Options) string {
+	if options.NormalizeQuery {
+		return normalizedURI(u, options.IgnoreQueryParams)
+	}
+	return u.RequestURI()
+}
+
+// methodPrefix returns the key prefix of the pages requested with method.
+// Pages requested with GET go without the method, so their keys don't depend
+// on the Methods option.
+func methodPrefix(method string, options Options) string {
+	if method == "GET" {
+		return options.Prefix
+	}
+	return options.Prefix + ":" + method
+}
+
+// keyMethod returns the method whose entry serves a request with method.
+func keyMethod(method string, options Options) string {
+	if method == "HEAD" && !options.SeparateHead {
+		return "GET"
+	}
+	return method
+}
+
+// cacheableMethod reports whether requests with method may be cached.
+func cacheableMethod(method string, options Options) bool {
+	for _, m := range options.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasQuery reports whether u has query parameters, not counting the ones
+// ignored by IgnoreQueryParams when NormalizeQuery is set.
+func hasQuery(u *url.URL, options Options) bool {
+	if u.RawQuery == "" || !options.NormalizeQuery {
+		return u.RawQuery != ""
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return true
+	}
+	for name := range query {
+		if !ignoredParam(name, options.IgnoreQueryParams) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizedURI returns the request URI of u with its query parameters sorted
+// by name and the ignored ones removed.
+func normalizedURI(u *url.URL, ignore []string) string {
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return u.RequestURI()
+	}
+	for name := range query {
+		if ignoredParam(name, ignore) {
+			delete(query, name)
+		}
+	}
+	uri := (&url.URL{Path: u.Path, RawPath: u.RawPath}).RequestURI()
+	if len(query) > 0 {
+		// Encode sorts by name and keeps the order of repeated values.
+		uri += "?" + query.Encode()
+	}
+	return uri
+}
+
+func ignoredParam(name string, ignore []string) bool {
+	for _, pattern := range ignore {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"net/http"
+	"time"
+)
+
+// KeyLister is implemented by stores able to list their keys, e.g. for admin
+// tooling.
+type KeyLister interface {
+	// Keys returns the keys starting with prefix. Listing a large store is
+	// slow and the result may be stale by the time it is returned.
+	Keys(prefix string) ([]string, error)
+}
+
+// PageEntry describes a page stored by the middlewares.
+type PageEntry struct {
+	Key string
+	// Size is the size of the stored body, 0 for the index entries of pages
+	// varying by request headers.
+	Size int
+	// TTL is the time left before the entry expires, FOREVER if it never
+	// does, or 0 if the store can't tell.
+	TTL time.Duration
+}
+
+// ListPages returns the pages stored by the middlewares with the default
+// prefix. It requires the store to implement KeyLister and returns
+// ErrNotSupport otherwise.
+func ListPages(store CacheStore) ([]PageEntry, error) {
+	return ListPagesWithOptions(store, Options{})
+}
+
+// ListPagesWithOptions is like ListPages for the pages stored by the
+// middlewares configured with options.
+func ListPagesWithOptions(store CacheStore, options Options) ([]PageEntry, error) {
+	lister, ok := store.(KeyLister)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	keys, err := lister.Keys(applyDefaults(options).Prefix + ":")
+	if err != nil {
+		return nil, err
+	}
+	ttls, hasTTL := ttlStore(store)
+	entries := make([]PageEntry, 0, len(keys))
+	for _, key := range keys {
+		var cache ResponseCache
+		switch err := store.Get(key, &cache); err {
+		case nil:
+		case ErrCacheMiss:
+			// Expired or removed since it was listed.
+			continue
+		default:
+			return nil, err
+		}
+		entry := PageEntry{Key: key, Size: len(cache.Data)}
+		if hasTTL {
+			if ttl, err := ttls.TTL(key); err == nil {
+				entry.TTL = ttl
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Lookup returns the status, headers and body of the page stored at key by
+// the middlewares with the default options, e.g. to inspect it in admin
+// tools or tests, or ErrCacheMiss if there is none. The key can be derived
+// with PageKey. Compressed bodies are decompressed. It returns
+// ErrNotSupport for a page varying on request headers, whose variants are
+// stored under keys of their own.
+func Lookup(store CacheStore, key string) (status int, header http.Header, body []byte, err error) {
+	return LookupWithOptions(store, key, Options{})
+}
+
+// LookupWithOptions is like Lookup for the pages stored by the middlewares
+// configured with options, decompressing with their Compressor.
+func LookupWithOptions(store CacheStore, key string, options Options) (status int, header http.Header, body []byte, err error) {
+	var cache ResponseCache
+	if err := store.Get(key, &cache); err != nil {
+		return 0, nil, nil, err
+	}
+	if len(cache.Vary) > 0 {
+		return 0, nil, nil, ErrNotSupport
+	}
+	if cache.Compressed {
+		if err := decompress(&cache, applyDefaults(options)); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	return cache.Status, cache.Header, cache.Data, nil
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testKeys checks that Keys lists the live keys under a prefix.
+func testKeys(t *testing.T, newCache cacheFactory) {
+	cache := newCache(t, time.Hour)
+	cache.Set("page:a", 1, DEFAULT)
+	cache.Set("page:b", 2, DEFAULT)
+	cache.Set("page:c", 3, 100*time.Millisecond)
+	cache.Set("other", 4, DEFAULT)
+	time.Sleep(200 * time.Millisecond)
+
+	keys, err := cache.(KeyLister).Keys("page:")
+	if err != nil {
+		t.Fatalf("Unexpected error listing keys: %s", err)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "page:a" || keys[1] != "page:b" {
+		t.Errorf("Expected [page:a page:b], got %v", keys)
+	}
+}
+
+func TestInMemoryCache_Keys(t *testing.T) {
+	testKeys(t, newInMemoryStore)
+}
+
+func TestBoltStore_Keys(t *testing.T) {
+	testKeys(t, newBoltStore)
+}
+
+func TestTieredCache_Keys(t *testing.T) {
+	testKeys(t, newTieredStore)
+}
+
+func TestListPages(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r := newCountingRouter(store, Options{})
+	expectBody(t, performRequest(r, "GET", "/page?a=1"), "1")
+	expectBody(t, performRequest(r, "GET", "/page?a=2"), "2")
+	store.Set("unrelated", 1, DEFAULT)
+
+	entries, err := ListPages(store)
+	if err != nil {
+		t.Fatalf("Unexpected error listing pages: %s", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 pages, got %v", entries)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	for i, u := range []string{"/page?a=1", "/page?a=2"} {
+		if !strings.HasSuffix(entries[i].Key, url.QueryEscape(u)) {
+			t.Errorf("Expected the key of %q, got %q", u, entries[i].Key)
+		}
+		if entries[i].Size != 1 {
+			t.Errorf("Expected a size of 1, got %d", entries[i].Size)
+		}
+		if entries[i].TTL <= 0 || entries[i].TTL > time.Minute {
+			t.Errorf("Expected a TTL of at most a minute, got %s", entries[i].TTL)
+		}
+	}
+
+	if _, err := ListPages(failingStore{}); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport for a store without key listing, got: %v", err)
+	}
+}
+
+func TestNamespaceKeys(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	ns := NewNamespaceStore(store, "ns:")
+	ns.Set("a", 1, DEFAULT)
+	store.Set("a", 1, DEFAULT)
+
+	keys, err := ns.Keys("")
+	if err != nil {
+		t.Fatalf("Unexpected error listing keys: %s", err)
+	}
+	if len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("Expected the namespace keys only, got %v", keys)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	options := Options{Compress: true, CompressMinSize: 1}
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+		c.Header("X-Page", "yes")
+		c.String(http.StatusOK, "page")
+	}))
+	r.GET("/vary", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+		c.Header("Vary", "Accept-Language")
+		c.String(http.StatusOK, "vary")
+	}))
+	performRequest(r, "GET", "/page")
+	performRequest(r, "GET", "/vary")
+
+	key, _ := PageKey("GET", "/page", options)
+	status, header, body, err := LookupWithOptions(store, key, options)
+	if err != nil || status != http.StatusOK || header.Get("X-Page") != "yes" || string(body) != "page" {
+		t.Errorf("Expected the cached page, got %d %v %q: %v", status, header, body, err)
+	}
+	if _, _, _, err := Lookup(store, key+"?missing"); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss for a missing page, got %v", err)
+	}
+	key, _ = PageKey("GET", "/vary", options)
+	if _, _, _, err := Lookup(store, key); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport for a varying page, got %v", err)
+	}
+}
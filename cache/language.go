@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// languageOf returns the key segment, see pageKey, holding the language
+// negotiated for the request among the KeyLanguages.
+func languageOf(r *http.Request, options Options) string {
+	if len(options.KeyLanguages) == 0 {
+		return ""
+	}
+	return " lang:" + negotiateLanguage(r.Header.Get("Accept-Language"), options.KeyLanguages)
+}
+
+// languageRange is a language of an Accept-Language header with its weight.
+type languageRange struct {
+	tag string
+	q   float64
+}
+
+// negotiateLanguage returns the supported language best matching the
+// Accept-Language header, or the first supported language if none does. A
+// range matches a language of the same tag or of the same primary language,
+// e.g. en-US matches en, while * matches any.
+func negotiateLanguage(header string, supported []string) string {
+	var ranges []languageRange
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" {
+			continue
+		}
+		if q := quality(fields[1:]); q > 0 {
+			ranges = append(ranges, languageRange{tag, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, r := range ranges {
+		if r.tag == "*" {
+			return supported[0]
+		}
+		for _, lang := range supported {
+			if strings.EqualFold(r.tag, lang) {
+				return lang
+			}
+		}
+		for _, lang := range supported {
+			if strings.EqualFold(primaryLanguage(r.tag), primaryLanguage(lang)) {
+				return lang
+			}
+		}
+	}
+	return supported[0]
+}
+
+// primaryLanguage returns the primary language of tag, e.g. en for en-US.
+func primaryLanguage(tag string) string {
+	if i := strings.IndexByte(tag, '-'); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
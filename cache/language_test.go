@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	supported := []string{"en", "fr", "pt-BR"}
+	for header, expected := range map[string]string{
+		"":                        "en",
+		"en-US,en;q=0.9":          "en",
+		"en-GB,en;q=0.8":          "en",
+		"fr-CH, fr;q=0.9, en;q=0": "fr",
+		"de, fr;q=0.5":            "fr",
+		"de":                      "en",
+		"en;q=0.1, pt-br":         "pt-BR",
+		"pt-PT":                   "pt-BR",
+		"de, *;q=0.5":             "en",
+		"fr;q=0, en;q=0.5":        "en",
+	} {
+		if lang := negotiateLanguage(header, supported); lang != expected {
+			t.Errorf("Expected %q to negotiate %q, got %q", header, expected, lang)
+		}
+	}
+}
+
+func TestCachePage_KeyLanguages(t *testing.T) {
+	r := newCountingRouter(NewInMemoryStore(time.Minute), Options{KeyLanguages: []string{"en", "fr"}})
+	lang := func(header string) http.Header {
+		return http.Header{"Accept-Language": {header}}
+	}
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", lang("en-US,en;q=0.9")), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", lang("en-GB,en;q=0.8")), "1")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", lang("fr-CH, fr;q=0.9")), "2")
+	expectBody(t, performRequestWithHeader(r, "GET", "/page", lang("fr")), "2")
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+}
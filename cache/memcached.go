@@ -1,76 +1,215 @@
 package cache
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"github.com/bradfitz/gomemcache/memcache"
+	"io"
 	"time"
 )
 
+const (
+	// memcachedMaxKey is the longest key accepted by memcached.
+	memcachedMaxKey = 250
+	// memcachedMaxValue is the default item size limit of memcached.
+	memcachedMaxValue = 1024 * 1024
+	// memcachedMaxRelative is the longest expiration memcached takes as a
+	// number of seconds rather than a unix timestamp.
+	memcachedMaxRelative = 30 * 24 * time.Hour
+)
+
+// MemcachedStore is a CacheStore backed by memcached. Values are serialized
+// with gob unless another Codec is given, keys that memcached would reject are replaced by their hex encoded
+// sha1, and values bigger than 1MB aren't stored.
 type MemcachedStore struct {
 	*memcache.Client
 	defaultExpiration time.Duration
+	codec             Codec
 }
 
 func NewMemcachedStore(hostList []string, defaultExpiration time.Duration) *MemcachedStore {
-	return &MemcachedStore{memcache.New(hostList...), defaultExpiration}
+	return NewMemcachedStoreWithCodec(hostList, defaultExpiration, GobCodec{})
+}
+
+// NewMemcachedStoreWithCodec is like NewMemcachedStore, encoding the values
+// with codec instead of gob.
+func NewMemcachedStoreWithCodec(hostList []string, defaultExpiration time.Duration, codec Codec) *MemcachedStore {
+	return &MemcachedStore{memcache.New(hostList...), defaultExpiration, codec}
 }
 
 func (c *MemcachedStore) Set(key string, value interface{}, expires time.Duration) error {
-	return c.invoke((*memcache.Client).Set, key, value, expires)
+	return c.SetContext(context.Background(), key, value, expires)
+}
+
+// SetContext is like Set, bounded by ctx as described by memcachedCall.
+func (c *MemcachedStore) SetContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	return c.invoke(ctx, (*memcache.Client).Set, key, value, expires)
 }
 
 func (c *MemcachedStore) Add(key string, value interface{}, expires time.Duration) error {
-	return c.invoke((*memcache.Client).Add, key, value, expires)
+	return c.AddContext(context.Background(), key, value, expires)
+}
+
+// AddContext is like Add, bounded by ctx.
+func (c *MemcachedStore) AddContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	return c.invoke(ctx, (*memcache.Client).Add, key, value, expires)
 }
 
 func (c *MemcachedStore) Replace(key string, value interface{}, expires time.Duration) error {
-	return c.invoke((*memcache.Client).Replace, key, value, expires)
+	return c.ReplaceContext(context.Background(), key, value, expires)
+}
+
+// ReplaceContext is like Replace, bounded by ctx.
+func (c *MemcachedStore) ReplaceContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	return c.invoke(ctx, (*memcache.Client).Replace, key, value, expires)
 }
 
 func (c *MemcachedStore) Get(key string, value interface{}) error {
-	item, err := c.Client.Get(key)
+	return c.GetContext(context.Background(), key, value)
+}
+
+// GetContext is like Get, bounded by ctx.
+func (c *MemcachedStore) GetContext(ctx context.Context, key string, value interface{}) error {
+	var item *memcache.Item
+	err := memcachedCall(ctx, func() (err error) {
+		item, err = c.Client.Get(memcachedKey(key))
+		return err
+	})
 	if err != nil {
 		return convertMemcacheError(err)
 	}
-	return deserialize(item.Value, value)
+	return c.codec.Unmarshal(item.Value, value)
 }
 
 func (c *MemcachedStore) Delete(key string) error {
-	return convertMemcacheError(c.Client.Delete(key))
+	return c.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is like Delete, bounded by ctx.
+func (c *MemcachedStore) DeleteContext(ctx context.Context, key string) error {
+	return convertMemcacheError(memcachedCall(ctx, func() error {
+		return c.Client.Delete(memcachedKey(key))
+	}))
 }
 
 func (c *MemcachedStore) Increment(key string, delta uint64) (uint64, error) {
-	newValue, err := c.Client.Increment(key, delta)
-	return newValue, convertMemcacheError(err)
+	return c.IncrementContext(context.Background(), key, delta)
+}
+
+// IncrementContext is like Increment, bounded by ctx.
+func (c *MemcachedStore) IncrementContext(ctx context.Context, key string, delta uint64) (uint64, error) {
+	var newValue uint64
+	err := memcachedCall(ctx, func() (err error) {
+		newValue, err = c.Client.Increment(memcachedKey(key), delta)
+		return err
+	})
+	if err != nil {
+		return 0, convertMemcacheError(err)
+	}
+	return newValue, nil
 }
 
 func (c *MemcachedStore) Decrement(key string, delta uint64) (uint64, error) {
-	newValue, err := c.Client.Decrement(key, delta)
-	return newValue, convertMemcacheError(err)
+	return c.DecrementContext(context.Background(), key, delta)
+}
+
+// DecrementContext is like Decrement, bounded by ctx.
+func (c *MemcachedStore) DecrementContext(ctx context.Context, key string, delta uint64) (uint64, error) {
+	var newValue uint64
+	err := memcachedCall(ctx, func() (err error) {
+		newValue, err = c.Client.Decrement(memcachedKey(key), delta)
+		return err
+	})
+	if err != nil {
+		return 0, convertMemcacheError(err)
+	}
+	return newValue, nil
 }
 
 func (c *MemcachedStore) Flush() error {
 	return ErrNotSupport
 }
 
-func (c *MemcachedStore) invoke(storeFn func(*memcache.Client, *memcache.Item) error,
+// FlushContext returns ErrNotSupport, as Flush.
+func (c *MemcachedStore) FlushContext(ctx context.Context) error {
+	return ErrNotSupport
+}
+
+// memcachedCall runs op, which can't be interrupted, in a goroutine when ctx
+// can be done, returning with the error of ctx as soon as it is. An abandoned
+// call still holds its connection until it completes, within the Timeout of
+// the client.
+func memcachedCall(ctx context.Context, op func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		return op()
+	}
+	done := make(chan error, 1)
+	go func() { done <- op() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *MemcachedStore) invoke(ctx context.Context, storeFn func(*memcache.Client, *memcache.Item) error,
 	key string, value interface{}, expire time.Duration) error {
 
-	switch expire {
-	case DEFAULT:
+	if expire == DEFAULT {
 		expire = c.defaultExpiration
-	case FOREVER:
-		expire = time.Duration(0)
 	}
 
-	b, err := serialize(value)
+	b, err := c.codec.Marshal(value)
 	if err != nil {
 		return err
 	}
-	return convertMemcacheError(storeFn(c.Client, &memcache.Item{
-		Key:        key,
+	if len(b) > memcachedMaxValue {
+		return ErrNotStored
+	}
+	item := &memcache.Item{
+		Key:        memcachedKey(key),
 		Value:      b,
-		Expiration: int32(expire / time.Second),
-	}))
+		Expiration: memcachedExpiration(expire),
+	}
+	return convertMemcacheError(memcachedCall(ctx, func() error { return storeFn(c.Client, item) }))
+}
+
+// memcachedExpiration converts expire to the memcached item expiration: 0
+// never expires, and values over 30 days are unix timestamps.
+func memcachedExpiration(expire time.Duration) int32 {
+	if expire <= 0 {
+		return 0
+	}
+	if expire > memcachedMaxRelative {
+		return int32(time.Now().Add(expire).Unix())
+	}
+	// Round up, as 0 would never expire.
+	return int32((expire + time.Second - 1) / time.Second)
+}
+
+// memcachedKey returns key if memcached accepts it, and its sha1 otherwise:
+// keys are limited to 250 bytes without spaces or control characters.
+func memcachedKey(key string) string {
+	if len(key) <= memcachedMaxKey && legalMemcachedKey(key) {
+		return key
+	}
+	h := sha1.New()
+	io.WriteString(h, key)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func legalMemcachedKey(key string) bool {
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
 }
 
 func convertMemcacheError(err error) error {
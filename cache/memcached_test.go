@@ -1,12 +1,18 @@
+//go:build memcached
+// +build memcached
+
 package cache
 
 import (
+	"context"
 	"net"
+	"strings"
 	"testing"
 	"time"
 )
 
 // These tests require memcached running on localhost:11211 (the default)
+// and are only built with the memcached tag: go test -tags memcached
 const testServer = "localhost:11211"
 
 var newMemcachedStore = func(t *testing.T, defaultExpiration time.Duration) CacheStore {
@@ -33,6 +39,10 @@ func TestMemcachedCache_Expiration(t *testing.T) {
 	expiration(t, newMemcachedStore)
 }
 
+func TestMemcachedCache_ExpirationConstants(t *testing.T) {
+	expirationConstants(t, newMemcachedStore)
+}
+
 func TestMemcachedCache_EmptyCache(t *testing.T) {
 	emptyCache(t, newMemcachedStore)
 }
@@ -44,3 +54,68 @@ func TestMemcachedCache_Replace(t *testing.T) {
 func TestMemcachedCache_Add(t *testing.T) {
 	testAdd(t, newMemcachedStore)
 }
+
+func TestMemcachedCache_LongKey(t *testing.T) {
+	store := newMemcachedStore(t, time.Hour)
+	key := strings.Repeat("k", 300)
+	if err := store.Set(key, "value", DEFAULT); err != nil {
+		t.Fatalf("Error setting a long key: %s", err)
+	}
+	var value string
+	if err := store.Get(key, &value); err != nil || value != "value" {
+		t.Errorf("Expected to get the long key back, got %q, %v", value, err)
+	}
+	if err := store.Delete(key); err != nil {
+		t.Errorf("Error deleting a long key: %s", err)
+	}
+}
+
+func TestMemcachedCache_KeyWithSpaces(t *testing.T) {
+	store := newMemcachedStore(t, time.Hour)
+	if err := store.Set("a key", "value", DEFAULT); err != nil {
+		t.Fatalf("Error setting a key with spaces: %s", err)
+	}
+	var value string
+	if err := store.Get("a key", &value); err != nil || value != "value" {
+		t.Errorf("Expected to get the key back, got %q, %v", value, err)
+	}
+}
+
+func TestMemcachedCache_LargeValue(t *testing.T) {
+	store := newMemcachedStore(t, time.Hour)
+	if err := store.Set("large", make([]byte, memcachedMaxValue+1), DEFAULT); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored for a value over 1MB, got: %v", err)
+	}
+}
+
+func TestMemcachedExpiration(t *testing.T) {
+	if got := memcachedExpiration(FOREVER); got != 0 {
+		t.Errorf("Expected FOREVER to never expire, got %d", got)
+	}
+	if got := memcachedExpiration(500 * time.Millisecond); got != 1 {
+		t.Errorf("Expected expirations under a second to be rounded up, got %d", got)
+	}
+	if got := memcachedExpiration(60 * 24 * time.Hour); int64(got) < time.Now().Unix() {
+		t.Errorf("Expected expirations over 30 days to be unix timestamps, got %d", got)
+	}
+}
+
+func TestMemcachedCache_HungServer(t *testing.T) {
+	store := NewMemcachedStore([]string{silentServer(t)}, time.Hour)
+	store.Client.Timeout = time.Minute
+	if withContext(store) != ContextCacheStore(store) {
+		t.Errorf("Expected MemcachedStore to be context aware")
+	}
+
+	var value string
+	expectDeadline(t, "GetContext", func(ctx context.Context) error {
+		return store.GetContext(ctx, "key", &value)
+	})
+	expectDeadline(t, "SetContext", func(ctx context.Context) error {
+		return store.SetContext(ctx, "key", "value", DEFAULT)
+	})
+	expectDeadline(t, "IncrementContext", func(ctx context.Context) error {
+		_, err := store.IncrementContext(ctx, "key", 1)
+		return err
+	})
+}
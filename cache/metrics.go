@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Metrics receives the events of the page cache middlewares, e.g. to export
+// them to a monitoring system. Its methods are called on the request path and
+// should return quickly.
+type Metrics interface {
+	// Hit is called when a request is served from the store.
+	Hit(key string)
+	// Miss is called when a request runs the handler because its page isn't
+	// in the store, or the client asked not to read it.
+	Miss(key string)
+	// Store is called when a response is stored.
+	Store(key string)
+	// Error is called when a store operation fails. op is "get" or "set".
+	Error(op string, err error)
+	// Coalesced is called when a request waited for another one to
+	// regenerate its page, see Options.SingleFlight, and is served the
+	// result. Such requests are counted as hits as well.
+	Coalesced(key string, waited time.Duration)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Hit(key string)                             {}
+func (noopMetrics) Miss(key string)                            {}
+func (noopMetrics) Store(key string)                           {}
+func (noopMetrics) Error(op string, err error)                 {}
+func (noopMetrics) Coalesced(key string, waited time.Duration) {}
+
+// CounterMetrics is a Metrics counting the events with atomic counters. Its
+// zero value is ready to use and it is safe for concurrent use.
+type CounterMetrics struct {
+	hits      uint64
+	misses    uint64
+	stores    uint64
+	errors    uint64
+	coalesced uint64
+	waited    int64
+}
+
+func (m *CounterMetrics) Hit(key string) {
+	atomic.AddUint64(&m.hits, 1)
+}
+
+func (m *CounterMetrics) Miss(key string) {
+	atomic.AddUint64(&m.misses, 1)
+}
+
+func (m *CounterMetrics) Store(key string) {
+	atomic.AddUint64(&m.stores, 1)
+}
+
+func (m *CounterMetrics) Error(op string, err error) {
+	atomic.AddUint64(&m.errors, 1)
+}
+
+func (m *CounterMetrics) Coalesced(key string, waited time.Duration) {
+	atomic.AddUint64(&m.coalesced, 1)
+	atomic.AddInt64(&m.waited, int64(waited))
+}
+
+// Hits returns the number of requests served from the store.
+func (m *CounterMetrics) Hits() uint64 {
+	return atomic.LoadUint64(&m.hits)
+}
+
+// Misses returns the number of requests that ran the handler.
+func (m *CounterMetrics) Misses() uint64 {
+	return atomic.LoadUint64(&m.misses)
+}
+
+// Stores returns the number of responses stored.
+func (m *CounterMetrics) Stores() uint64 {
+	return atomic.LoadUint64(&m.stores)
+}
+
+// Errors returns the number of failed store operations.
+func (m *CounterMetrics) Errors() uint64 {
+	return atomic.LoadUint64(&m.errors)
+}
+
+// CoalescedRequests returns the number of requests served the page
+// regenerated by another one.
+func (m *CounterMetrics) CoalescedRequests() uint64 {
+	return atomic.LoadUint64(&m.coalesced)
+}
+
+// Waited returns the total time coalesced requests waited.
+func (m *CounterMetrics) Waited() time.Duration {
+	return time.Duration(atomic.LoadInt64(&m.waited))
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newMetricsRouter(store CacheStore, metrics Metrics) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(store, time.Minute, Options{Metrics: metrics}, func(c *gin.Context) {
+		c.String(http.StatusOK, "body")
+	}))
+	return r
+}
+
+func TestCounterMetrics(t *testing.T) {
+	metrics := &CounterMetrics{}
+	r := newMetricsRouter(NewInMemoryStore(time.Minute), metrics)
+	performRequest(r, "GET", "/page")
+	performRequest(r, "GET", "/page")
+
+	if metrics.Hits() != 1 || metrics.Misses() != 1 || metrics.Stores() != 1 || metrics.Errors() != 0 {
+		t.Errorf("Expected 1 hit, 1 miss, 1 store and no error, got %d, %d, %d, %d",
+			metrics.Hits(), metrics.Misses(), metrics.Stores(), metrics.Errors())
+	}
+}
+
+func TestCounterMetrics_Errors(t *testing.T) {
+	metrics := &CounterMetrics{}
+	r := newMetricsRouter(failingStore{}, metrics)
+	performRequest(r, "GET", "/page")
+
+	// One failed Get and one failed Set.
+	if metrics.Errors() != 2 || metrics.Misses() != 1 || metrics.Stores() != 0 {
+		t.Errorf("Expected 2 errors, 1 miss and no store, got %d, %d, %d",
+			metrics.Errors(), metrics.Misses(), metrics.Stores())
+	}
+}
+
+func TestCachePage_ObserveOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	metrics := &CounterMetrics{}
+	var errs []error
+	options := Options{
+		ObserveOnly: true,
+		Metrics:     metrics,
+		OnError:     func(err error) { errs = append(errs, err) },
+	}
+	calls := 0
+	r := gin.New()
+	// failingStore fails every call, so any use of it would be reported.
+	r.GET("/:status", CachePageWithOptions(failingStore{}, time.Minute, options, func(c *gin.Context) {
+		calls++
+		// Tagging doesn't reach the store either.
+		c.Set(CACHE_TAGS_KEY, []string{"pages"})
+		if c.Param("status") == "error" {
+			c.String(http.StatusInternalServerError, "error")
+		} else {
+			c.String(http.StatusOK, "body")
+		}
+	}))
+
+	for _, path := range []string{"/ok", "/ok", "/error"} {
+		performRequest(r, "GET", path)
+	}
+	if len(errs) != 0 {
+		t.Errorf("Expected the store not to be used, got %v", errs)
+	}
+	if calls != 3 {
+		t.Errorf("Expected every request to run the handler, ran %d times", calls)
+	}
+	if metrics.Misses() != 3 || metrics.Stores() != 2 || metrics.Hits() != 0 {
+		t.Errorf("Expected 3 misses and 2 would-be stores, got %d misses, %d stores, %d hits", metrics.Misses(), metrics.Stores(), metrics.Hits())
+	}
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// NamespaceStore prepends a namespace to every key before passing it on to
+// the wrapped store, so several applications can share a store without
+// their keys colliding.
+type NamespaceStore struct {
+	store     CacheStore
+	namespace string
+}
+
+// NewNamespaceStore returns a store keeping its entries in store under
+// namespace, e.g. "app1:".
+func NewNamespaceStore(store CacheStore, namespace string) *NamespaceStore {
+	return &NamespaceStore{store, namespace}
+}
+
+func (s *NamespaceStore) Get(key string, value interface{}) error {
+	return s.store.Get(s.namespace+key, value)
+}
+
+func (s *NamespaceStore) Set(key string, value interface{}, expires time.Duration) error {
+	return s.store.Set(s.namespace+key, value, expires)
+}
+
+func (s *NamespaceStore) Add(key string, value interface{}, expires time.Duration) error {
+	return s.store.Add(s.namespace+key, value, expires)
+}
+
+func (s *NamespaceStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.store.Replace(s.namespace+key, value, expires)
+}
+
+func (s *NamespaceStore) Delete(key string) error {
+	return s.store.Delete(s.namespace + key)
+}
+
+func (s *NamespaceStore) Increment(key string, n uint64) (uint64, error) {
+	return s.store.Increment(s.namespace+key, n)
+}
+
+func (s *NamespaceStore) Decrement(key string, n uint64) (uint64, error) {
+	return s.store.Decrement(s.namespace+key, n)
+}
+
+// GetContext is like Get, passing ctx on to the wrapped store, which is only
+// bounded by it if it implements ContextCacheStore.
+func (s *NamespaceStore) GetContext(ctx context.Context, key string, value interface{}) error {
+	return withContext(s.store).GetContext(ctx, s.namespace+key, value)
+}
+
+// SetContext is like Set, passing ctx on as GetContext does.
+func (s *NamespaceStore) SetContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	return withContext(s.store).SetContext(ctx, s.namespace+key, value, expires)
+}
+
+// AddContext is like Add, passing ctx on as GetContext does.
+func (s *NamespaceStore) AddContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	return withContext(s.store).AddContext(ctx, s.namespace+key, value, expires)
+}
+
+// ReplaceContext is like Replace, passing ctx on as GetContext does.
+func (s *NamespaceStore) ReplaceContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	return withContext(s.store).ReplaceContext(ctx, s.namespace+key, value, expires)
+}
+
+// DeleteContext is like Delete, passing ctx on as GetContext does.
+func (s *NamespaceStore) DeleteContext(ctx context.Context, key string) error {
+	return withContext(s.store).DeleteContext(ctx, s.namespace+key)
+}
+
+// IncrementContext is like Increment, passing ctx on as GetContext does.
+func (s *NamespaceStore) IncrementContext(ctx context.Context, key string, n uint64) (uint64, error) {
+	return withContext(s.store).IncrementContext(ctx, s.namespace+key, n)
+}
+
+// DecrementContext is like Decrement, passing ctx on as GetContext does.
+func (s *NamespaceStore) DecrementContext(ctx context.Context, key string, n uint64) (uint64, error) {
+	return withContext(s.store).DecrementContext(ctx, s.namespace+key, n)
+}
+
+// TTL returns the time left before key expires, or ErrNotSupport if the
+// wrapped store can't tell.
+func (s *NamespaceStore) TTL(key string) (time.Duration, error) {
+	ttl, ok := ttlStore(s.store)
+	if !ok {
+		return 0, ErrNotSupport
+	}
+	return ttl.TTL(s.namespace + key)
+}
+
+// Flush only removes the keys of the namespace. It requires the wrapped store
+// to implement PrefixFlusher and returns ErrNotSupport otherwise, rather than
+// flushing the other namespaces.
+func (s *NamespaceStore) Flush() error {
+	return s.FlushPrefix("")
+}
+
+// FlushContext is like Flush, which isn't started once ctx is done.
+func (s *NamespaceStore) FlushContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Flush()
+}
+
+// FlushPrefix removes all the keys of the namespace starting with prefix.
+func (s *NamespaceStore) FlushPrefix(prefix string) error {
+	flusher, ok := s.store.(PrefixFlusher)
+	if !ok {
+		return ErrNotSupport
+	}
+	return flusher.FlushPrefix(s.namespace + prefix)
+}
+
+// Tag adds key to the keys of the namespace tagged with tag. It requires the
+// wrapped store to implement TagStore and returns ErrNotSupport otherwise.
+func (s *NamespaceStore) Tag(tag string, key string, expires time.Duration) error {
+	tagger, ok := s.store.(TagStore)
+	if !ok {
+		return ErrNotSupport
+	}
+	return tagger.Tag(s.namespace+tag, s.namespace+key, expires)
+}
+
+// PopTag removes the keys of the namespace tagged with tag and returns them.
+func (s *NamespaceStore) PopTag(tag string) ([]string, error) {
+	tagger, ok := s.store.(TagStore)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	keys, err := tagger.PopTag(s.namespace + tag)
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, s.namespace)
+	}
+	return keys, err
+}
+
+// Keys returns the keys of the namespace starting with prefix, without the
+// namespace. It requires the wrapped store to implement KeyLister and returns
+// ErrNotSupport otherwise.
+func (s *NamespaceStore) Keys(prefix string) ([]string, error) {
+	lister, ok := s.store.(KeyLister)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	keys, err := lister.Keys(s.namespace + prefix)
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, s.namespace)
+	}
+	return keys, err
+}
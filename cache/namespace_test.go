@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var newNamespaceStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewNamespaceStore(NewInMemoryStore(defaultExpiration), "app:")
+}
+
+func TestNamespaceStore_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newNamespaceStore)
+}
+
+func TestNamespaceStore_IncrDecr(t *testing.T) {
+	incrDecr(t, newNamespaceStore)
+}
+
+func TestNamespaceStore_EmptyCache(t *testing.T) {
+	emptyCache(t, newNamespaceStore)
+}
+
+func TestNamespaceStore_Replace(t *testing.T) {
+	testReplace(t, newNamespaceStore)
+}
+
+func TestNamespaceStore_Add(t *testing.T) {
+	testAdd(t, newNamespaceStore)
+}
+
+func TestNamespaceStore_TTL(t *testing.T) {
+	testTTL(t, newNamespaceStore)
+}
+
+func TestNamespaceStore_Prefix(t *testing.T) {
+	backend := NewInMemoryStore(time.Hour)
+	app1 := NewNamespaceStore(backend, "app1:")
+	app2 := NewNamespaceStore(backend, "app2:")
+
+	app1.Set("key", "one", DEFAULT)
+	app2.Set("key", "two", DEFAULT)
+	app1.Set("counter", 1, DEFAULT)
+	app1.Increment("counter", 1)
+
+	var value string
+	if err := backend.Get("app1:key", &value); err != nil || value != "one" {
+		t.Errorf("Expected the key to be stored under the namespace, got %q: %v", value, err)
+	}
+	if err := app2.Get("key", &value); err != nil || value != "two" {
+		t.Errorf("Expected the namespaces not to collide, got %q: %v", value, err)
+	}
+	var i int
+	if err := backend.Get("app1:counter", &i); err != nil || i != 2 {
+		t.Errorf("Expected the counter to be incremented under the namespace, got %d: %v", i, err)
+	}
+
+	if err := app1.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	if err := app1.Get("key", &value); err != ErrCacheMiss {
+		t.Errorf("Expected the namespace to be flushed, got: %v", err)
+	}
+	if err := app2.Get("key", &value); err != nil {
+		t.Errorf("Expected the other namespace to be kept, got: %v", err)
+	}
+	if err := app2.Delete("key"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	if err := backend.Get("app2:key", &value); err != ErrCacheMiss {
+		t.Errorf("Expected the key to be deleted under the namespace, got: %v", err)
+	}
+
+	if err := NewNamespaceStore(NewNoOpStore(), "app:").Flush(); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport flushing a store without prefix flush, got: %v", err)
+	}
+}
+
+func TestNamespaceStore_Context(t *testing.T) {
+	s := withContext(NewNamespaceStore(blockingStore{contextStore{NewInMemoryStore(time.Minute)}}, "app:"))
+	expectDeadline(t, "GetContext", func(ctx context.Context) error {
+		var value string
+		return s.GetContext(ctx, "key", &value)
+	})
+	expectDeadline(t, "SetContext", func(ctx context.Context) error {
+		return s.SetContext(ctx, "key", "value", DEFAULT)
+	})
+}
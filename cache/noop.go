@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"time"
+)
+
+// NoOpStore is a CacheStore that stores nothing, to disable caching without
+// changing the middlewares wiring: every lookup is a miss and every write
+// succeeds without effect.
+type NoOpStore struct{}
+
+// NewNoOpStore returns a store that stores nothing.
+func NewNoOpStore() *NoOpStore {
+	return &NoOpStore{}
+}
+
+func (*NoOpStore) Get(key string, value interface{}) error {
+	return ErrCacheMiss
+}
+
+func (*NoOpStore) Set(key string, value interface{}, expires time.Duration) error {
+	return nil
+}
+
+func (*NoOpStore) Add(key string, value interface{}, expires time.Duration) error {
+	return nil
+}
+
+func (*NoOpStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return nil
+}
+
+func (*NoOpStore) Delete(key string) error {
+	return ErrCacheMiss
+}
+
+func (*NoOpStore) Increment(key string, delta uint64) (uint64, error) {
+	return 0, ErrNotSupport
+}
+
+func (*NoOpStore) Decrement(key string, delta uint64) (uint64, error) {
+	return 0, ErrNotSupport
+}
+
+func (*NoOpStore) Flush() error {
+	return nil
+}
@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNoOpStore(t *testing.T) {
+	store := NewNoOpStore()
+	if err := store.Set("key", "value", DEFAULT); err != nil {
+		t.Errorf("Unexpected error setting: %s", err)
+	}
+	var value string
+	if err := store.Get("key", &value); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss, got: %v", err)
+	}
+	if _, err := store.Increment("key", 1); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport incrementing, got: %v", err)
+	}
+}
+
+func TestNoOpStore_AlwaysMiss(t *testing.T) {
+	store := NewNoOpStore()
+	r := newCountingRouter(store, Options{SetXCacheHeader: true})
+	cached := gin.New()
+	count := 0
+	cached.Use(Cache(store))
+	cached.GET("/page", Cached(time.Minute), func(c *gin.Context) {
+		count++
+		c.String(200, "%d", count)
+	})
+
+	for i, body := range []string{"1", "2", "3"} {
+		w := performRequest(r, "GET", "/page")
+		expectBody(t, w, body)
+		if w.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("Expected request %d to be a MISS, got %q", i, w.Header().Get("X-Cache"))
+		}
+		expectBody(t, performRequest(cached, "GET", "/page"), body)
+	}
+}
@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"hash"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Options is a struct for specifying configuration options for the page cache middlewares.
+type Options struct {
+	// Skip reports whether a request bypasses the cache, e.g. `func(c *gin.Context) bool { return c.GetHeader("Authorization") != "" }` to only cache anonymous requests. Skipped requests neither read nor write the cache. Default is to skip none.
+	Skip func(c *gin.Context) bool
+	// If SkipQueryStrings is true, requests with a query string bypass the cache, so only clean urls are cached. With NormalizeQuery, queries made only of parameters in IgnoreQueryParams don't count. Default is false.
+	SkipQueryStrings bool
+	// CacheableStatus reports whether a response with the given status code may be stored. Default is to only store 200 responses.
+	CacheableStatus func(status int) bool
+	// CacheableContentType reports whether a response may be stored given its media type, the lower cased Content-Type header without parameters such as charset, or an empty string when the response has none. Default is to store responses of any type.
+	CacheableContentType func(mediaType string) bool
+	// NegativeExpire is the expiration used to cache responses in NegativeStatus that aren't cacheable otherwise, typically shorter than the page expiration. Default is 0, which doesn't cache them.
+	NegativeExpire time.Duration
+	// NegativeStatus lists the status codes cached with NegativeExpire. Default is 404 and 410.
+	NegativeStatus []int
+	// RouteErrorExpire is the expiration of the 404 and 405 responses of the requests matching no route, as rendered by the NoRoute and NoMethod handlers or gin's default messages, apart from the errors returned by the handlers, which go by NegativeExpire. If the store implements TagStore, they are tagged with ROUTE_ERROR_TAG, to invalidate them when the routes change. Cached only, as CachePage only runs on its route. Default is 0, which handles them as the responses of the handlers.
+	RouteErrorExpire time.Duration
+	// TTLByStatus maps status codes to the expiration of their responses, e.g. 10 minutes for 200, 24 hours for 301 and 30 seconds for 404. Mapped statuses are stored whether or not they are in CacheableStatus, unless mapped to 0, which never stores them. Other statuses go by CacheableStatus and NegativeExpire. The Cache-Control header of the response and CACHE_TTL_KEY still take precedence. Default is none.
+	TTLByStatus map[int]time.Duration
+	// ExpireJitter randomly spreads the expiration of each stored page by up to this fraction of it, in either direction, so pages stored together don't expire together, e.g. 0.1 for ±10%. Default is 0, which disables it.
+	ExpireJitter float64
+	// ExpireJitterMax caps the spread of ExpireJitter. When ExpireJitter is 0, pages are spread by up to ExpireJitterMax. Default is 0, which disables it.
+	ExpireJitterMax time.Duration
+	// If IgnoreRequestCacheControl is true, the Cache-Control and Pragma request headers are ignored. Otherwise `no-store` bypasses the cache entirely and `no-cache` skips reading the cached copy while still refreshing it. Default is false.
+	IgnoreRequestCacheControl bool
+	// If SetAgeHeader is true, responses served from the cache carry an `Age` header with the number of seconds since they were stored. Default is false.
+	SetAgeHeader bool
+	// If SetMaxAgeHeader is true, responses served from the cache get a `Cache-Control: max-age` header with the number of seconds they stay in the cache, replacing the stored one. It requires the store to implement TTLStore, unless StaleWhileRevalidate is set. Default is false.
+	SetMaxAgeHeader bool
+	// If SetXCacheHeader is true, responses carry an `X-Cache` header set to `HIT` when served from the cache and `MISS` otherwise. Default is false.
+	SetXCacheHeader bool
+	// If ETag is true, an `ETag` is computed from the body of stored responses that don't set one, and requests with a matching `If-None-Match` get a `304 Not Modified` from the cache. Default is false.
+	ETag bool
+	// If LastModified is true, stored responses that don't set a `Last-Modified` header get one holding the time they were stored, so it is sent along with the page served from the cache. Requests whose `If-Modified-Since` isn't older than the `Last-Modified` of the page, whether set by the handler or not, get a `304 Not Modified` from the cache. `If-None-Match` takes precedence. Default is false.
+	LastModified bool
+	// Methods lists the request methods whose responses are cached. Requests with other methods go straight to the handler. Default is GET and HEAD.
+	Methods []string
+	// If SeparateHead is true, HEAD requests have their own entries. Otherwise they are served the status and headers of the cached GET response, with its Content-Length and without its body, and HEAD responses aren't stored. Default is false.
+	SeparateHead bool
+	// Prefix is prepended to the keys of the stored pages, e.g. to namespace them by API version or tenant in a shared store. Default is the value of PageCachePrefix when the middleware is created.
+	Prefix string
+	// If KeyHost is true, the default key starts with the request Host, so the virtual hosts served by the same router don't share pages. Invalidated urls must then be absolute. Default is false.
+	KeyHost bool
+	// If KeyScheme is true along with KeyHost, the key also starts with the request scheme, taken from `X-Forwarded-Proto` when set. Default is false.
+	KeyScheme bool
+	// KeyCookies lists the cookies whose values are part of the key, e.g. a locale or theme cookie, so the page is cached once per combination of values while the other cookies are ignored. A missing cookie counts as an empty value. Pages keyed by cookies aren't removed by InvalidateURL, use InvalidatePrefix instead. Default is none.
+	KeyCookies []string
+	// KeyLanguages lists the languages the site is localized in, e.g. `en` and `fr`, in order of preference. The language negotiated from the request `Accept-Language` header is then part of the key, so requests negotiating the same language share a page however their header is written. Requests matching none of them get the first one. Pages keyed by language aren't removed by InvalidateURL, use InvalidatePrefix instead. Default is none.
+	KeyLanguages []string
+	// KeyContext lists the gin context keys whose values, set by earlier middlewares, are part of the key, e.g. the role of the authenticated user, so role-gated pages are cached once per role rather than per user. Values are formatted with fmt.Sprint, and a missing value has a placeholder of its own. Pages keyed by context values aren't removed by InvalidateURL, use InvalidatePrefix instead. Default is none.
+	KeyContext []string
+	// MaxKeyLength is the longest key stored, prefix included. The url of longer keys is replaced by its sha1, e.g. to fit the 250 bytes limit of memcached. Default is 200.
+	MaxKeyLength int
+	// HashedKeyHint is the number of bytes of the escaped url kept before the sha1 of hashed keys, to tell them apart when inspecting the store. Default is 0.
+	HashedKeyHint int
+	// HashFunc hashes the url of the keys longer than MaxKeyLength, and the bodies of the ETag option, e.g. sha256.New where sha1 isn't allowed. The hashed keys are tagged with the size of the hash, e.g. "h256-", so changing it changes them: the pages stored under the previous keys are missed until they expire. Default is nil, which hashes with sha1, without a tag, as the previous versions.
+	HashFunc func() hash.Hash
+	// KeyFunc derives the cache key of a request, e.g. to segment the cache by user or tenant. The result is escaped and hashed when too long, so it may be any string. Default is the request URI.
+	KeyFunc func(c *gin.Context) string
+	// If NormalizeQuery is true, query parameters are sorted by name before building the default key, so reordered queries share an entry. Repeated parameters keep their relative order. Default is false.
+	NormalizeQuery bool
+	// IgnoreQueryParams lists query parameters dropped from the default key when NormalizeQuery is set. A trailing `*` matches any parameter with that prefix, e.g. `utm_*`. Default is empty list.
+	IgnoreQueryParams []string
+	// If ObserveOnly is true, the store is never read nor written: every request runs the handler, while the keys are computed and the responses go through the storing rules as usual, with the misses and the responses that would be stored reported to Metrics. This tells the effect of the options, e.g. the key cardinality, before enabling the cache. Default is false.
+	ObserveOnly bool
+	// OnError is called whenever a store operation fails, except for cache misses, and with ErrBodyTooLarge when a response exceeds MaxBodyBytes. Errors are passed as a *CacheError holding the key and operation, to be matched with errors.Is. The request is still served from the handler. Default is to ignore errors.
+	OnError func(err error)
+	// OnStoreError is the policy applied when a lookup fails for another reason than a miss: FailOpen, FailClosed or CircuitBreaker. Default is FailOpen.
+	OnStoreError StoreErrorPolicy
+	// FailClosedHandler answers the requests failed by the FailClosed policy instead of the handlers, e.g. with a maintenance page. Default is to abort with 503 Service Unavailable.
+	FailClosedHandler gin.HandlerFunc
+	// BreakerThreshold is the number of consecutive failed lookups opening the circuit breaker. Default is 5.
+	BreakerThreshold int
+	// BreakerCooldown is how long the store isn't used once the circuit breaker is open. Default is 30 seconds.
+	BreakerCooldown time.Duration
+	// LatencyThreshold is the average store lookup time past which the cache is bypassed, every request being served by the handler without using the store, to protect the response times while the store is slow. Lookups are averaged over LatencyWindow, once it has at least 10 of them so a single slow lookup doesn't count, and the cache is bypassed for a LatencyWindow before the lookups are timed again. Default is 0, which never bypasses the cache.
+	LatencyThreshold time.Duration
+	// LatencyWindow is the period over which the lookups are averaged, and for which the cache is bypassed, when LatencyThreshold is set. Default is 10 seconds.
+	LatencyWindow time.Duration
+	// Metrics receives the hits, misses, stores and store errors of the middleware. Default is to discard them.
+	Metrics Metrics
+	// If SingleFlight is true, concurrent misses for the same key only run the handler once: the other requests wait for it and are served the response it cached. Each middleware instance has its own set of in flight requests. Default is false.
+	SingleFlight bool
+	// MaxNewKeys is the number of pages missing from the cache that are stored per NewKeysWindow, so a flood of new keys, such as urls with random query strings, doesn't evict the pages in use: past it, the other missing pages are served by the handler without being stored until the window ends. The pages kept for ServeStaleOnError don't count. CachePage and Cached only. See also InMemoryStore.SetAdmission. Default is 0, which stores every page.
+	MaxNewKeys int
+	// NewKeysWindow is the period MaxNewKeys applies to. Default is 1 minute.
+	NewKeysWindow time.Duration
+	// StaleWhileRevalidate is how long a page stays in the store past its expiration. Within that window it is still served while being refreshed, one refresh per key at a time. CachePage refreshes in the background, only replacing the page if it is still in the store, so a page invalidated meanwhile isn't stored again; Cached can't run the rest of the chain once the request is over, so the request that finds the page stale refreshes it while others are served the stale copy. It requires a positive expiration. Default is 0, which disables it.
+	StaleWhileRevalidate time.Duration
+	// RevalidationLockTimeout is how long the refresh of a stale page holds a lock, kept in the store, keeping the other requests of every process sharing the store from refreshing it too. Once it has expired, e.g. because the handler hangs, the next request finding the page stale takes the refresh over. The lock is an entry added with Add, so it is only as reliable as the store: it is lost if the store evicts it, refreshes outlasting the timeout may overlap, and the network stores round it to the second. Default is 0, which only keeps the requests of the middleware instance from refreshing a page at the same time, for as long as the refresh runs.
+	RevalidationLockTimeout time.Duration
+	// MustCache keeps the requests it returns true for from reaching the handler, e.g. during a planned maintenance of the origin: they are only served from the cache, including the pages kept for ServeStaleOnError, and get a 503 Service Unavailable otherwise. The request Cache-Control directives are ignored and stale pages aren't refreshed meanwhile. The requests the cache doesn't apply to, per Skip or Methods, still reach the handler. Default is nil, which lets every request through.
+	MustCache func(c *gin.Context) bool
+	// RetryAfter is sent, in seconds, as the Retry-After header of the responses refused by MustCache. Default is 0, which sends none.
+	RetryAfter time.Duration
+	// ServeStaleOnError is how long a page stays in the store past its expiration, and StaleWhileRevalidate, to be served in place of 5xx responses of the handler. The `stale-if-error` directive of the response Cache-Control header takes precedence. The responses of the requests finding such a page are buffered until the handler is done. CachePage and Cached only. It requires a positive expiration. Default is 0, which disables it.
+	ServeStaleOnError time.Duration
+	// If Compress is true, bodies of at least CompressMinSize bytes are compressed with Compressor before being stored. They are served as is to clients accepting its encoding and decompressed for the others. Default is false.
+	Compress bool
+	// Compressor compresses the bodies when Compress is set. Entries compressed with another compressor, other than the built-in ones, can't be read. Default is GzipCompressor.
+	Compressor Compressor
+	// CompressMinSize is the minimum body size compressed when Compress is set. Default is 0.
+	CompressMinSize int
+	// WriteBehind is the number of responses a CachePage or Cached middleware may store in the background once the handler returns, so clients don't wait for the store. When that many writes are pending, responses are stored before returning again. Store errors are still passed to OnError and Metrics, from the background. Default is 0, which stores every response before returning.
+	WriteBehind int
+	// TransformOnStore rewrites the body of responses before they are stored, e.g. to strip a CSRF token. The client getting the response from the handler receives it unchanged. Default is nil, which stores bodies as is.
+	TransformOnStore func(c *gin.Context, body []byte) []byte
+	// TransformOnServe rewrites the body of pages served from the cache, e.g. to add a "served from cache" banner. Compressed pages are decompressed first. It must return a new slice rather than modify body, which may be shared with the store. Default is nil, which serves bodies as is.
+	TransformOnServe func(c *gin.Context, body []byte) []byte
+	// If Buffered is true, responses are only sent to the client once the handler is done and the response is stored, instead of being streamed as they are written. The client gets the first byte later and the whole body is held in memory, up to MaxBodyBytes after which the response is streamed. Default is false.
+	Buffered bool
+	// MaxBodyBytes is the largest response body stored. Bigger responses are passed through without being buffered any further. Files served with `c.File` or `http.ServeContent` are buffered like any other body, so it should be set when serving large files. Partial responses to range requests are never stored. Default is 0, which doesn't limit the size.
+	MaxBodyBytes int
+	// If SkipEmptyBody is true, responses without a body aren't stored, so an upstream intermittently answering nothing isn't served from the cache until the page expires. 204 responses and responses to HEAD requests stored apart, see SeparateHead, are still stored. Default is false, enabling it is recommended.
+	SkipEmptyBody bool
+	// If AllowSetCookie is true, responses setting cookies are stored, without the headers in ExcludeHeaders. Otherwise they aren't stored at all, as their body often depends on the session the cookie belongs to. Default is false.
+	AllowSetCookie bool
+	// AccessControl tells whether the Access-Control-* CORS headers are stored and replayed. Default is DefaultAccessControl, which skips them in Cached only.
+	AccessControl AccessControlPolicy
+	// AppendHeaders lists the response headers whose cached values are added to the ones already set by upstream middlewares when replaying a page. Other cached headers replace them, so single valued headers such as `Content-Type` aren't duplicated. Default is `Vary`, `Link` and `Via`.
+	AppendHeaders []string
+	// ExcludeHeaders lists the response headers that are neither stored nor replayed from the cache. Default is `Set-Cookie`, `Set-Cookie2`, `Authorization` and `Proxy-Authorization`; set it to an empty list to keep every header.
+	ExcludeHeaders []string
+	// If SkipLinkHeaders is true, the `Link` response headers, e.g. the preload hints of a page, are neither stored nor replayed from the cache. Otherwise every Link value of the handler is replayed, merged with the ones of upstream middlewares per AppendHeaders. Default is false.
+	SkipLinkHeaders bool
+	// Now returns the current time, from which the pages expire, go stale and get their `Age`. Tests can set it to a fake clock shared with the store, see InMemoryStore.SetClock, to expire pages without sleeping. Default is time.Now.
+	Now func() time.Time
+}
+
+func defaultCacheableStatus(status int) bool {
+	return status == http.StatusOK
+}
+
+func ignoreError(err error) {}
+
+// applyDefaults fills in the zero fields of the options with their default values.
+func applyDefaults(options Options) Options {
+	if options.Prefix == "" {
+		options.Prefix = PageCachePrefix
+	}
+	if options.MaxKeyLength <= 0 {
+		options.MaxKeyLength = defaultMaxKeyLength
+	}
+	if options.Methods == nil {
+		options.Methods = []string{"GET", "HEAD"}
+	}
+	if options.CacheableStatus == nil {
+		options.CacheableStatus = defaultCacheableStatus
+	}
+	if options.NegativeStatus == nil {
+		options.NegativeStatus = []int{http.StatusNotFound, http.StatusGone}
+	}
+	if options.ExcludeHeaders == nil {
+		options.ExcludeHeaders = defaultExcludedHeaders
+	}
+	if options.BreakerThreshold <= 0 {
+		options.BreakerThreshold = 5
+	}
+	if options.BreakerCooldown <= 0 {
+		options.BreakerCooldown = 30 * time.Second
+	}
+	if options.NewKeysWindow <= 0 {
+		options.NewKeysWindow = time.Minute
+	}
+	if options.LatencyWindow <= 0 {
+		options.LatencyWindow = 10 * time.Second
+	}
+	if options.Metrics == nil {
+		options.Metrics = noopMetrics{}
+	}
+	if options.Compressor == nil {
+		options.Compressor = GzipCompressor{}
+	}
+	if options.AppendHeaders == nil {
+		options.AppendHeaders = defaultAppendedHeaders
+	}
+	if options.OnError == nil {
+		options.OnError = ignoreError
+	}
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+	return options
+}
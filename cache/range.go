@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rangeRequest reports whether the request asks for a part of a cached
+// response that can be served from its body: a complete, uncompressed 200
+// response to GET, still matching the If-Range condition if any.
+func rangeRequest(r *http.Request, cache *ResponseCache) bool {
+	if r.Method != "GET" || r.Header.Get("Range") == "" || cache.Status != http.StatusOK || cache.Compressed {
+		return false
+	}
+	ifRange := strings.TrimSpace(r.Header.Get("If-Range"))
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		// If-Range requires a strong comparison.
+		return strongMatch(ifRange, cache.ETag)
+	}
+	date, err := http.ParseTime(ifRange)
+	return err == nil && lastModified(cache).Equal(date)
+}
+
+// lastModified returns the Last-Modified time of a cached response, or the
+// time it was stored if it has none, to the second.
+func lastModified(cache *ResponseCache) time.Time {
+	if modified, err := http.ParseTime(cache.Header.Get("Last-Modified")); err == nil {
+		return modified
+	}
+	return cache.Timestamp.Truncate(time.Second)
+}
+
+// parseRange parses a Range header holding a single byte range of a body of
+// the given size, returning its first and last byte. ok is false when the
+// range is invalid or unsatisfiable. Headers asking for several ranges, or
+// another unit, are ignored as allowed: ignore is true and the whole body is
+// served.
+func parseRange(header string, size int) (first int, last int, ok bool, ignore bool) {
+	spec := strings.TrimSpace(header)
+	if !strings.HasPrefix(spec, "bytes=") {
+		return 0, 0, false, true
+	}
+	spec = strings.TrimSpace(strings.TrimPrefix(spec, "bytes="))
+	if strings.Contains(spec, ",") {
+		return 0, 0, false, true
+	}
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, 0, false, false
+	}
+	start, end := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if start == "" {
+		// A suffix range: the last bytes of the body.
+		n, err := strconv.Atoi(end)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true, false
+	}
+	first, err := strconv.Atoi(start)
+	if err != nil || first < 0 || first >= size {
+		return 0, 0, false, false
+	}
+	last = size - 1
+	if end != "" {
+		if last, err = strconv.Atoi(end); err != nil || last < first {
+			return 0, 0, false, false
+		}
+		if last >= size {
+			last = size - 1
+		}
+	}
+	return first, last, true, false
+}
+
+// writeRange sends the part of the cached body asked for by the Range header,
+// or a 416 if it can't be satisfied. It reports false when the header is
+// ignored and the whole response should be sent instead.
+func writeRange(w http.ResponseWriter, r *http.Request, cache *ResponseCache) bool {
+	size := len(cache.Data)
+	first, last, ok, ignore := parseRange(r.Header.Get("Range"), size)
+	if ignore {
+		return false
+	}
+	if !ok {
+		w.Header().Set("Content-Range", "bytes */"+strconv.Itoa(size))
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+	w.Header().Set("Content-Range", "bytes "+strconv.Itoa(first)+"-"+strconv.Itoa(last)+"/"+strconv.Itoa(size))
+	w.Header().Set("Content-Length", strconv.Itoa(last-first+1))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(cache.Data[first : last+1])
+	return true
+}
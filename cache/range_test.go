@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseRange(t *testing.T) {
+	for header, want := range map[string]struct {
+		first, last int
+		ok, ignore  bool
+	}{
+		"bytes=0-4":     {0, 4, true, false},
+		"bytes=5-":      {5, 9, true, false},
+		"bytes=-3":      {7, 9, true, false},
+		"bytes=-30":     {0, 9, true, false},
+		"bytes=8-20":    {8, 9, true, false},
+		"bytes=10-":     {0, 0, false, false},
+		"bytes=4-2":     {0, 0, false, false},
+		"bytes=x-2":     {0, 0, false, false},
+		"bytes=-0":      {0, 0, false, false},
+		"bytes=0-1,4-5": {0, 0, false, true},
+		"items=0-1":     {0, 0, false, true},
+	} {
+		first, last, ok, ignore := parseRange(header, 10)
+		if first != want.first || last != want.last || ok != want.ok || ignore != want.ignore {
+			t.Errorf("%q: expected %v, got %d %d %t %t", header, want, first, last, ok, ignore)
+		}
+	}
+}
+
+func newRangeRouter(store CacheStore) (*gin.Engine, *int) {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	r := gin.New()
+	r.GET("/file", CachePageWithOptions(store, time.Minute, Options{ETag: true}, func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, "0123456789")
+	}))
+	return r, &calls
+}
+
+func TestCachePage_Range(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r, calls := newRangeRouter(store)
+	performRequest(r, "GET", "/file")
+
+	w := performRequestWithHeader(r, "GET", "/file", http.Header{"Range": {"bytes=2-5"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+		t.Errorf("Expected the range of the cached body, got %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Range") != "bytes 2-5/10" || w.Header().Get("Content-Length") != "4" {
+		t.Errorf("Unexpected range headers %v", w.Header())
+	}
+
+	w = performRequestWithHeader(r, "GET", "/file", http.Header{"Range": {"bytes=20-"}})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable || w.Header().Get("Content-Range") != "bytes */10" || w.Body.Len() != 0 {
+		t.Errorf("Expected a 416 for an unsatisfiable range, got %d %q", w.Code, w.Body.String())
+	}
+	if *calls != 1 {
+		t.Errorf("Expected ranges to be served from the cache, the handler ran %d times", *calls)
+	}
+
+	var cache ResponseCache
+	store.Get(urlEscape(PageCachePrefix, "/file"), &cache)
+	if len(cache.Data) != 10 {
+		t.Errorf("Expected the full body to stay cached, got %q", cache.Data)
+	}
+}
+
+func TestCachePage_IfRange(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	r, _ := newRangeRouter(store)
+	performRequest(r, "GET", "/file")
+	var cache ResponseCache
+	store.Get(urlEscape(PageCachePrefix, "/file"), &cache)
+
+	for ifRange, partial := range map[string]bool{
+		cache.ETag:        true,
+		"W/" + cache.ETag: false,
+		`"other"`:         false,
+		cache.Timestamp.UTC().Format(http.TimeFormat):                 true,
+		cache.Timestamp.Add(-time.Hour).UTC().Format(http.TimeFormat): false,
+		"tomorrow": false,
+	} {
+		w := performRequestWithHeader(r, "GET", "/file", http.Header{"Range": {"bytes=0-1"}, "If-Range": {ifRange}})
+		if partial && (w.Code != http.StatusPartialContent || w.Body.String() != "01") {
+			t.Errorf("If-Range %q: expected the range, got %d %q", ifRange, w.Code, w.Body.String())
+		}
+		if !partial && (w.Code != http.StatusOK || w.Body.String() != "0123456789") {
+			t.Errorf("If-Range %q: expected the whole body, got %d %q", ifRange, w.Code, w.Body.String())
+		}
+	}
+}
@@ -1,16 +1,66 @@
 package cache
 
 import (
+	"context"
 	"github.com/garyburd/redigo/redis"
+	"strings"
 	"time"
 )
 
+// redisDialTimeout bounds the connection and the AUTH or PING checking it in
+// the pool of NewRedisCache, which can't tell the deadline of the request.
+const redisDialTimeout = 5 * time.Second
+
 // Wraps the Redis client to meet the Cache interface.
 type RedisStore struct {
 	pool              *redis.Pool
+	prefix            string
 	defaultExpiration time.Duration
+	codec             Codec
 }
 
+// incrScript increments an existing key atomically. Unlike a bare INCRBY it
+// never creates the key, as per the cache contract.
+var incrScript = redis.NewScript(1, `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return false
+end
+return redis.call("INCRBY", KEYS[1], ARGV[1])
+`)
+
+// decrScript decrements an existing key atomically, stopping at 0.
+var decrScript = redis.NewScript(1, `
+local current = redis.call("GET", KEYS[1])
+if not current then
+	return false
+end
+if tonumber(current) < tonumber(ARGV[1]) then
+	return redis.call("DECRBY", KEYS[1], current)
+end
+return redis.call("DECRBY", KEYS[1], ARGV[1])
+`)
+
+// tagScript adds a key to a tag set, keeping the set as long as its longest
+// lived key. A zero expiration keeps it forever.
+var tagScript = redis.NewScript(1, `
+local exists = redis.call("EXISTS", KEYS[1])
+redis.call("SADD", KEYS[1], ARGV[1])
+local expire = tonumber(ARGV[2])
+if expire == 0 then
+	redis.call("PERSIST", KEYS[1])
+elseif exists == 0 or redis.call("PTTL", KEYS[1]) >= 0 and redis.call("PTTL", KEYS[1]) < expire then
+	redis.call("PEXPIRE", KEYS[1], expire)
+end
+return true
+`)
+
+// popTagScript removes a tag set and returns its members.
+var popTagScript = redis.NewScript(1, `
+local keys = redis.call("SMEMBERS", KEYS[1])
+redis.call("DEL", KEYS[1])
+return keys
+`)
+
 // until redigo supports sharding/clustering, only one host will be in hostList
 func NewRedisCache(host string, password string, defaultExpiration time.Duration) *RedisStore {
 	var pool = &redis.Pool{
@@ -18,18 +68,18 @@ func NewRedisCache(host string, password string, defaultExpiration time.Duration
 		IdleTimeout: 240 * time.Second,
 		Dial: func() (redis.Conn, error) {
 			// the redis protocol should probably be made sett-able
-			c, err := redis.Dial("tcp", host)
+			c, err := redis.Dial("tcp", host, redis.DialConnectTimeout(redisDialTimeout))
 			if err != nil {
 				return nil, err
 			}
 			if len(password) > 0 {
-				if _, err := c.Do("AUTH", password); err != nil {
+				if _, err := redis.DoWithTimeout(c, redisDialTimeout, "AUTH", password); err != nil {
 					c.Close()
 					return nil, err
 				}
 			} else {
 				// check with PING
-				if _, err := c.Do("PING"); err != nil {
+				if _, err := redis.DoWithTimeout(c, redisDialTimeout, "PING"); err != nil {
 					c.Close()
 					return nil, err
 				}
@@ -38,7 +88,7 @@ func NewRedisCache(host string, password string, defaultExpiration time.Duration
 		},
 		// custom connection test method
 		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			if _, err := c.Do("PING"); err != nil {
+			if _, err := redis.DoWithTimeout(c, redisDialTimeout, "PING"); err != nil {
 				return err
 			}
 			return nil
@@ -49,138 +99,427 @@ func NewRedisCache(host string, password string, defaultExpiration time.Duration
 }
 
 func NewRedisCacheWithPool(pool *redis.Pool, defaultExpiration time.Duration) *RedisStore {
-	return &RedisStore{pool, defaultExpiration}
+	return NewRedisCacheWithPrefix(pool, "", defaultExpiration)
+}
+
+// NewRedisCacheWithPrefix returns a store that prepends prefix to every key,
+// so several stores can share one redis database. Flush only removes the keys
+// under prefix.
+func NewRedisCacheWithPrefix(pool *redis.Pool, prefix string, defaultExpiration time.Duration) *RedisStore {
+	return NewRedisCacheWithCodec(pool, prefix, defaultExpiration, GobCodec{})
+}
+
+// NewRedisCacheWithCodec is like NewRedisCacheWithPrefix, encoding the values
+// with codec instead of gob.
+func NewRedisCacheWithCodec(pool *redis.Pool, prefix string, defaultExpiration time.Duration, codec Codec) *RedisStore {
+	return &RedisStore{pool, prefix, defaultExpiration, codec}
+}
+
+// conn returns a connection of the pool for the operations of ctx. When ctx
+// has a deadline, waiting for the pool and each command are bounded by the
+// time left, and commands fail with the error of ctx once it has passed. A
+// context cancelled without a deadline only keeps new commands from being
+// sent.
+func (c *RedisStore) conn(ctx context.Context) (redis.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return deadlineConn{conn, ctx}, nil
+	}
+	return conn, nil
+}
+
+// deadlineConn bounds the commands sent on a connection by the deadline of
+// ctx.
+type deadlineConn struct {
+	redis.Conn
+	ctx context.Context
+}
+
+func (c deadlineConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, _ := c.ctx.Deadline()
+	reply, err := redis.DoWithTimeout(c.Conn, time.Until(deadline), cmd, args...)
+	if err != nil && !time.Now().Before(deadline) {
+		// The read deadline of the connection is the deadline of ctx, which
+		// may fire a moment before ctx is done.
+		return nil, context.DeadlineExceeded
+	}
+	return reply, err
 }
 
 func (c *RedisStore) Set(key string, value interface{}, expires time.Duration) error {
-	return c.invoke(c.pool.Get().Do, key, value, expires)
+	return c.SetContext(context.Background(), key, value, expires)
 }
 
-func (c *RedisStore) Add(key string, value interface{}, expires time.Duration) error {
-	conn := c.pool.Get()
-	if exists(conn, key) {
-		return ErrNotStored
+// SetContext is like Set, bounded by ctx as described by conn.
+func (c *RedisStore) SetContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return err
 	}
-	return c.invoke(conn.Do, key, value, expires)
+	defer conn.Close()
+	return c.invoke(conn, key, value, expires)
+}
+
+// Add stores value with SET NX, so it is atomic.
+func (c *RedisStore) Add(key string, value interface{}, expires time.Duration) error {
+	return c.AddContext(context.Background(), key, value, expires)
+}
+
+// AddContext is like Add, bounded by ctx.
+func (c *RedisStore) AddContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	return c.setIf(ctx, key, value, expires, "NX")
 }
 
+// Replace stores value with SET XX, so it is atomic.
 func (c *RedisStore) Replace(key string, value interface{}, expires time.Duration) error {
-	conn := c.pool.Get()
-	if !exists(conn, key) {
-		return ErrNotStored
-	}
-	err := c.invoke(conn.Do, key, value, expires)
+	return c.ReplaceContext(context.Background(), key, value, expires)
+}
+
+// ReplaceContext is like Replace, bounded by ctx.
+func (c *RedisStore) ReplaceContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
 	if value == nil {
 		return ErrNotStored
-	} else {
+	}
+	return c.setIf(ctx, key, value, expires, "XX")
+}
+
+// setIf stores value at key under condition, NX or XX, returning
+// ErrNotStored if the condition doesn't hold.
+func (c *RedisStore) setIf(ctx context.Context, key string, value interface{}, expires time.Duration, condition string) error {
+	b, err := c.codec.Marshal(value)
+	if err != nil {
 		return err
 	}
+	args := []interface{}{c.prefix + key, b}
+	if expires = c.expiration(expires); expires > 0 {
+		args = append(args, "PX", int64(expires/time.Millisecond))
+	}
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	reply, err := conn.Do("SET", append(args, condition)...)
+	if err == nil && reply == nil {
+		return ErrNotStored
+	}
+	return err
 }
 
 func (c *RedisStore) Get(key string, ptrValue interface{}) error {
-	conn := c.pool.Get()
+	return c.GetContext(context.Background(), key, ptrValue)
+}
+
+// GetContext is like Get, bounded by ctx.
+func (c *RedisStore) GetContext(ctx context.Context, key string, ptrValue interface{}) error {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
 	defer conn.Close()
-	raw, err := conn.Do("GET", key)
-	if raw == nil {
+	raw, err := conn.Do("GET", c.prefix+key)
+	if raw == nil && err == nil {
 		return ErrCacheMiss
 	}
 	item, err := redis.Bytes(raw, err)
 	if err != nil {
 		return err
 	}
-	return deserialize(item, ptrValue)
+	return c.codec.Unmarshal(item, ptrValue)
 }
 
-func exists(conn redis.Conn, key string) bool {
-	retval, _ := redis.Bool(conn.Do("EXISTS", key))
-	return retval
+func (c *RedisStore) Delete(key string) error {
+	return c.DeleteContext(context.Background(), key)
 }
 
-func (c *RedisStore) Delete(key string) error {
-	conn := c.pool.Get()
+// DeleteContext is like Delete, bounded by ctx.
+func (c *RedisStore) DeleteContext(ctx context.Context, key string) error {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
 	defer conn.Close()
-	if !exists(conn, key) {
+	deleted, err := redis.Int(conn.Do("DEL", c.prefix+key))
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
 		return ErrCacheMiss
 	}
-	_, err := conn.Do("DEL", key)
-	return err
+	return nil
 }
 
-func (c *RedisStore) Increment(key string, delta uint64) (uint64, error) {
+// TTL returns the time left before key expires, or FOREVER.
+func (c *RedisStore) TTL(key string) (time.Duration, error) {
 	conn := c.pool.Get()
 	defer conn.Close()
-	// Check for existance *before* increment as per the cache contract.
-	// redis will auto create the key, and we don't want that. Since we need to do increment
-	// ourselves instead of natively via INCRBY (redis doesn't support wrapping), we get the value
-	// and do the exists check this way to minimize calls to Redis
-	val, err := conn.Do("GET", key)
-	if val == nil {
+	ttl, err := redis.Int64(conn.Do("PTTL", c.prefix+key))
+	if err != nil {
+		return 0, err
+	}
+	switch ttl {
+	case -2:
+		return 0, ErrCacheMiss
+	case -1:
+		return FOREVER, nil
+	}
+	return time.Duration(ttl) * time.Millisecond, nil
+}
+
+// Increment wraps around on overflow: redis counters are signed 64 bit values
+// and the delta is passed on as its two's complement.
+func (c *RedisStore) Increment(key string, delta uint64) (uint64, error) {
+	return c.IncrementContext(context.Background(), key, delta)
+}
+
+// IncrementContext is like Increment, bounded by ctx.
+func (c *RedisStore) IncrementContext(ctx context.Context, key string, delta uint64) (uint64, error) {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return counterReply(incrScript.Do(conn, c.prefix+key, int64(delta)))
+}
+
+func (c *RedisStore) Decrement(key string, delta uint64) (uint64, error) {
+	return c.DecrementContext(context.Background(), key, delta)
+}
+
+// DecrementContext is like Decrement, bounded by ctx.
+func (c *RedisStore) DecrementContext(ctx context.Context, key string, delta uint64) (uint64, error) {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return counterReply(decrScript.Do(conn, c.prefix+key, delta))
+}
+
+// counterReply converts the reply of the counter scripts, where a nil reply
+// means the key did not exist.
+func counterReply(reply interface{}, err error) (uint64, error) {
+	if reply == nil && err == nil {
 		return 0, ErrCacheMiss
 	}
-	if err == nil {
-		currentVal, err := redis.Int64(val, nil)
+	value, err := redis.Int64(reply, err)
+	return uint64(value), err
+}
+
+// Flush removes every key under the store prefix, leaving unrelated keys of
+// the database alone.
+func (c *RedisStore) Flush() error {
+	return c.FlushContext(context.Background())
+}
+
+// FlushContext is like Flush, bounded by ctx.
+func (c *RedisStore) FlushContext(ctx context.Context) error {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return c.flushPrefix(conn, "")
+}
+
+// FlushPrefix removes all the keys starting with prefix.
+func (c *RedisStore) FlushPrefix(prefix string) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+	return c.flushPrefix(conn, prefix)
+}
+
+func (c *RedisStore) flushPrefix(conn redis.Conn, prefix string) error {
+	return c.scan(conn, prefix, func(keys []interface{}) error {
+		_, err := conn.Do("DEL", keys...)
+		return err
+	})
+}
+
+// Keys returns the keys starting with prefix, as found by SCAN: keys added or
+// removed meanwhile may or may not be listed.
+func (c *RedisStore) Keys(prefix string) ([]string, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	var keys []string
+	err := c.scan(conn, prefix, func(batch []interface{}) error {
+		for _, key := range batch {
+			key, err := redis.String(key, nil)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, strings.TrimPrefix(key, c.prefix))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// scan calls fn with every batch of the keys starting with prefix, including
+// the store prefix.
+func (c *RedisStore) scan(conn redis.Conn, prefix string, fn func(keys []interface{}) error) error {
+	pattern := globEscape(c.prefix+prefix) + "*"
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern))
 		if err != nil {
-			return 0, err
+			return err
+		}
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return err
 		}
-		var sum int64 = currentVal + int64(delta)
-		_, err = conn.Do("SET", key, sum)
+		keys, err := redis.Values(values[1], nil)
 		if err != nil {
-			return 0, err
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if cursor == 0 {
+			return nil
 		}
-		return uint64(sum), nil
-	} else {
-		return 0, err
 	}
 }
 
-func (c *RedisStore) Decrement(key string, delta uint64) (newValue uint64, err error) {
-	conn := c.pool.Get()
-	defer conn.Close()
-	// Check for existance *before* increment as per the cache contract.
-	// redis will auto create the key, and we don't want that, hence the exists call
-	if !exists(conn, key) {
-		return 0, ErrCacheMiss
+// globEscape escapes the characters of s that are special in redis patterns.
+func globEscape(s string) string {
+	var escaped []byte
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, s[i])
 	}
-	// Decrement contract says you can only go to 0
-	// so we go fetch the value and if the delta is greater than the amount,
-	// 0 out the value
-	currentVal, err := redis.Int64(conn.Do("GET", key))
-	if err == nil && delta > uint64(currentVal) {
-		tempint, err := redis.Int64(conn.Do("DECRBY", key, currentVal))
-		return uint64(tempint), err
+	return string(escaped)
+}
+
+func (c *RedisStore) invoke(conn redis.Conn, key string, value interface{}, expires time.Duration) error {
+	cmd, args, err := c.command(key, value, expires)
+	if err != nil {
+		return err
 	}
-	tempint, err := redis.Int64(conn.Do("DECRBY", key, delta))
-	return uint64(tempint), err
+	_, err = conn.Do(cmd, args...)
+	return err
 }
 
-func (c *RedisStore) Flush() error {
+// command returns the command storing value at key.
+func (c *RedisStore) command(key string, value interface{}, expires time.Duration) (string, []interface{}, error) {
+	expires = c.expiration(expires)
+	b, err := c.codec.Marshal(value)
+	if err != nil {
+		return "", nil, err
+	}
+	if expires > 0 {
+		// PSETEX keeps expirations shorter than a second.
+		return "PSETEX", []interface{}{c.prefix + key, int64(expires / time.Millisecond), b}, nil
+	}
+	return "SET", []interface{}{c.prefix + key, b}, nil
+}
+
+// Tag adds key to the redis set of the keys tagged with tag.
+func (c *RedisStore) Tag(tag string, key string, expires time.Duration) error {
+	expires = c.expiration(expires)
 	conn := c.pool.Get()
 	defer conn.Close()
-	_, err := conn.Do("FLUSHALL")
+	_, err := tagScript.Do(conn, c.prefix+tag, key, int64(expires/time.Millisecond))
 	return err
 }
 
-func (c *RedisStore) invoke(f func(string, ...interface{}) (interface{}, error),
-	key string, value interface{}, expires time.Duration) error {
+// PopTag removes the redis set of the keys tagged with tag and returns them.
+func (c *RedisStore) PopTag(tag string) ([]string, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	return redis.Strings(popTagScript.Do(conn, c.prefix+tag))
+}
 
-	switch expires {
-	case DEFAULT:
+// expiration returns the expiration of an entry stored for expires, 0 for
+// none.
+func (c *RedisStore) expiration(expires time.Duration) time.Duration {
+	if expires == DEFAULT {
 		expires = c.defaultExpiration
-	case FOREVER:
-		expires = time.Duration(0)
 	}
+	if expires < time.Millisecond {
+		// FOREVER, or a default of FOREVER.
+		return 0
+	}
+	return expires
+}
 
-	b, err := serialize(value)
+// GetMany fetches keys with a single MGET.
+func (c *RedisStore) GetMany(keys []string, values []interface{}) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = c.prefix + key
+	}
+	items, err := redis.Values(conn.Do("MGET", args...))
 	if err != nil {
 		return err
 	}
+	var missing []string
+	for i, item := range items {
+		if item == nil {
+			missing = append(missing, keys[i])
+			continue
+		}
+		b, err := redis.Bytes(item, nil)
+		if err != nil {
+			return err
+		}
+		if err := c.codec.Unmarshal(b, values[i]); err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingKeysError{missing}
+	}
+	return nil
+}
+
+// SetMany stores entries with pipelined SET and PSETEX commands.
+func (c *RedisStore) SetMany(entries ...BatchEntry) error {
+	type command struct {
+		name string
+		args []interface{}
+	}
+	commands := make([]command, len(entries))
+	for i, entry := range entries {
+		name, args, err := c.command(entry.Key, entry.Value, entry.Expire)
+		if err != nil {
+			return err
+		}
+		commands[i] = command{name, args}
+	}
 	conn := c.pool.Get()
 	defer conn.Close()
-	if expires > 0 {
-		_, err := f("SETEX", key, int32(expires/time.Second), b)
-		return err
-	} else {
-		_, err := f("SET", key, b)
+	for _, cmd := range commands {
+		if err := conn.Send(cmd.name, cmd.args...); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
 		return err
 	}
+	for range entries {
+		if _, err := conn.Receive(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -1,12 +1,19 @@
+//go:build redis
+// +build redis
+
 package cache
 
 import (
+	"context"
 	"net"
 	"testing"
 	"time"
+
+	"github.com/garyburd/redigo/redis"
 )
 
 // These tests require redis server running on localhost:6379 (the default)
+// and are only built with the redis tag: go test -tags redis
 const redisTestServer = "localhost:6379"
 
 var newRedisStore = func(t *testing.T, defaultExpiration time.Duration) CacheStore {
@@ -35,6 +42,10 @@ func TestRedisCache_Expiration(t *testing.T) {
 	expiration(t, newRedisStore)
 }
 
+func TestRedisCache_ExpirationConstants(t *testing.T) {
+	expirationConstants(t, newRedisStore)
+}
+
 func TestRedisCache_EmptyCache(t *testing.T) {
 	emptyCache(t, newRedisStore)
 }
@@ -46,3 +57,113 @@ func TestRedisCache_Replace(t *testing.T) {
 func TestRedisCache_Add(t *testing.T) {
 	testAdd(t, newRedisStore)
 }
+
+func TestRedisCache_TTL(t *testing.T) {
+	testTTL(t, newRedisStore)
+}
+
+func TestRedisCache_Keys(t *testing.T) {
+	testKeys(t, newRedisStore)
+}
+
+func TestRedisCache_Tags(t *testing.T) {
+	testTags(t, newRedisStore)
+}
+
+func TestRedisCache_Prefix(t *testing.T) {
+	plain := newRedisStore(t, time.Hour)
+	prefixed := NewRedisCacheWithPrefix(plain.(*RedisStore).pool, "prefix:", time.Hour)
+
+	if err := plain.Set("unrelated", "value", DEFAULT); err != nil {
+		t.Errorf("Error setting a value: %s", err)
+	}
+	if err := prefixed.Set("value", "foo", DEFAULT); err != nil {
+		t.Errorf("Error setting a value: %s", err)
+	}
+
+	var value string
+	if err := plain.Get("prefix:value", &value); err != nil || value != "foo" {
+		t.Errorf("Expected the key to be stored under the prefix, got %q: %v", value, err)
+	}
+
+	if err := prefixed.Flush(); err != nil {
+		t.Errorf("Error flushing: %s", err)
+	}
+	if err := prefixed.Get("value", &value); err != ErrCacheMiss {
+		t.Errorf("Expected CacheMiss after flush, got: %v", err)
+	}
+	if err := plain.Get("unrelated", &value); err != nil {
+		t.Errorf("Expected unrelated key to survive a prefix flush, got: %s", err)
+	}
+}
+
+func TestRedisCache_JSONCodec(t *testing.T) {
+	plain := newRedisStore(t, time.Hour)
+	newJSONStore := func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+		return NewRedisCacheWithCodec(plain.(*RedisStore).pool, "", defaultExpiration, JSONCodec{})
+	}
+	typicalGetSet(t, newJSONStore)
+	incrDecr(t, newJSONStore)
+
+	store := newJSONStore(t, time.Hour)
+	store.Set("value", map[string]int{"a": 1}, DEFAULT)
+	var raw []byte
+	if err := plain.Get("value", &raw); err != nil || string(raw) != `{"a":1}` {
+		t.Errorf("Expected the value to be stored as JSON, got %q: %v", raw, err)
+	}
+}
+
+func TestRedisCache_FlushPrefix(t *testing.T) {
+	store := newRedisStore(t, time.Hour).(*RedisStore)
+	store.Set("page:1", "value", DEFAULT)
+	store.Set("page:2", "value", DEFAULT)
+	store.Set("page*", "value", DEFAULT)
+	store.Set("unrelated", "value", DEFAULT)
+
+	if err := store.FlushPrefix("page:"); err != nil {
+		t.Errorf("Error flushing a prefix: %s", err)
+	}
+	var value string
+	for _, key := range []string{"page:1", "page:2"} {
+		if err := store.Get(key, &value); err != ErrCacheMiss {
+			t.Errorf("Expected %s to be flushed, got: %v", key, err)
+		}
+	}
+	for _, key := range []string{"page*", "unrelated"} {
+		if err := store.Get(key, &value); err != nil {
+			t.Errorf("Expected %s to survive the flush, got: %v", key, err)
+		}
+	}
+	if err := FlushPages(store); err != nil {
+		t.Errorf("Error flushing pages: %s", err)
+	}
+}
+
+func TestRedisCache_Batch(t *testing.T) {
+	RegisterGobTypes()
+	batchEquivalence(t, newRedisStore)
+}
+
+func TestRedisCache_HungServer(t *testing.T) {
+	addr := silentServer(t)
+	store := NewRedisCacheWithPool(&redis.Pool{
+		Dial: func() (redis.Conn, error) { return redis.Dial("tcp", addr) },
+	}, time.Hour)
+	if withContext(store) != ContextCacheStore(store) {
+		t.Errorf("Expected RedisStore to be context aware")
+	}
+
+	var value string
+	expectDeadline(t, "GetContext", func(ctx context.Context) error {
+		return store.GetContext(ctx, "key", &value)
+	})
+	expectDeadline(t, "SetContext", func(ctx context.Context) error {
+		return store.SetContext(ctx, "key", "value", DEFAULT)
+	})
+	expectDeadline(t, "AddContext", func(ctx context.Context) error {
+		return store.AddContext(ctx, "key", "value", DEFAULT)
+	})
+	expectDeadline(t, "DeleteContext", func(ctx context.Context) error {
+		return store.DeleteContext(ctx, "key")
+	})
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// stale reports whether the cached response is past its freshness deadline
+// but still within its stale-while-revalidate window.
+func (cache *ResponseCache) stale(now time.Time) bool {
+	return !cache.FreshUntil.IsZero() && now.After(cache.FreshUntil) && now.Before(cache.StaleUntil)
+}
+
+// expired reports whether the cached response is past its freshness deadline
+// and its stale-while-revalidate window, and only kept to be served in place
+// of an error.
+func (cache *ResponseCache) expired(now time.Time) bool {
+	return !cache.FreshUntil.IsZero() && now.After(cache.FreshUntil) && !now.Before(cache.StaleUntil)
+}
+
+// revalidationLock returns the store key of the lock held while refreshing
+// the page at key.
+func revalidationLock(key string, options Options) string {
+	return options.Prefix + ".lock:" + key
+}
+
+// lockRevalidation takes the lock to refresh the page at key, returning the
+// function releasing it, or false if the page is already being refreshed.
+// Failing to reach the store counts as the lock being held.
+func (p *pageCache) lockRevalidation(r *http.Request, store ContextCacheStore, key string) (func(), bool) {
+	timeout := p.options.RevalidationLockTimeout
+	if timeout <= 0 {
+		if !p.revalidating.tryAdd(key) {
+			return nil, false
+		}
+		return func() { p.revalidating.remove(key) }, true
+	}
+
+	lock := revalidationLock(key, p.options)
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, false
+	}
+	token := hex.EncodeToString(b)
+	if err := store.AddContext(r.Context(), lock, token, timeout); err != nil {
+		return nil, false
+	}
+	return func() {
+		// The lock may have expired and been taken over meanwhile. It can
+		// still expire between the check and the delete.
+		var holder string
+		if store.GetContext(context.Background(), lock, &holder) == nil && holder == token {
+			store.DeleteContext(context.Background(), lock)
+		}
+	}, true
+}
+
+// revalidate runs the handler of x in the background, on the exchange
+// detached from it, and stores the response, which is otherwise discarded, in
+// place of the stale page: if the page was invalidated meanwhile, it isn't
+// stored again. A panic of the handler, which no recovery middleware can
+// catch there, is reported as an error storing the page, which is kept.
+func revalidate(x *exchange, store ContextCacheStore, expire time.Duration, key string, options Options, done func()) {
+	discard := &discardWriter{header: http.Header{}, status: http.StatusOK}
+	bx := x.detach(discard)
+	go func() {
+		defer done()
+		writer := newCachedWriter(store, expire, discard, key, bx, options)
+		writer.replace = true
+		defer func() {
+			if r := recover(); r != nil {
+				writer.fail(fmt.Errorf("cache: revalidation panicked: %v", r))
+			}
+		}()
+		bx.run(writer)
+		writer.finalize()
+	}()
+}
+
+// discardWriter is a gin.ResponseWriter without a client, used to run
+// handlers in the background.
+type discardWriter struct {
+	header  http.Header
+	status  int
+	size    int
+	written bool
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) WriteHeader(code int) {
+	if !w.written {
+		w.status = code
+	}
+}
+
+func (w *discardWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+func (w *discardWriter) Write(data []byte) (int, error) {
+	w.written = true
+	w.size += len(data)
+	return len(data), nil
+}
+
+func (w *discardWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *discardWriter) Status() int {
+	return w.status
+}
+
+func (w *discardWriter) Size() int {
+	return w.size
+}
+
+func (w *discardWriter) Written() bool {
+	return w.written
+}
+
+func (w *discardWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("cache: background revalidation can't be hijacked")
+}
+
+func (w *discardWriter) Flush() {}
+
+func (w *discardWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *discardWriter) Pusher() http.Pusher {
+	return nil
+}
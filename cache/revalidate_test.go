@@ -0,0 +1,374 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// makeStale moves the freshness deadline of the entry at key to the past.
+func makeStale(store CacheStore, key string) {
+	var cache ResponseCache
+	store.Get(key, &cache)
+	cache.FreshUntil = time.Now().Add(-time.Second)
+	store.Set(key, cache, DEFAULT)
+}
+
+// waitForBody polls the store until the entry at key holds body.
+func waitForBody(t *testing.T, store CacheStore, key string, body string) {
+	for i := 0; i < 100; i++ {
+		var cache ResponseCache
+		if store.Get(key, &cache) == nil && string(cache.Data) == body {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Timed out waiting for %q to be cached", body)
+}
+
+func TestCachePage_StaleWhileRevalidate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	var calls int32
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(store, time.Minute, Options{StaleWhileRevalidate: time.Minute}, func(c *gin.Context) {
+		n := atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		c.String(http.StatusOK, fmt.Sprint(n))
+	}))
+	key := urlEscape(PageCachePrefix, "/page")
+
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+	var cache ResponseCache
+	store.Get(key, &cache)
+	if cache.StaleUntil.Sub(cache.FreshUntil) != time.Minute {
+		t.Errorf("Expected a one minute stale window, got %s", cache.StaleUntil.Sub(cache.FreshUntil))
+	}
+
+	makeStale(store, key)
+	// The stale page is served right away, and only refreshed once.
+	for i := 0; i < 5; i++ {
+		expectBody(t, performRequest(r, "GET", "/page"), "1")
+	}
+	waitForBody(t, store, key, "2")
+	expectBody(t, performRequest(r, "GET", "/page"), "2")
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Expected a single refresh, handler ran %d times", n)
+	}
+}
+
+func TestCachePage_RevalidatePanic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	errs := make(chan error, 1)
+	var calls int32
+	r := gin.New()
+	options := Options{StaleWhileRevalidate: time.Minute, OnError: func(err error) { errs <- err }}
+	r.GET("/page", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+		if n := atomic.AddInt32(&calls, 1); n > 1 {
+			panic("origin down")
+		}
+		c.String(http.StatusOK, "page")
+	}))
+	key := urlEscape(PageCachePrefix, "/page")
+
+	expectBody(t, performRequest(r, "GET", "/page"), "page")
+	makeStale(store, key)
+	expectBody(t, performRequest(r, "GET", "/page"), "page")
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "origin down") {
+			t.Errorf("Expected the panic to be reported, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the panic to be reported")
+	}
+	// The stale page is kept, and refreshed again once the lock is released.
+	for i := 0; i < 100 && atomic.LoadInt32(&calls) < 3; i++ {
+		expectBody(t, performRequest(r, "GET", "/page"), "page")
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("Expected the refresh to be retried, handler ran %d times", n)
+	}
+}
+
+func TestCachePage_RevalidateAfterInvalidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	release := make(chan struct{})
+	var calls int32
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(store, time.Minute, Options{StaleWhileRevalidate: time.Minute}, func(c *gin.Context) {
+		if n := atomic.AddInt32(&calls, 1); n > 1 {
+			<-release
+		}
+		c.String(http.StatusOK, "page")
+	}))
+	key := urlEscape(PageCachePrefix, "/page")
+	expectBody(t, performRequest(r, "GET", "/page"), "page")
+
+	makeStale(store, key)
+	expectBody(t, performRequest(r, "GET", "/page"), "page")
+	// The page is invalidated while being refreshed.
+	if err := InvalidateURL(store, "/page"); err != nil {
+		t.Fatalf("Unexpected error invalidating: %s", err)
+	}
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+	var cache ResponseCache
+	if err := store.Get(key, &cache); err != ErrCacheMiss {
+		t.Errorf("Expected the refresh not to store the invalidated page again, got %v", err)
+	}
+}
+
+func TestCached_StaleWhileRevalidate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	calls := 0
+	r := gin.New()
+	r.Use(Cache(store))
+	r.GET("/page", CachedWithOptions(time.Minute, Options{StaleWhileRevalidate: time.Minute}), func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, fmt.Sprint(calls))
+	})
+	key := urlEscape(PageCachePrefix, "/page")
+
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+	makeStale(store, key)
+	// The request finding the page stale refreshes it.
+	expectBody(t, performRequest(r, "GET", "/page"), "2")
+	expectBody(t, performRequest(r, "GET", "/page"), "2")
+}
+
+// makeExpired moves the freshness deadline and stale window of the entry at
+// key to the past.
+func makeExpired(store CacheStore, key string) {
+	var cache ResponseCache
+	store.Get(key, &cache)
+	cache.FreshUntil = time.Now().Add(-time.Second)
+	cache.StaleUntil = cache.FreshUntil
+	store.Set(key, cache, DEFAULT)
+}
+
+func TestCachePage_ServeStaleOnError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	status := http.StatusOK
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(store, time.Minute, Options{ServeStaleOnError: time.Minute}, func(c *gin.Context) {
+		c.Header("X-Origin", "origin")
+		c.String(status, fmt.Sprint(status))
+	}))
+	key := urlEscape(PageCachePrefix, "/page")
+
+	expectBody(t, performRequest(r, "GET", "/page"), "200")
+	var cache ResponseCache
+	store.Get(key, &cache)
+	if cache.ErrorUntil.Sub(cache.FreshUntil) != time.Minute {
+		t.Errorf("Expected a one minute grace period, got %s", cache.ErrorUntil.Sub(cache.FreshUntil))
+	}
+
+	// The origin fails once the page expired: the stale page is served.
+	status = http.StatusBadGateway
+	makeExpired(store, key)
+	w := performRequest(r, "GET", "/page")
+	if w.Code != http.StatusOK || w.Body.String() != "200" {
+		t.Errorf("Expected the stale page in place of the error, got %d %q", w.Code, w.Body.String())
+	}
+
+	// Past the grace period, the error goes through.
+	store.Get(key, &cache)
+	cache.ErrorUntil = time.Now().Add(-time.Second)
+	store.Set(key, cache, DEFAULT)
+	w = performRequest(r, "GET", "/page")
+	if w.Code != http.StatusBadGateway || w.Body.String() != "502" {
+		t.Errorf("Expected the error past the grace period, got %d %q", w.Code, w.Body.String())
+	}
+
+	// Successful responses refresh the page.
+	status = http.StatusOK
+	makeExpired(store, key)
+	expectBody(t, performRequest(r, "GET", "/page"), "200")
+	store.Get(key, &cache)
+	if cache.stale(time.Now()) || time.Now().After(cache.FreshUntil) {
+		t.Errorf("Expected the page to be fresh again")
+	}
+}
+
+func TestCachePage_ServeStaleOnPanic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	mode := ""
+	var errs int
+	r := gin.New()
+	r.Use(gin.RecoveryWithWriter(io.Discard))
+	r.Use(func(c *gin.Context) {
+		c.Next()
+		errs += len(c.Errors)
+	})
+	r.GET("/page", CachePageWithOptions(store, time.Minute, Options{ServeStaleOnError: time.Minute}, func(c *gin.Context) {
+		switch mode {
+		case "panic":
+			panic("origin failed")
+		case "flush":
+			c.String(http.StatusOK, "streamed")
+			c.Writer.Flush()
+			panic("origin failed")
+		}
+		c.String(http.StatusOK, "page")
+	}))
+	key := urlEscape(PageCachePrefix, "/page")
+	expectBody(t, performRequest(r, "GET", "/page"), "page")
+
+	// The origin panics once the page expired: the stale page is served.
+	mode = "panic"
+	makeExpired(store, key)
+	w := performRequest(r, "GET", "/page")
+	if w.Code != http.StatusOK || w.Body.String() != "page" {
+		t.Errorf("Expected the stale page in place of the panic, got %d %q", w.Code, w.Body.String())
+	}
+	if errs != 1 {
+		t.Errorf("Expected the panic to be reported in the context errors, got %d", errs)
+	}
+
+	// Once the response was sent, the panic goes on to the recovery.
+	mode = "flush"
+	w = performRequest(r, "GET", "/page")
+	if w.Code != http.StatusOK || w.Body.String() != "streamed" {
+		t.Errorf("Expected the streamed response, got %d %q", w.Code, w.Body.String())
+	}
+
+	// Past the grace period, the recovery answers.
+	mode = "panic"
+	var cache ResponseCache
+	store.Get(key, &cache)
+	cache.ErrorUntil = time.Now().Add(-time.Second)
+	store.Set(key, cache, DEFAULT)
+	if w := performRequest(r, "GET", "/page"); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected the recovery status past the grace period, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+// shiftDeadlines moves the freshness deadlines of the entry at key d earlier,
+// as if d had passed.
+func shiftDeadlines(store CacheStore, key string, d time.Duration) {
+	var cache ResponseCache
+	store.Get(key, &cache)
+	cache.FreshUntil = cache.FreshUntil.Add(-d)
+	cache.StaleUntil = cache.StaleUntil.Add(-d)
+	cache.ErrorUntil = cache.ErrorUntil.Add(-d)
+	store.Set(key, cache, DEFAULT)
+}
+
+func TestStaleIfError(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		grace  time.Duration
+	}{
+		{"", time.Minute},
+		{"max-age=60", time.Minute},
+		{"max-age=60, stale-if-error=30", 30 * time.Second},
+		{"Stale-If-Error=\"10\"", 10 * time.Second},
+		{"stale-if-error=0", 0},
+		{"stale-if-error=soon", time.Minute},
+		{"stale-if-error=-1", time.Minute},
+	} {
+		header := http.Header{"Cache-Control": {test.header}}
+		if grace := staleIfError(header, time.Minute); grace != test.grace {
+			t.Errorf("%q: expected %s, got %s", test.header, test.grace, grace)
+		}
+	}
+}
+
+func TestCachePage_StaleIfErrorDirective(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	status := http.StatusOK
+	r := gin.New()
+	r.GET("/page", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.Header("Cache-Control", "max-age=60, stale-if-error=30")
+		c.String(status, fmt.Sprint(status))
+	}))
+	key := urlEscape(PageCachePrefix, "/page")
+
+	expectBody(t, performRequest(r, "GET", "/page"), "200")
+	var cache ResponseCache
+	store.Get(key, &cache)
+	if cache.ErrorUntil.Sub(cache.FreshUntil) != 30*time.Second {
+		t.Errorf("Expected a 30s grace period, got %s", cache.ErrorUntil.Sub(cache.FreshUntil))
+	}
+
+	// 10s past its expiration, the page is served in place of errors.
+	status = http.StatusInternalServerError
+	shiftDeadlines(store, key, 70*time.Second)
+	w := performRequest(r, "GET", "/page")
+	if w.Code != http.StatusOK || w.Body.String() != "200" {
+		t.Errorf("Expected the stale page within the grace period, got %d %q", w.Code, w.Body.String())
+	}
+
+	// 40s past its expiration, it isn't anymore.
+	shiftDeadlines(store, key, 30*time.Second)
+	w = performRequest(r, "GET", "/page")
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected the error past the grace period, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestCachePage_RevalidationLockTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	clock := newFakeClock()
+	store := NewInMemoryStore(time.Minute)
+	store.SetClock(clock.Now)
+	release := make(chan struct{})
+	defer close(release)
+	var calls int32
+	handler := func(c *gin.Context) {
+		n := atomic.AddInt32(&calls, 1)
+		if n == 2 {
+			// The first refresh hangs.
+			<-release
+		}
+		c.String(http.StatusOK, fmt.Sprint(n))
+	}
+	options := Options{StaleWhileRevalidate: time.Minute, RevalidationLockTimeout: 10 * time.Second}
+	// Two instances sharing the store, as in two processes.
+	r1, r2 := gin.New(), gin.New()
+	r1.GET("/page", CachePageWithOptions(store, time.Minute, options, handler))
+	r2.GET("/page", CachePageWithOptions(store, time.Minute, options, handler))
+	key := urlEscape(PageCachePrefix, "/page")
+
+	expectBody(t, performRequest(r1, "GET", "/page"), "1")
+	makeStale(store, key)
+	expectBody(t, performRequest(r1, "GET", "/page"), "1")
+	for i := 0; i < 100 && atomic.LoadInt32(&calls) < 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The other instance doesn't refresh the page while the lock is held.
+	expectBody(t, performRequest(r2, "GET", "/page"), "1")
+	expectBody(t, performRequest(r1, "GET", "/page"), "1")
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Expected a single refresh while the lock is held, got %d calls", n)
+	}
+
+	// Once the lock has expired, the hung refresh is taken over.
+	clock.Advance(11 * time.Second)
+	expectBody(t, performRequest(r2, "GET", "/page"), "1")
+	waitForBody(t, store, key, "3")
+	var holder string
+	lock := revalidationLock(key, applyDefaults(options))
+	for i := 0; i < 100 && store.Get(lock, &holder) == nil; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if err := store.Get(lock, &holder); err != ErrCacheMiss {
+		t.Errorf("Expected the lock to be released after the refresh, got %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CacheByRoute is a middleware caching the pages of each route for the
+// expiration listed for its pattern in ttls, e.g. "/catalog/:id", as returned
+// by c.FullPath. Routes that aren't listed are cached for defaultExpire. An
+// expiration of 0 disables caching for the route; use FOREVER for pages that
+// never expire.
+func CacheByRoute(store CacheStore, ttls map[string]time.Duration, defaultExpire time.Duration) gin.HandlerFunc {
+	return CacheByRouteWithOptions(store, ttls, defaultExpire, Options{})
+}
+
+// CacheByRouteWithOptions is like CacheByRoute but allows tuning the cache behavior.
+func CacheByRouteWithOptions(cacheStore CacheStore, ttls map[string]time.Duration, defaultExpire time.Duration, options Options) gin.HandlerFunc {
+	p := newPageCache(options)
+	store := withContext(cacheStore)
+	// Copy the table, so it isn't read while the caller changes it.
+	routes := make(map[string]time.Duration, len(ttls))
+	for route, expire := range ttls {
+		routes[route] = expire
+	}
+	return func(c *gin.Context) {
+		expire, found := routes[c.FullPath()]
+		if !found {
+			expire = defaultExpire
+		}
+		if expire == 0 {
+			c.Next()
+			return
+		}
+		p.serveContext(c, store, expire, nil)
+	}
+}
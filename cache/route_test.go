@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCacheByRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := newRecordingStore()
+	r := gin.New()
+	r.Use(CacheByRoute(store, map[string]time.Duration{
+		"/catalog/:id": time.Hour,
+		"/prices":      time.Minute,
+		"/cart":        0,
+	}, 10*time.Second))
+	calls := 0
+	handler := func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, fmt.Sprint(calls))
+	}
+	for _, path := range []string{"/catalog/:id", "/prices", "/cart", "/other"} {
+		r.GET(path, handler)
+	}
+
+	for path, expire := range map[string]time.Duration{
+		"/catalog/42": time.Hour,
+		"/prices":     time.Minute,
+		"/other":      10 * time.Second,
+	} {
+		body := performRequest(r, "GET", path).Body.String()
+		expectBody(t, performRequest(r, "GET", path), body)
+		if got := store.expires[urlEscape(PageCachePrefix, path)]; got != expire {
+			t.Errorf("Expected %s to be cached for %s, got %s", path, expire, got)
+		}
+	}
+
+	body := performRequest(r, "GET", "/cart").Body.String()
+	if performRequest(r, "GET", "/cart").Body.String() == body {
+		t.Errorf("Expected a route with a 0 expiration not to be cached")
+	}
+	if _, found := store.expires[urlEscape(PageCachePrefix, "/cart")]; found {
+		t.Errorf("Expected a route with a 0 expiration not to be stored")
+	}
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"hash/fnv"
+	"runtime"
+	"time"
+)
+
+// ShardedStore is a process local CacheStore spreading its keys over several
+// InMemoryStore shards, each with its own lock, LRU and janitor, to reduce
+// lock contention under high concurrency. Limits apply per shard, so the
+// least recently used entry of the whole store isn't always the first
+// evicted.
+type ShardedStore struct {
+	shards []*InMemoryStore
+}
+
+// NewShardedStore returns an unbounded store of n shards, or GOMAXPROCS shards
+// if n is 0.
+func NewShardedStore(defaultExpiration time.Duration, n int) *ShardedStore {
+	return NewShardedStoreWithLimits(defaultExpiration, n, 0, 0)
+}
+
+// NewShardedStoreWithLimits returns a store of n shards, or GOMAXPROCS shards
+// if n is 0, holding at most about maxEntries entries taking at most about
+// maxBytes bytes, split evenly between the shards. A limit of 0 disables it.
+func NewShardedStoreWithLimits(defaultExpiration time.Duration, n int, maxEntries int, maxBytes int) *ShardedStore {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	s := &ShardedStore{make([]*InMemoryStore, n)}
+	for i := range s.shards {
+		s.shards[i] = NewInMemoryStoreWithLimits(defaultExpiration, shareOf(maxEntries, n), shareOf(maxBytes, n))
+	}
+	return s
+}
+
+// shareOf returns the limit of a shard, rounded up so that a limit isn't
+// disabled.
+func shareOf(limit int, n int) int {
+	return (limit + n - 1) / n
+}
+
+// shard returns the shard holding key.
+func (s *ShardedStore) shard(key string) *InMemoryStore {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+func (s *ShardedStore) Get(key string, value interface{}) error {
+	return s.shard(key).Get(key, value)
+}
+
+func (s *ShardedStore) Set(key string, value interface{}, expires time.Duration) error {
+	return s.shard(key).Set(key, value, expires)
+}
+
+func (s *ShardedStore) Add(key string, value interface{}, expires time.Duration) error {
+	return s.shard(key).Add(key, value, expires)
+}
+
+func (s *ShardedStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.shard(key).Replace(key, value, expires)
+}
+
+func (s *ShardedStore) Delete(key string) error {
+	return s.shard(key).Delete(key)
+}
+
+func (s *ShardedStore) Increment(key string, n uint64) (uint64, error) {
+	return s.shard(key).Increment(key, n)
+}
+
+func (s *ShardedStore) Decrement(key string, n uint64) (uint64, error) {
+	return s.shard(key).Decrement(key, n)
+}
+
+// TTL returns the time left before key expires, or FOREVER.
+func (s *ShardedStore) TTL(key string) (time.Duration, error) {
+	return s.shard(key).TTL(key)
+}
+
+// Flush empties every shard.
+func (s *ShardedStore) Flush() error {
+	for _, shard := range s.shards {
+		shard.Flush()
+	}
+	return nil
+}
+
+// FlushPrefix removes all the keys starting with prefix.
+func (s *ShardedStore) FlushPrefix(prefix string) error {
+	for _, shard := range s.shards {
+		shard.FlushPrefix(prefix)
+	}
+	return nil
+}
+
+// Keys returns a snapshot of the live keys starting with prefix, taken shard
+// by shard.
+func (s *ShardedStore) Keys(prefix string) ([]string, error) {
+	var keys []string
+	for _, shard := range s.shards {
+		shardKeys, _ := shard.Keys(prefix)
+		keys = append(keys, shardKeys...)
+	}
+	return keys, nil
+}
+
+// Tag adds key to the keys tagged with tag, kept in the shard of tag.
+func (s *ShardedStore) Tag(tag string, key string, expire time.Duration) error {
+	return s.shard(tag).Tag(tag, key, expire)
+}
+
+// PopTag removes the keys tagged with tag and returns them.
+func (s *ShardedStore) PopTag(tag string) ([]string, error) {
+	return s.shard(tag).PopTag(tag)
+}
+
+// SetClock sets the time source of every shard, see InMemoryStore.SetClock.
+func (s *ShardedStore) SetClock(now func() time.Time) {
+	for _, shard := range s.shards {
+		shard.SetClock(now)
+	}
+}
+
+// SetAdmission sets the admission policy of every shard, see
+// InMemoryStore.SetAdmission.
+func (s *ShardedStore) SetAdmission(writes int) {
+	for _, shard := range s.shards {
+		shard.SetAdmission(writes)
+	}
+}
+
+// Len returns the number of entries in the store, including the expired ones
+// not removed yet.
+func (s *ShardedStore) Len() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Size returns the approximate size of the entries in the store, in bytes.
+func (s *ShardedStore) Size() int {
+	size := 0
+	for _, shard := range s.shards {
+		size += shard.Size()
+	}
+	return size
+}
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var newShardedStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewShardedStore(defaultExpiration, 4)
+}
+
+func TestShardedStore_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newShardedStore)
+}
+
+func TestShardedStore_IncrDecr(t *testing.T) {
+	incrDecr(t, newShardedStore)
+}
+
+func TestShardedStore_Expiration(t *testing.T) {
+	expiration(t, newShardedStore)
+}
+
+func TestShardedStore_EmptyCache(t *testing.T) {
+	emptyCache(t, newShardedStore)
+}
+
+func TestShardedStore_Replace(t *testing.T) {
+	testReplace(t, newShardedStore)
+}
+
+func TestShardedStore_Add(t *testing.T) {
+	testAdd(t, newShardedStore)
+}
+
+func TestShardedStore_Routing(t *testing.T) {
+	store := NewShardedStore(time.Hour, 8)
+	used := map[*InMemoryStore]bool{}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("key", i)
+		shard := store.shard(key)
+		if store.shard(key) != shard {
+			t.Fatalf("Expected %q to always map to the same shard", key)
+		}
+		used[shard] = true
+		store.Set(key, i, DEFAULT)
+		var v int
+		if err := shard.Get(key, &v); err != nil || v != i {
+			t.Errorf("Expected %q to be stored in its shard, got %d: %v", key, v, err)
+		}
+	}
+	if len(used) != 8 {
+		t.Errorf("Expected the keys to spread over the 8 shards, got %d", len(used))
+	}
+}
+
+func TestShardedStore_FlushAndSize(t *testing.T) {
+	store := NewShardedStoreWithLimits(time.Hour, 4, 100, 0)
+	for i := 0; i < 20; i++ {
+		store.Set(fmt.Sprint("key", i), []byte("ab"), DEFAULT)
+	}
+	if store.Len() != 20 || store.Size() != 40 {
+		t.Errorf("Expected 20 entries of 40 bytes, got %d of %d bytes", store.Len(), store.Size())
+	}
+	for _, shard := range store.shards {
+		if shard.maxEntries != 25 {
+			t.Errorf("Expected the limit to be split between the shards, got %d", shard.maxEntries)
+		}
+	}
+
+	store.Flush()
+	if store.Len() != 0 || store.Size() != 0 {
+		t.Errorf("Expected every shard to be flushed, got %d entries of %d bytes", store.Len(), store.Size())
+	}
+}
+
+func benchmarkConcurrent(b *testing.B, store CacheStore) {
+	for i := 0; i < 1000; i++ {
+		store.Set(fmt.Sprint("key", i), i, DEFAULT)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var v int
+		for i := 0; pb.Next(); i++ {
+			key := fmt.Sprint("key", i%1000)
+			if i%10 == 0 {
+				store.Set(key, i, DEFAULT)
+			} else {
+				store.Get(key, &v)
+			}
+		}
+	})
+}
+
+func BenchmarkInMemoryStore_Concurrent(b *testing.B) {
+	benchmarkConcurrent(b, NewInMemoryStore(time.Hour))
+}
+
+func BenchmarkShardedStore_Concurrent(b *testing.B) {
+	benchmarkConcurrent(b, NewShardedStore(time.Hour, 0))
+}
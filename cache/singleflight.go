@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"sync"
+)
+
+// flightGroup deduplicates concurrent regenerations of the same page, so a
+// single request runs the handler while the others wait for it to complete.
+// The calls are keyed by comparable values, e.g. the page keys.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[interface{}]*sync.WaitGroup
+}
+
+// do runs fn unless a call for key is already in flight, in which case it
+// waits for that call to complete instead. It reports whether fn was run.
+func (g *flightGroup) do(key interface{}, fn func()) bool {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[interface{}]*sync.WaitGroup)
+	}
+	if wg, found := g.calls[key]; found {
+		g.mu.Unlock()
+		wg.Wait()
+		return false
+	}
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	g.calls[key] = wg
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		wg.Done()
+	}()
+	fn()
+	return true
+}
+
+// keySet tracks keys with work in progress, e.g. background revalidations.
+type keySet struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+// tryAdd adds key and reports whether it wasn't in the set already.
+func (s *keySet) tryAdd(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[string]bool)
+	}
+	if s.keys[key] {
+		return false
+	}
+	s.keys[key] = true
+	return true
+}
+
+func (s *keySet) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+}
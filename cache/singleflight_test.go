@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFlightGroup(t *testing.T) {
+	var group flightGroup
+	var runs int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			group.do("key", func() {
+				atomic.AddInt32(&runs, 1)
+				<-release
+			})
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if runs != 1 {
+		t.Errorf("Expected a single run, got %d", runs)
+	}
+
+	// Once done, the key can run again.
+	if !group.do("key", func() {}) {
+		t.Errorf("Expected a new call to run")
+	}
+}
+
+func TestCachePage_SingleFlight(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var calls int32
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(NewInMemoryStore(time.Minute), time.Minute, Options{SingleFlight: true}, func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		c.String(http.StatusOK, "body")
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := performRequest(r, "GET", "/page")
+			if w.Body.String() != "body" {
+				t.Errorf("Expected body, got %q", w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("Expected the handler to run once, ran %d times", calls)
+	}
+}
+
+func TestCachePage_CoalescedMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	metrics := &CounterMetrics{}
+	release := make(chan struct{})
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(NewInMemoryStore(time.Minute), time.Minute, Options{SingleFlight: true, Metrics: metrics}, func(c *gin.Context) {
+		<-release
+		c.String(http.StatusOK, "body")
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			performRequest(r, "GET", "/page")
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := metrics.CoalescedRequests(); n != 4 {
+		t.Errorf("Expected 4 coalesced requests, got %d", n)
+	}
+	if waited := metrics.Waited(); waited < 4*40*time.Millisecond {
+		t.Errorf("Expected the waiters to report their wait, got %s", waited)
+	}
+	if metrics.Misses() != 1 || metrics.Hits() != 4 {
+		t.Errorf("Expected 1 miss and 4 hits, got %d and %d", metrics.Misses(), metrics.Hits())
+	}
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TagStore is implemented by stores able to keep the sets of keys sharing a
+// tag, so related pages can be invalidated together.
+type TagStore interface {
+	// Tag adds key to the keys tagged with tag. The set is kept at least
+	// expire, the expiration of the entry at key.
+	Tag(tag string, key string, expire time.Duration) error
+	// PopTag removes the set of keys tagged with tag and returns it, at once
+	// so keys tagged meanwhile are either returned or kept in a new set.
+	PopTag(tag string) ([]string, error)
+}
+
+// tagStore returns the TagStore behind store, if any.
+func tagStore(store CacheStore) (TagStore, bool) {
+	if s, ok := store.(contextStore); ok {
+		store = s.CacheStore
+	}
+	s, ok := store.(TagStore)
+	return s, ok
+}
+
+// contextTags returns the tags set by the handler in CACHE_TAGS_KEY.
+func contextTags(c *gin.Context) []string {
+	tags, _ := c.Get(CACHE_TAGS_KEY)
+	s, _ := tags.([]string)
+	return s
+}
+
+// tagName returns the name of tag in the store, apart from the page keys.
+func tagName(tag string, options Options) string {
+	return options.Prefix + ".tag:" + tag
+}
+
+// tagPage records that the page at key is tagged with tags. It returns
+// ErrNotSupport if the store doesn't implement TagStore.
+func tagPage(store CacheStore, key string, tags []string, expire time.Duration, options Options) error {
+	tagger, ok := tagStore(store)
+	if !ok {
+		return ErrNotSupport
+	}
+	for _, tag := range tags {
+		if err := tagger.Tag(tagName(tag, options), key, expire); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InvalidateTag removes the cached pages tagged with tag by the middlewares
+// with default options. It requires the store to implement TagStore and
+// returns ErrNotSupport otherwise.
+func InvalidateTag(store CacheStore, tag string) error {
+	return InvalidateTagWithOptions(store, tag, Options{})
+}
+
+// InvalidateTagWithOptions is like InvalidateTag for the pages tagged by the
+// middlewares configured with options.
+func InvalidateTagWithOptions(store CacheStore, tag string, options Options) error {
+	tagger, ok := tagStore(store)
+	if !ok {
+		return ErrNotSupport
+	}
+	keys, err := tagger.PopTag(tagName(tag, applyDefaults(options)))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		// Pages may have expired or been removed since they were tagged.
+		if err := store.Delete(key); err != nil && err != ErrCacheMiss {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testTags checks that the keys tagged alike are popped together.
+func testTags(t *testing.T, newCache cacheFactory) {
+	cache := newCache(t, time.Hour)
+	tagger := cache.(TagStore)
+	tagger.Tag("tag:a", "k1", DEFAULT)
+	tagger.Tag("tag:a", "k2", time.Minute)
+	tagger.Tag("tag:b", "k1", DEFAULT)
+
+	keys, err := tagger.PopTag("tag:a")
+	if err != nil {
+		t.Fatalf("Unexpected error popping the tag: %s", err)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "k1" || keys[1] != "k2" {
+		t.Errorf("Expected [k1 k2], got %v", keys)
+	}
+	if keys, err := tagger.PopTag("tag:a"); err != nil || len(keys) != 0 {
+		t.Errorf("Expected the tag to be removed, got %v: %v", keys, err)
+	}
+	if keys, err := tagger.PopTag("tag:b"); err != nil || len(keys) != 1 {
+		t.Errorf("Expected the other tag to be kept, got %v: %v", keys, err)
+	}
+}
+
+func TestInMemoryCache_Tags(t *testing.T) {
+	testTags(t, newInMemoryStore)
+}
+
+func TestNamespaceStore_Tags(t *testing.T) {
+	testTags(t, newNamespaceStore)
+}
+
+func TestTieredCache_Tags(t *testing.T) {
+	testTags(t, newTieredStore)
+}
+
+func TestCircuitBreakerStore_Tags(t *testing.T) {
+	testTags(t, func(t *testing.T, defaultExpiration time.Duration) CacheStore {
+		return NewCircuitBreakerStore(NewInMemoryStore(defaultExpiration), 3, time.Minute)
+	})
+}
+
+func TestEncryptedStore_Tags(t *testing.T) {
+	testTags(t, newEncryptedStore)
+}
+
+func TestInMemoryCache_TagExpiration(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewInMemoryStore(time.Hour)
+	cache.SetClock(clock.Now)
+	cache.Tag("tag", "short", time.Second)
+	cache.Tag("tag", "long", time.Minute)
+
+	clock.Advance(30 * time.Second)
+	cache.deleteExpired()
+	if keys, _ := cache.PopTag("tag"); len(keys) != 2 {
+		t.Errorf("Expected the tag to live as long as its longest lived key, got %v", keys)
+	}
+
+	cache.Tag("tag", "short", time.Second)
+	clock.Advance(2 * time.Second)
+	cache.deleteExpired()
+	if keys, _ := cache.PopTag("tag"); len(keys) != 0 {
+		t.Errorf("Expected the tag to expire, got %v", keys)
+	}
+}
+
+func TestInvalidateTag(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	calls := 0
+	r := gin.New()
+	r.GET("/:page", CachePage(store, time.Minute, func(c *gin.Context) {
+		calls++
+		switch c.Param("page") {
+		case "product", "reviews":
+			c.Set(CACHE_TAGS_KEY, []string{"product:42"})
+		}
+		c.String(http.StatusOK, fmt.Sprint(calls))
+	}))
+	expectBody(t, performRequest(r, "GET", "/product"), "1")
+	expectBody(t, performRequest(r, "GET", "/reviews"), "2")
+	expectBody(t, performRequest(r, "GET", "/home"), "3")
+
+	if err := InvalidateTag(store, "product:42"); err != nil {
+		t.Errorf("Unexpected error invalidating: %s", err)
+	}
+	expectBody(t, performRequest(r, "GET", "/product"), "4")
+	expectBody(t, performRequest(r, "GET", "/reviews"), "5")
+	expectBody(t, performRequest(r, "GET", "/home"), "3")
+
+	if err := InvalidateTag(failingStore{}, "product:42"); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport for a store without tags, got: %v", err)
+	}
+}
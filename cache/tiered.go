@@ -0,0 +1,207 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// TieredStore layers a small, fast store (L1), typically an InMemoryStore, in
+// front of a shared one (L2), typically a RedisStore or MemcachedStore. Reads
+// are served from L1 when possible and backfill it from L2. Writes go to both
+// stores, L2 first as it is the source of truth.
+//
+// L1 entries are kept at most l1Expiration, even if they are removed or
+// changed in L2 by another process meanwhile, so it should be short.
+type TieredStore struct {
+	l1           CacheStore
+	l2           CacheStore
+	l1Expiration time.Duration
+}
+
+// NewTieredStore returns a store reading l1 before l2. Entries are stored in
+// l1 with the expiration passed to the store capped at l1Expiration; a zero
+// l1Expiration keeps the expiration as is.
+func NewTieredStore(l1 CacheStore, l2 CacheStore, l1Expiration time.Duration) *TieredStore {
+	return &TieredStore{l1, l2, l1Expiration}
+}
+
+// expiration returns the l1 expiration of an entry stored for expires.
+func (s *TieredStore) expiration(expires time.Duration) time.Duration {
+	if s.l1Expiration > 0 && (expires <= 0 || expires > s.l1Expiration) {
+		return s.l1Expiration
+	}
+	return expires
+}
+
+func (s *TieredStore) Get(key string, value interface{}) error {
+	return s.GetContext(context.Background(), key, value)
+}
+
+func (s *TieredStore) Set(key string, value interface{}, expires time.Duration) error {
+	return s.SetContext(context.Background(), key, value, expires)
+}
+
+func (s *TieredStore) Add(key string, value interface{}, expires time.Duration) error {
+	return s.AddContext(context.Background(), key, value, expires)
+}
+
+func (s *TieredStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.ReplaceContext(context.Background(), key, value, expires)
+}
+
+func (s *TieredStore) Delete(key string) error {
+	return s.DeleteContext(context.Background(), key)
+}
+
+// Increment updates the l2 counter and drops the l1 copy, so the next Get
+// reads the new value.
+func (s *TieredStore) Increment(key string, delta uint64) (uint64, error) {
+	return s.IncrementContext(context.Background(), key, delta)
+}
+
+// Decrement updates the l2 counter and drops the l1 copy, so the next Get
+// reads the new value.
+func (s *TieredStore) Decrement(key string, delta uint64) (uint64, error) {
+	return s.DecrementContext(context.Background(), key, delta)
+}
+
+func (s *TieredStore) Flush() error {
+	return s.FlushContext(context.Background())
+}
+
+// GetContext is like Get, passing ctx on to both stores, which are only
+// bounded by it if they implement ContextCacheStore.
+func (s *TieredStore) GetContext(ctx context.Context, key string, value interface{}) error {
+	l1 := withContext(s.l1)
+	if err := l1.GetContext(ctx, key, value); err == nil {
+		return nil
+	}
+	if err := withContext(s.l2).GetContext(ctx, key, value); err != nil {
+		return err
+	}
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr && !v.IsNil() {
+		// The remaining lifetime of the l2 entry isn't known.
+		l1.SetContext(ctx, key, v.Elem().Interface(), s.expiration(DEFAULT))
+	}
+	return nil
+}
+
+// SetContext is like Set, passing ctx on as GetContext does.
+func (s *TieredStore) SetContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	if err := withContext(s.l2).SetContext(ctx, key, value, expires); err != nil {
+		return err
+	}
+	return withContext(s.l1).SetContext(ctx, key, value, s.expiration(expires))
+}
+
+// AddContext is like Add, passing ctx on as GetContext does.
+func (s *TieredStore) AddContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	if err := withContext(s.l2).AddContext(ctx, key, value, expires); err != nil {
+		return err
+	}
+	return withContext(s.l1).SetContext(ctx, key, value, s.expiration(expires))
+}
+
+// ReplaceContext is like Replace, passing ctx on as GetContext does.
+func (s *TieredStore) ReplaceContext(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	if err := withContext(s.l2).ReplaceContext(ctx, key, value, expires); err != nil {
+		return err
+	}
+	return withContext(s.l1).SetContext(ctx, key, value, s.expiration(expires))
+}
+
+// DeleteContext is like Delete, passing ctx on as GetContext does.
+func (s *TieredStore) DeleteContext(ctx context.Context, key string) error {
+	if err := withContext(s.l1).DeleteContext(ctx, key); err != nil && err != ErrCacheMiss {
+		return err
+	}
+	return withContext(s.l2).DeleteContext(ctx, key)
+}
+
+// IncrementContext is like Increment, passing ctx on as GetContext does. The
+// l1 copy is dropped even if ctx is done meanwhile.
+func (s *TieredStore) IncrementContext(ctx context.Context, key string, delta uint64) (uint64, error) {
+	value, err := withContext(s.l2).IncrementContext(ctx, key, delta)
+	s.l1.Delete(key)
+	return value, err
+}
+
+// DecrementContext is like Decrement, passing ctx on as IncrementContext does.
+func (s *TieredStore) DecrementContext(ctx context.Context, key string, delta uint64) (uint64, error) {
+	value, err := withContext(s.l2).DecrementContext(ctx, key, delta)
+	s.l1.Delete(key)
+	return value, err
+}
+
+// FlushContext is like Flush, passing ctx on as GetContext does.
+func (s *TieredStore) FlushContext(ctx context.Context) error {
+	if err := withContext(s.l1).FlushContext(ctx); err != nil {
+		return err
+	}
+	return withContext(s.l2).FlushContext(ctx)
+}
+
+// TTL returns the remaining lifetime of key in l2, the source of truth. It
+// requires l2 to implement TTLStore and returns ErrNotSupport otherwise.
+func (s *TieredStore) TTL(key string) (time.Duration, error) {
+	ttl, ok := ttlStore(s.l2)
+	if !ok {
+		return 0, ErrNotSupport
+	}
+	return ttl.TTL(key)
+}
+
+// FlushPrefix removes all the keys starting with prefix from both stores. It
+// requires l2 to implement PrefixFlusher and returns ErrNotSupport otherwise;
+// l1 is flushed entirely if it can't flush a prefix.
+func (s *TieredStore) FlushPrefix(prefix string) error {
+	flusher, ok := s.l2.(PrefixFlusher)
+	if !ok {
+		return ErrNotSupport
+	}
+	var err error
+	if l1, ok := s.l1.(PrefixFlusher); ok {
+		err = l1.FlushPrefix(prefix)
+	} else {
+		err = s.l1.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	return flusher.FlushPrefix(prefix)
+}
+
+// Keys returns the keys of l2 starting with prefix. It requires l2 to
+// implement KeyLister and returns ErrNotSupport otherwise.
+func (s *TieredStore) Keys(prefix string) ([]string, error) {
+	lister, ok := s.l2.(KeyLister)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	return lister.Keys(prefix)
+}
+
+// Tag adds key to the keys tagged with tag in l2. It requires l2 to implement
+// TagStore and returns ErrNotSupport otherwise.
+func (s *TieredStore) Tag(tag string, key string, expire time.Duration) error {
+	tagger, ok := tagStore(s.l2)
+	if !ok {
+		return ErrNotSupport
+	}
+	return tagger.Tag(tag, key, expire)
+}
+
+// PopTag removes the keys tagged with tag in l2 and returns them, dropping
+// their l1 copies so they aren't served until l1Expiration.
+func (s *TieredStore) PopTag(tag string) ([]string, error) {
+	tagger, ok := tagStore(s.l2)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	keys, err := tagger.PopTag(tag)
+	for _, key := range keys {
+		s.l1.Delete(key)
+	}
+	return keys, err
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// countingStore counts the Get calls reaching the wrapped store.
+type countingStore struct {
+	CacheStore
+	gets int
+}
+
+func (s *countingStore) Get(key string, value interface{}) error {
+	s.gets++
+	return s.CacheStore.Get(key, value)
+}
+
+var newTieredStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewTieredStore(NewInMemoryStore(defaultExpiration), NewInMemoryStore(defaultExpiration), 0)
+}
+
+func TestTieredCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newTieredStore)
+}
+
+func TestTieredCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newTieredStore)
+}
+
+func TestTieredCache_Expiration(t *testing.T) {
+	expiration(t, newTieredStore)
+}
+
+func TestTieredCache_ExpirationConstants(t *testing.T) {
+	expirationConstants(t, newTieredStore)
+}
+
+func TestTieredCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newTieredStore)
+}
+
+func TestTieredCache_Replace(t *testing.T) {
+	testReplace(t, newTieredStore)
+}
+
+func TestTieredCache_Add(t *testing.T) {
+	testAdd(t, newTieredStore)
+}
+
+func TestTieredCache_TTL(t *testing.T) {
+	testTTL(t, newTieredStore)
+}
+
+func TestTieredCache_Backfill(t *testing.T) {
+	l2 := &countingStore{CacheStore: NewInMemoryStore(time.Hour)}
+	store := NewTieredStore(NewInMemoryStore(time.Hour), l2, time.Minute)
+	l2.Set("key", "value", DEFAULT)
+
+	for i := 0; i < 2; i++ {
+		var value string
+		if err := store.Get("key", &value); err != nil || value != "value" {
+			t.Errorf("Expected to get the value, got %q, %v", value, err)
+		}
+	}
+	if l2.gets != 1 {
+		t.Errorf("Expected the second read to be served by l1, l2 was read %d times", l2.gets)
+	}
+}
+
+func TestTieredCache_L1Expiration(t *testing.T) {
+	l1 := newRecordingStore()
+	store := NewTieredStore(l1, NewInMemoryStore(time.Hour), time.Minute)
+	for key, expire := range map[string]time.Duration{
+		"short":   time.Second,
+		"long":    time.Hour,
+		"default": DEFAULT,
+		"forever": FOREVER,
+	} {
+		store.Set(key, "value", expire)
+		want := time.Minute
+		if expire == time.Second {
+			want = time.Second
+		}
+		if l1.expires[key] != want {
+			t.Errorf("Expected %s to be kept %s in l1, got %s", key, want, l1.expires[key])
+		}
+	}
+}
+
+func TestTieredCache_FlushPrefix(t *testing.T) {
+	l1, l2 := NewInMemoryStore(time.Hour), NewInMemoryStore(time.Hour)
+	store := NewTieredStore(l1, l2, time.Minute)
+	store.Set("page:a", 1, DEFAULT)
+	store.Set("other", 2, DEFAULT)
+
+	if err := store.FlushPrefix("page:"); err != nil {
+		t.Fatalf("Unexpected error flushing: %s", err)
+	}
+	var value int
+	if err := l1.Get("page:a", &value); err != ErrCacheMiss {
+		t.Errorf("Expected the l1 copy to be flushed, got %d, %v", value, err)
+	}
+	if err := l2.Get("page:a", &value); err != ErrCacheMiss {
+		t.Errorf("Expected the l2 entry to be flushed, got %d, %v", value, err)
+	}
+	if err := store.Get("other", &value); err != nil || value != 2 {
+		t.Errorf("Expected the other key to be kept, got %d, %v", value, err)
+	}
+
+	if err := NewTieredStore(l1, failingStore{}, 0).FlushPrefix("page:"); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport for an l2 without prefixes, got: %v", err)
+	}
+}
+
+func TestTieredCache_InvalidateTag(t *testing.T) {
+	l1 := NewInMemoryStore(time.Hour)
+	store := NewTieredStore(l1, NewInMemoryStore(time.Hour), time.Minute)
+	var errs []error
+	calls := 0
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(store, time.Minute, Options{
+		OnError: func(err error) { errs = append(errs, err) },
+	}, func(c *gin.Context) {
+		calls++
+		c.Set(CACHE_TAGS_KEY, []string{"product:42"})
+		c.String(http.StatusOK, fmt.Sprint(calls))
+	}))
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+	expectBody(t, performRequest(r, "GET", "/page"), "1")
+
+	if err := InvalidateTag(store, "product:42"); err != nil {
+		t.Errorf("Unexpected error invalidating: %s", err)
+	}
+	expectBody(t, performRequest(r, "GET", "/page"), "2")
+	if len(errs) != 0 {
+		t.Errorf("Expected the page to be tagged without errors, got %v", errs)
+	}
+}
+
+func TestTieredCache_Context(t *testing.T) {
+	l2 := blockingStore{contextStore{NewInMemoryStore(time.Minute)}}
+	s := withContext(NewTieredStore(NewInMemoryStore(time.Minute), l2, time.Second))
+	expectDeadline(t, "GetContext", func(ctx context.Context) error {
+		var value string
+		return s.GetContext(ctx, "key", &value)
+	})
+	expectDeadline(t, "SetContext", func(ctx context.Context) error {
+		return s.SetContext(ctx, "key", "value", DEFAULT)
+	})
+}
@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"time"
+)
+
+// TTLStore is implemented by stores able to tell how long an entry has left.
+type TTLStore interface {
+	// TTL returns the remaining lifetime of key, FOREVER if it never
+	// expires, or ErrCacheMiss if it isn't in the store.
+	TTL(key string) (time.Duration, error)
+}
+
+// ttlStore returns the TTLStore behind store, if any.
+func ttlStore(store CacheStore) (TTLStore, bool) {
+	if s, ok := store.(contextStore); ok {
+		store = s.CacheStore
+	}
+	s, ok := store.(TTLStore)
+	return s, ok
+}
+
+// remainingTTL returns how long the cached response stored at key stays
+// fresh at now, or 0 if the store can't tell.
+func remainingTTL(store CacheStore, key string, cache *ResponseCache, now time.Time) time.Duration {
+	if !cache.FreshUntil.IsZero() {
+		// The store keeps the response past its freshness.
+		if ttl := cache.FreshUntil.Sub(now); ttl > 0 {
+			return ttl
+		}
+		return 0
+	}
+	s, ok := ttlStore(store)
+	if !ok {
+		return 0
+	}
+	ttl, err := s.TTL(key)
+	if err != nil || ttl < 0 {
+		return 0
+	}
+	return ttl
+}
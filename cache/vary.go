@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Responses declaring a Vary header are stored in two parts: the page key
+// holds an index entry listing the varied request headers, and the response
+// itself is stored under a variant key derived from the page key and the
+// values of those headers in the request. Since the varied headers are only
+// known once a response has been produced, lookups first fetch the page key
+// and, when it turns out to be an index, fetch the variant for the request.
+
+// varyHeaders returns the request header names listed by the Vary response
+// headers, canonicalized, deduplicated and sorted.
+func varyHeaders(header http.Header) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, value := range header["Vary"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name)); name != "" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// varyKey returns the key of the variant of the page stored at key that
+// matches the request. The variant is the page key followed by the escaped
+// query string of the varied headers, in name order, with their normalized
+// values, e.g. "Accept-Encoding=deflate%2Cgzip".
+func varyKey(key string, names []string, r *http.Request, options Options) string {
+	values := url.Values{}
+	for _, name := range names {
+		values.Set(name, varyValue(name, r.Header[name]))
+	}
+	return pageEscape(key, values.Encode(), options)
+}
+
+// varyValue normalizes the values of a request header, so equivalent requests
+// share a variant: the comma separated elements are trimmed and sorted, and
+// lower cased for the content negotiation headers, whose elements are case
+// insensitive.
+func varyValue(name string, vals []string) string {
+	var elements []string
+	for _, value := range vals {
+		for _, element := range strings.Split(value, ",") {
+			if element = strings.TrimSpace(element); element != "" {
+				if strings.HasPrefix(name, "Accept") {
+					element = strings.ToLower(element)
+				}
+				elements = append(elements, element)
+			}
+		}
+	}
+	sort.Strings(elements)
+	return strings.Join(elements, ",")
+}
+
+// lookupCache fetches the cached response for the request stored at key,
+// resolving Vary index entries to the matching variant.
+func lookupCache(store ContextCacheStore, key string, r *http.Request, cache *ResponseCache, options Options) error {
+	if err := store.GetContext(r.Context(), key, cache); err != nil {
+		return err
+	}
+	if len(cache.Vary) == 0 {
+		return nil
+	}
+	names := cache.Vary
+	*cache = ResponseCache{}
+	return store.GetContext(r.Context(), varyKey(key, names, r, options), cache)
+}
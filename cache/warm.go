@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"net/http"
+	"time"
+)
+
+// Warm stores a page for u, e.g. "/products/42", as if the handler had
+// answered a request with method with status, header and body, so the
+// matching requests are hits from the start. The page goes through the same
+// rules as the responses of the middlewares with default options: it returns
+// ErrNotStored if one of them, e.g. a no-store Cache-Control header or a non
+// cacheable status, prevents storing it. The page is stored whether or not
+// it is already, see Refresh to only update existing pages.
+func Warm(store CacheStore, method, u string, status int, header http.Header, body []byte, expire time.Duration) error {
+	return WarmWithOptions(store, method, u, status, header, body, expire, Options{})
+}
+
+// WarmWithOptions is like Warm for the pages served by the middlewares
+// configured with options. It returns ErrNotSupport if method isn't cached,
+// or is served from the pages of another method, as HEAD is from the GET
+// pages unless SeparateHead is set.
+func WarmWithOptions(store CacheStore, method, u string, status int, header http.Header, body []byte, expire time.Duration, options Options) error {
+	return warm(store, method, u, status, header, body, expire, options, false)
+}
+
+// Refresh is like Warm, only replacing a page still in the store: it returns
+// ErrNotStored if the page isn't cached, e.g. because it was invalidated, so
+// a background refresher doesn't bring it back.
+func Refresh(store CacheStore, method, u string, status int, header http.Header, body []byte, expire time.Duration) error {
+	return RefreshWithOptions(store, method, u, status, header, body, expire, Options{})
+}
+
+// RefreshWithOptions is like Refresh for the pages served by the
+// middlewares configured with options.
+func RefreshWithOptions(store CacheStore, method, u string, status int, header http.Header, body []byte, expire time.Duration, options Options) error {
+	return warm(store, method, u, status, header, body, expire, options, true)
+}
+
+// warm stores the page, only if it is already in the store when replace is
+// set.
+func warm(store CacheStore, method, u string, status int, header http.Header, body []byte, expire time.Duration, options Options, replace bool) error {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	options = applyDefaults(options)
+	if !cacheableMethod(req.Method, options) || keyMethod(req.Method, options) != req.Method {
+		// A HEAD page would replace the GET page under the same key.
+		return ErrNotSupport
+	}
+
+	var saveErr error
+	onError := options.OnError
+	options.OnError = func(err error) {
+		saveErr = err
+		onError(err)
+	}
+	metrics := &warmMetrics{Metrics: options.Metrics}
+	options.Metrics = metrics
+	// The page is written straight to the store.
+	options.Buffered = false
+
+	discard := &discardWriter{header: http.Header{}, status: http.StatusOK}
+	x := &exchange{w: discard, r: req, c: newContext(discard, req)}
+	writer := newCachedWriter(withContext(store), expire, discard, pageKey(x, options), x, options)
+	writer.replace = replace
+	for k, vals := range header {
+		writer.Header()[k] = append([]string(nil), vals...)
+	}
+	writer.WriteHeader(status)
+	writer.Write(body)
+	writer.finalize()
+	if saveErr != nil {
+		return saveErr
+	}
+	if !metrics.stored {
+		return ErrNotStored
+	}
+	return nil
+}
+
+// warmMetrics records whether the warmed page was stored.
+type warmMetrics struct {
+	Metrics
+	stored bool
+}
+
+func (m *warmMetrics) Store(key string) {
+	m.stored = true
+	m.Metrics.Store(key)
+}
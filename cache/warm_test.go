@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWarm(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewInMemoryStore(time.Minute)
+	header := http.Header{"Content-Type": {"text/plain"}, "X-Warm": {"yes"}}
+	if err := Warm(store, "GET", "/page?id=1", http.StatusOK, header, []byte("warm"), time.Minute); err != nil {
+		t.Fatalf("Error warming the page: %s", err)
+	}
+
+	r, calls := newCachePageRouter(store, Options{}, http.StatusOK)
+	w := performRequest(r, "GET", "/page?id=1")
+	if *calls != 0 || w.Body.String() != "warm" || w.Header().Get("X-Warm") != "yes" {
+		t.Errorf("Expected the warmed page to be served, got %q after %d calls", w.Body.String(), *calls)
+	}
+}
+
+func TestWarm_NotStored(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	if err := Warm(store, "GET", "/page", http.StatusInternalServerError, nil, []byte("error"), time.Minute); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored for a non cacheable status, got %v", err)
+	}
+	header := http.Header{"Cache-Control": {"no-store"}}
+	if err := Warm(store, "GET", "/page", http.StatusOK, header, []byte("page"), time.Minute); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored for a no-store response, got %v", err)
+	}
+	if err := Warm(store, "POST", "/page", http.StatusOK, nil, []byte("page"), time.Minute); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport for a method that isn't cached, got %v", err)
+	}
+	if err := Warm(failingStore{}, "GET", "/page", http.StatusOK, nil, []byte("page"), time.Minute); !errors.Is(err, errStoreDown) {
+		t.Errorf("Expected the store error, got %v", err)
+	}
+}
+
+func TestWarm_Head(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	if err := Warm(store, "GET", "/page", http.StatusOK, nil, []byte("page"), time.Minute); err != nil {
+		t.Fatalf("Error warming the page: %s", err)
+	}
+	if err := Warm(store, "HEAD", "/page", http.StatusOK, nil, nil, time.Minute); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport for HEAD, served from the GET page, got %v", err)
+	}
+	expectBody(t, performRequest(newCountingRouter(store, Options{}), "GET", "/page"), "page")
+
+	options := Options{SeparateHead: true}
+	if err := WarmWithOptions(store, "HEAD", "/page", http.StatusOK, nil, nil, time.Minute, options); err != nil {
+		t.Errorf("Expected HEAD pages to be warmed with SeparateHead, got %v", err)
+	}
+	expectBody(t, performRequest(newCountingRouter(store, options), "GET", "/page"), "page")
+}
+
+func TestWarmWithOptions_Key(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	options := Options{KeyHost: true, NormalizeQuery: true}
+	if err := WarmWithOptions(store, "GET", "http://Example.com/page?b=2&a=1", http.StatusOK, nil, []byte("warm"), time.Minute, options); err != nil {
+		t.Fatalf("Error warming the page: %s", err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+		c.String(http.StatusOK, "origin")
+	}))
+	req, _ := http.NewRequest("GET", "/page?a=1&b=2", nil)
+	req.Host = "example.com"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	expectBody(t, w, "warm")
+}
+
+func TestWarmWithOptions_ClientIP(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	// The warmed request has no peer, so the page is for an empty address.
+	options := Options{KeyFunc: func(c *gin.Context) string {
+		return c.ClientIP() + c.Request.URL.Path
+	}}
+	if err := WarmWithOptions(store, "GET", "/page", http.StatusOK, nil, []byte("warm"), time.Minute, options); err != nil {
+		t.Fatalf("Error warming the page: %s", err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/page", CachePageWithOptions(store, time.Minute, options, func(c *gin.Context) {
+		c.String(http.StatusOK, "origin")
+	}))
+	req, _ := http.NewRequest("GET", "/page", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	expectBody(t, w, "warm")
+}
+
+func TestRefresh(t *testing.T) {
+	store := NewInMemoryStore(time.Minute)
+	if err := Refresh(store, "GET", "/page", http.StatusOK, nil, []byte("new"), time.Minute); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored refreshing a page that isn't cached, got %v", err)
+	}
+	var cache ResponseCache
+	if err := store.Get(urlEscape(PageCachePrefix, "/page"), &cache); err != ErrCacheMiss {
+		t.Errorf("Expected the refresh not to create the page, got %v", err)
+	}
+
+	Warm(store, "GET", "/page", http.StatusOK, nil, []byte("old"), time.Minute)
+	if err := Refresh(store, "GET", "/page", http.StatusOK, nil, []byte("new"), time.Minute); err != nil {
+		t.Errorf("Unexpected error refreshing a cached page: %s", err)
+	}
+	if err := store.Get(urlEscape(PageCachePrefix, "/page"), &cache); err != nil || string(cache.Data) != "new" {
+		t.Errorf("Expected the page to be replaced, got %q: %v", cache.Data, err)
+	}
+}
@@ -0,0 +1,27 @@
+package cache
+
+// writeBehind bounds the number of responses of a middleware being stored in
+// the background.
+type writeBehind chan struct{}
+
+// newWriteBehind returns nil when n isn't positive, disabling the mode.
+func newWriteBehind(n int) writeBehind {
+	if n <= 0 {
+		return nil
+	}
+	return make(writeBehind, n)
+}
+
+// run calls write in the background, unless the maximum number of writes is
+// already pending, in which case it calls it right away.
+func (q writeBehind) run(write func()) {
+	select {
+	case q <- struct{}{}:
+		go func() {
+			defer func() { <-q }()
+			write()
+		}()
+	default:
+		write()
+	}
+}
@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gatedStore blocks every Set until the gate is opened.
+type gatedStore struct {
+	CacheStore
+	gate chan struct{}
+}
+
+func (s *gatedStore) Set(key string, value interface{}, expire time.Duration) error {
+	<-s.gate
+	return s.CacheStore.Set(key, value, expire)
+}
+
+func TestCachePage_WriteBehind(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := &gatedStore{NewInMemoryStore(time.Minute), make(chan struct{})}
+	r := gin.New()
+	r.GET("/page/:id", CachePageWithOptions(store, time.Minute, Options{WriteBehind: 1}, func(c *gin.Context) {
+		c.String(http.StatusOK, c.Param("id"))
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		expectBody(t, performRequest(r, "GET", "/page/1"), "1")
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the response not to wait for the store")
+	}
+
+	// The write of /page/1 is pending, so the next one is synchronous.
+	second := make(chan struct{})
+	go func() {
+		defer close(second)
+		performRequest(r, "GET", "/page/2")
+	}()
+	select {
+	case <-second:
+		t.Fatal("Expected the response to wait for the store once the workers are busy")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(store.gate)
+	<-second
+	for _, id := range []string{"1", "2"} {
+		waitForBody(t, store, urlEscape(PageCachePrefix, "/page/"+id), id)
+	}
+}
+
+func TestWriteBehind_Bounded(t *testing.T) {
+	q := newWriteBehind(2)
+	var mu sync.Mutex
+	running, max := 0, 0
+	gate := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		q.run(func() {
+			defer wg.Done()
+			mu.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			mu.Unlock()
+			<-gate
+		})
+	}
+	synchronous := false
+	q.run(func() { synchronous = true })
+	if !synchronous {
+		t.Errorf("Expected the write to run right away when the workers are busy")
+	}
+	close(gate)
+	wg.Wait()
+	if max > 2 {
+		t.Errorf("Expected at most 2 background writes, got %d", max)
+	}
+	if newWriteBehind(0) != nil {
+		t.Errorf("Expected write-behind to be disabled by default")
+	}
+}